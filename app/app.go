@@ -40,6 +40,9 @@ const (
 	Store = "var"
 
 	InitState = "init.state"
+	// SymbolFileName is the name of the symbol file in the external data
+	// directory of a game
+	SymbolFileName = "symbols"
 )
 
 func PathFor(kind string, path ...string) string {
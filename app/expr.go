@@ -0,0 +1,349 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+)
+
+// Expr is a compiled expression that returns its value when called.
+type Expr func() int
+
+type exprParser struct {
+	tokens []string
+	pos    int
+	lookup func(string) (Expr, bool)
+	mem    memory.Memory
+}
+
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// operators that are two characters long must come first
+var opTokens = []string{
+	"||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~",
+	"(", ")", "[", "]",
+}
+
+// CompileExpr compiles the expression in str. Names are resolved with
+// lookup and memory references are loaded from mem. Expressions are
+// written with the following:
+//
+//	ab $ab 0xab   hexadecimal numbers
+//	+12           decimal number
+//	HL            value of a register (names are upper case)
+//	main          address of a symbol
+//	(HL)          value in memory at an address
+//	[A+1]*2       grouping
+//
+// The operators, from lowest to highest precedence, are:
+//
+//	||
+//	&&
+//	|
+//	^
+//	&
+//	== !=
+//	< <= > >=
+//	<< >>
+//	+ -
+//	* / %
+//	- ! ~         (unary)
+//
+// Comparisons and logical operators evaluate to 1 when true and 0 when
+// false. A number that is also the name of a register or symbol, like
+// "BC", must be prefixed with "$" or "0x".
+func CompileExpr(str string, lookup func(string) (Expr, bool), mem memory.Memory) (Expr, error) {
+	tokens, err := tokenize(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{tokens: tokens, lookup: lookup, mem: mem}
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected: %v", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func tokenize(str string) ([]string, error) {
+	tokens := make([]string, 0, 0)
+	for i := 0; i < len(str); {
+		ch := str[i]
+		if ch == ' ' || ch == '\t' {
+			i++
+			continue
+		}
+		if isWordChar(ch) || ch == '$' {
+			j := i + 1
+			for j < len(str) && isWordChar(str[j]) {
+				j++
+			}
+			tokens = append(tokens, str[i:j])
+			i = j
+			continue
+		}
+		op := ""
+		for _, t := range opTokens {
+			if strings.HasPrefix(str[i:], t) {
+				op = t
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("unexpected: %c", ch)
+		}
+		tokens = append(tokens, op)
+		i += len(op)
+	}
+	return tokens, nil
+}
+
+func isWordChar(ch byte) bool {
+	return ch >= '0' && ch <= '9' ||
+		ch >= 'a' && ch <= 'z' ||
+		ch >= 'A' && ch <= 'Z' ||
+		ch == '_' || ch == '.'
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("expected %v", t)
+		}
+		return fmt.Errorf("expected %v, got %v", t, got)
+	}
+	return nil
+}
+
+func (p *exprParser) binary(level int) (Expr, error) {
+	if level == len(binaryOps) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if !isOp(op, binaryOps[level]) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr(op, left, right)
+	}
+}
+
+func isOp(t string, ops []string) bool {
+	for _, op := range ops {
+		if t == op {
+			return true
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func binaryExpr(op string, a Expr, b Expr) Expr {
+	switch op {
+	case "||":
+		return func() int { return boolValue(a() != 0 || b() != 0) }
+	case "&&":
+		return func() int { return boolValue(a() != 0 && b() != 0) }
+	case "|":
+		return func() int { return a() | b() }
+	case "^":
+		return func() int { return a() ^ b() }
+	case "&":
+		return func() int { return a() & b() }
+	case "==":
+		return func() int { return boolValue(a() == b()) }
+	case "!=":
+		return func() int { return boolValue(a() != b()) }
+	case "<":
+		return func() int { return boolValue(a() < b()) }
+	case "<=":
+		return func() int { return boolValue(a() <= b()) }
+	case ">":
+		return func() int { return boolValue(a() > b()) }
+	case ">=":
+		return func() int { return boolValue(a() >= b()) }
+	case "<<":
+		return func() int { return a() << uint(b()) }
+	case ">>":
+		return func() int { return a() >> uint(b()) }
+	case "+":
+		return func() int { return a() + b() }
+	case "-":
+		return func() int { return a() - b() }
+	case "*":
+		return func() int { return a() * b() }
+	case "/":
+		return func() int {
+			if d := b(); d != 0 {
+				return a() / d
+			}
+			return 0
+		}
+	case "%":
+		return func() int {
+			if d := b(); d != 0 {
+				return a() % d
+			}
+			return 0
+		}
+	}
+	panic("unknown operator: " + op)
+}
+
+func (p *exprParser) unary() (Expr, error) {
+	switch p.peek() {
+	case "-", "!", "~":
+		op := p.next()
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "-":
+			return func() int { return -e() }, nil
+		case "!":
+			return func() int { return boolValue(e() == 0) }, nil
+		default:
+			return func() int { return ^e() }, nil
+		}
+	case "+":
+		// A plus sign before a number makes it decimal
+		p.next()
+		t := p.next()
+		v, err := strconv.ParseUint(t, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decimal number: %v", t)
+		}
+		return constExpr(int(v)), nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (Expr, error) {
+	t := p.next()
+	switch t {
+	case "":
+		return nil, errors.New("unexpected end of expression")
+	case "(":
+		e, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		mem := p.mem
+		return func() int { return int(mem.Load(uint16(e()))) }, nil
+	case "[":
+		e, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	if strings.HasPrefix(t, "$") {
+		return parseHex(t[1:], t)
+	}
+	if p.lookup != nil {
+		if e, ok := p.lookup(t); ok {
+			return e, nil
+		}
+	}
+	return parseHex(strings.TrimPrefix(t, "0x"), t)
+}
+
+func parseHex(digits string, t string) (Expr, error) {
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unknown value: %v", t)
+	}
+	return constExpr(int(v)), nil
+}
+
+func constExpr(v int) Expr {
+	return func() int { return v }
+}
+
+// registerLookup resolves names to the value of registers.
+func registerLookup(regs map[string]proc.Value) func(string) (Expr, bool) {
+	return func(name string) (Expr, bool) {
+		reg, ok := regs[name]
+		if !ok {
+			return nil, false
+		}
+		switch get := reg.Get.(type) {
+		case func() uint8:
+			return func() int { return int(get()) }, true
+		case func() uint16:
+			return func() int { return int(get()) }, true
+		}
+		return nil, false
+	}
+}
+
+// symbolLookup resolves names to the addresses of symbols.
+func symbolLookup(c *proc.CodeInfo) func(string) (Expr, bool) {
+	return func(name string) (Expr, bool) {
+		if c == nil {
+			return nil, false
+		}
+		addr, ok := c.Symbols[name]
+		if !ok {
+			return nil, false
+		}
+		return constExpr(int(addr)), true
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestExpr(t *testing.T) {
+	mem := memory.NewRAM(0x100)
+	mem.Store(0x20, 0x10)
+	regs := map[string]proc.Value{
+		"A":  proc.Value{Get: func() uint8 { return 3 }},
+		"HL": proc.Value{Get: func() uint16 { return 0x20 }},
+	}
+	tests := []struct {
+		expr  string
+		value int
+	}{
+		{"10", 0x10},
+		{"$10", 0x10},
+		{"0x10", 0x10},
+		{"+10", 10},
+		{"ab", 0xab},
+		{"A", 3},
+		{"$A", 0xa},
+		{"HL", 0x20},
+		{"(HL)", 0x10},
+		{"(HL+1)", 0},
+		{"A==3 && (HL)==$10", 1},
+		{"A==3 && (HL)==$11", 0},
+		{"A==4 || HL==20", 1},
+		{"1+2*3", 7},
+		{"[1+2]*3", 9},
+		{"-1+2", 1},
+		{"!0", 1},
+		{"~0&ff", 0xff},
+		{"1<<4|1", 0x11},
+		{"7/2 + 7%2", 4},
+		{"1/0", 0},
+		{"3 > 2 == 1", 1},
+		{"A != 3", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			e, err := CompileExpr(test.expr, registerLookup(regs), mem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			With(t).Expect(e()).ToBe(test.value)
+		})
+	}
+}
+
+func TestExprError(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "empty expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1", "expected )"},
+		{"[1)", "expected ], got )"},
+		{"1 2", "unexpected: 2"},
+		{"xyz", "unknown value: xyz"},
+		{"+A", "invalid decimal number: A"},
+		{"1 # 2", "unexpected: #"},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			_, err := CompileExpr(test.expr, nil, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			With(t).Expect(err.Error()).ToBe(test.err)
+		})
+	}
+}
@@ -2,6 +2,9 @@ package app
 
 import (
 	"fmt"
+	"image"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blackchip-org/pac8/pkg/audio"
@@ -9,16 +12,24 @@ import (
 	"github.com/blackchip-org/pac8/pkg/memory"
 	"github.com/blackchip-org/pac8/pkg/proc"
 	"github.com/blackchip-org/pac8/pkg/util/state"
-	"github.com/blackchip-org/pac8/pkg/video"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// Instructions of the fixture CPU that call and return. The address of
+// the return is kept in the CPU and not on a stack in memory.
+const (
+	fixtureCall = 0x2c // i2c $nnnn
+	fixtureRet  = 0x0c // i0c
+)
+
 type fixtureCPU struct {
 	A      uint8
 	BC     uint16
+	cycles int
 	mem    memory.Memory
 	cursor *memory.Cursor
 	info   proc.Info
+	calls  []proc.Frame
 }
 
 func newFixtureCPU(mem memory.Memory) *fixtureCPU {
@@ -32,18 +43,42 @@ func newFixtureCPU(mem memory.Memory) *fixtureCPU {
 		CodeFormatter:   fixtureFormatter(),
 		NewDisassembler: NewDisassembler,
 		Registers:       c.registers(),
+		Assemble:        fixtureAssemble,
 	}
 	return c
 }
 
 func (c *fixtureCPU) Next() {
+	pc := c.cursor.Pos
 	opcode := c.cursor.Fetch()
 	args := opcode >> 4
+	var arg uint16
 	if args == 1 {
-		c.cursor.Fetch()
+		arg = uint16(c.cursor.Fetch())
 	} else if args == 2 {
-		c.cursor.FetchLE()
+		arg = c.cursor.FetchLE()
+	}
+	switch opcode {
+	case fixtureCall:
+		c.calls = append(c.calls, proc.Frame{
+			Kind:   "call",
+			From:   pc,
+			Target: arg,
+			Return: c.cursor.Pos,
+			SP:     uint16(0x1000 - 2*(len(c.calls)+1)),
+		})
+		c.cursor.Pos = arg
+	case fixtureRet:
+		if n := len(c.calls); n > 0 {
+			c.cursor.Pos = c.calls[n-1].Return
+			c.calls = c.calls[:n-1]
+		}
 	}
+	c.cycles++
+}
+
+func (c *fixtureCPU) CallStack() []proc.Frame {
+	return append([]proc.Frame{}, c.calls...)
 }
 
 func (c *fixtureCPU) PC() uint16 {
@@ -58,6 +93,10 @@ func (c *fixtureCPU) Ready() bool {
 	return true
 }
 
+func (c *fixtureCPU) Cycles() int {
+	return c.cycles
+}
+
 func (c *fixtureCPU) Info() proc.Info {
 	return c.info
 }
@@ -112,6 +151,33 @@ func fixtureReader(e proc.Eval) proc.Statement {
 	return *e.Statement
 }
 
+func fixtureAssemble(src string, addr uint16, eval func(string) (int, error)) ([]uint8, error) {
+	fields := strings.Fields(src)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "i") {
+		return nil, fmt.Errorf("unknown instruction: %v", src)
+	}
+	opcode, err := strconv.ParseUint(fields[0][1:], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("unknown instruction: %v", src)
+	}
+	argN := int(opcode >> 4)
+	if argN > 2 || len(fields) != 1 && argN == 0 || len(fields) != 2 && argN > 0 {
+		return nil, fmt.Errorf("invalid operands: %v", src)
+	}
+	out := []uint8{uint8(opcode)}
+	if argN > 0 {
+		v, err := eval(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, uint8(v))
+		if argN == 2 {
+			out = append(out, uint8(v>>8))
+		}
+	}
+	return out, nil
+}
+
 func fixtureFormatter() proc.CodeFormatter {
 	options := proc.FormatOptions{
 		BytesFormat: "%-8s",
@@ -140,9 +206,11 @@ func (f fixtureSys) Spec() *machine.Spec {
 		}
 	}
 	return &machine.Spec{
+		Name:         "fixture",
 		CPU:          []proc.CPU{f.cpu},
 		Mem:          []memory.Memory{f.mem},
-		Display:      video.NullDisplay{},
+		RAM:          []memory.Memory{f.mem},
+		Display:      fixtureDisplay{image.NewRGBA(image.Rect(0, 0, 4, 3))},
 		Audio:        audio.NullAudio{},
 		TickCallback: callback,
 		TickRate:     1 * time.Millisecond,
@@ -150,13 +218,27 @@ func (f fixtureSys) Spec() *machine.Spec {
 	}
 }
 
-func (f fixtureSys) Save(*state.Encoder) {}
+type fixtureDisplay struct {
+	img *image.RGBA
+}
+
+func (d fixtureDisplay) Render() {}
 
-func (f fixtureSys) Restore(*state.Decoder) {}
+func (d fixtureDisplay) Frame() *image.RGBA {
+	return d.img
+}
+
+func (f fixtureSys) Save(w *state.Writer) {
+	f.mem.Save(w.Section("mem"))
+}
+
+func (f fixtureSys) Restore(r *state.Reader) {
+	f.mem.Restore(r.Section("mem"))
+}
 
 func newFixtureCab(renderer *sdl.Renderer) machine.System {
 	sys := &fixtureSys{}
-	sys.mem = memory.NewRAM(0x1000)
+	sys.mem = memory.NewSpy(memory.NewRAM(0x1000))
 	sys.cpu = newFixtureCPU(sys.mem)
 	return sys
 }
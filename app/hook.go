@@ -0,0 +1,161 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+	"github.com/blackchip-org/pac8/pkg/machine"
+)
+
+// hookCmds can be used by a hook. The others need the machine goroutine,
+// which is the one running the hook.
+var hookCmds = map[string]bool{
+	CmdAssert:      true,
+	CmdDisassemble: true,
+	CmdFill:        true,
+	CmdHalt:        true,
+	CmdIf:          true,
+	CmdInput:       true,
+	CmdMemory:      true,
+	CmdNext:        true,
+	CmdPokePeek:    true,
+	CmdRegisters:   true,
+}
+
+// hookMonitor returns a copy of the monitor that runs the commands of a
+// hook on the machine goroutine. The hook uses the core selected when it
+// was added.
+func (m *Monitor) hookMonitor() *Monitor {
+	hm := *m
+	hm.parent = m
+	hm.rl = nil
+	hm.dasm = m.newDisassembler(m.selectedCore)
+	return &hm
+}
+
+// onMach calls f from the machine goroutine. A hook is already there.
+func (m *Monitor) onMach(f func()) {
+	if m.parent != nil {
+		f()
+		return
+	}
+	m.mach.Do(f)
+}
+
+func (m *Monitor) on(args []string) error {
+	if len(args) == 0 {
+		return m.hookList()
+	}
+	if len(args) == 1 && args[0] == "clear" {
+		m.mach.Do(func() {
+			for id := range m.hooks {
+				m.mach.RemoveHook(id)
+			}
+		})
+		m.hooks = make(map[int]string)
+		return nil
+	}
+	if len(args) == 2 && args[1] == "off" {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid hook: %v", args[0])
+		}
+		if _, ok := m.hooks[id]; !ok {
+			return fmt.Errorf("no such hook: %v", id)
+		}
+		m.mach.Do(func() { err = m.mach.RemoveHook(id) })
+		delete(m.hooks, id)
+		return err
+	}
+
+	h := machine.Hook{Core: m.selectedCore}
+	desc := args[0]
+	switch args[0] {
+	case "frame":
+		h.Type = machine.FrameHook
+		args = args[1:]
+	case "exec", "write":
+		if err := checkLen(args, 2, maxArgs); err != nil {
+			return err
+		}
+		h.Type = machine.ExecHook
+		if args[0] == "write" {
+			h.Type = machine.WriteHook
+		}
+		addr, err := m.evalAddress(args[1])
+		if err != nil {
+			return err
+		}
+		h.Addr = addr
+		desc = fmt.Sprintf("%v %v", args[0], m.addressName(addr))
+		args = args[2:]
+	default:
+		return fmt.Errorf("invalid event: %v", args[0])
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no commands")
+	}
+	body := strings.Join(args, " ")
+
+	var id int
+	hm := m.hookMonitor()
+	h.Func = func(value uint8) bool {
+		hm.hookBreak = false
+		if err := hm.runMacro(body, []string{fmt.Sprintf("%02x", value)}); err != nil {
+			hm.out.Printf("hook %v: %v", id, err)
+			return true
+		}
+		return hm.hookBreak
+	}
+	var err error
+	m.mach.Do(func() { id, err = m.mach.AddHook(h) })
+	if err != nil {
+		return err
+	}
+	m.hooks[id] = desc + ": " + body
+	return nil
+}
+
+func (m *Monitor) hookList() error {
+	if len(m.hooks) == 0 {
+		m.out.Println("no hooks")
+		return nil
+	}
+	ids := make([]int, 0, len(m.hooks))
+	for id := range m.hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		m.out.Printf("%v %v", id, m.hooks[id])
+	}
+	return nil
+}
+
+func (m *Monitor) ifCmd(args []string) error {
+	if err := checkLen(args, 2, maxArgs); err != nil {
+		return err
+	}
+	e, err := m.compile(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid expression: %v: %v", args[0], err)
+	}
+	var v int
+	m.onMach(func() { v = e() })
+	if v == 0 {
+		return nil
+	}
+	return m.run(strings.Join(args[1:], " "))
+}
+
+func (m *Monitor) input(args []string) error {
+	in, err := input.ParseNames(args)
+	if err != nil {
+		return err
+	}
+	m.onMach(func() { m.mach.In = in })
+	return nil
+}
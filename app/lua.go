@@ -0,0 +1,41 @@
+package app
+
+import (
+	"github.com/blackchip-org/pac8/pkg/lua"
+)
+
+// luaCmd loads a Lua script in place of the one that is loaded, if any.
+func (m *Monitor) luaCmd(args []string) error {
+	if err := checkLen(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if m.luaPath == "" {
+			m.out.Println("no lua script")
+		} else {
+			m.out.Println(m.luaPath)
+		}
+		return nil
+	}
+	var err error
+	m.mach.Do(func() {
+		if m.luaScript != nil {
+			m.luaScript.Close()
+			m.luaScript, m.luaPath = nil, ""
+		}
+		if args[0] == "off" {
+			return
+		}
+		var s *lua.Script
+		if s, err = lua.New(m.mach); err != nil {
+			return
+		}
+		s.Print = func(str string) { m.out.Println(str) }
+		if err = s.DoFile(args[0]); err != nil {
+			s.Close()
+			return
+		}
+		m.luaScript, m.luaPath = s, args[0]
+	})
+	return err
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/blackchip-org/pac8/pkg/lua"
 	"github.com/blackchip-org/pac8/pkg/machine"
 	"github.com/blackchip-org/pac8/pkg/memory"
 	"github.com/blackchip-org/pac8/pkg/proc"
@@ -21,21 +22,44 @@ import (
 )
 
 const (
+	CmdAssemble    = "a"
+	CmdAssert      = "assert"
+	CmdBacktrace   = "bt"
 	CmdBreakpoint  = "b"
 	CmdCore        = "c"
+	CmdCycles      = "cy"
 	CmdDisassemble = "d"
 	CmdFill        = "f"
+	CmdFrames      = "fr"
 	CmdGo          = "g"
+	CmdGoBack      = "gb"
 	CmdHalt        = "h"
 	CmdHelp        = "?"
+	CmdIf          = "if"
+	CmdInput       = "in"
+	CmdLua         = "lua"
+	CmdMacro       = "macro"
 	CmdMemory      = "m"
+	CmdMovie       = "mov"
 	CmdNext        = "n"
+	CmdOn          = "on"
 	CmdPokePeek    = "p"
+	CmdProfile     = "prof"
+	CmdRecord      = "rec"
 	CmdRegisters   = "r"
+	CmdRewind      = "rewind"
 	CmdStep        = "s"
+	CmdStepBack    = "sb"
+	CmdStepOut     = "sout"
+	CmdStepOver    = "sov"
 	CmdRestore     = "si"
 	CmdSave        = "so"
+	CmdSlots       = "sl"
+	CmdSource      = "source"
+	CmdScreenshot  = "ss"
 	CmdTrace       = "t"
+	CmdUntil       = "u"
+	CmdWatch       = "w"
 	CmdQuit        = "q"
 	CmdQuitLong    = "quit"
 )
@@ -43,6 +67,7 @@ const (
 const (
 	memPageLen       = 0x100
 	dasmPageLen      = 0x3f
+	profTop          = 10
 	maxArgs          = 0x100
 	SnapshotFileName = "snapshot"
 )
@@ -54,14 +79,27 @@ type Monitor struct {
 	mach         *machine.Mach
 	cpu          proc.CPU
 	mem          memory.Memory
-	breakpoints  map[uint16]struct{}
+	breakpoints  map[uint16]*machine.Breakpoint
+	spy          *memory.Spy
 	in           io.ReadCloser
 	out          *log.Logger
 	rl           *readline.Instance
 	lastCmd      string
 	memPtr       uint16
 	dasmPtr      uint16
+	asmPtr       uint16
+	assembling   bool // lines are instructions for the assembler
 	selectedCore int
+	macros       map[string]string
+	depth        int  // number of scripts and macros being run
+	failures     int  // number of assertions that were false
+	batch        bool // quit ends the script instead of the monitor
+	stopped      chan struct{}
+	hooks        map[int]string // description of each hook by id
+	parent       *Monitor       // monitor that added the hook, if running one
+	hookBreak    bool           // the hook asked for the machine to stop
+	luaScript    *lua.Script    // script loaded with the lua command, if any
+	luaPath      string
 }
 
 func NewMonitor(mach *machine.Mach) *Monitor {
@@ -70,14 +108,27 @@ func NewMonitor(mach *machine.Mach) *Monitor {
 		cpu:         mach.Cores[0].CPU,
 		mem:         mach.Cores[0].Mem,
 		breakpoints: mach.Cores[0].Breakpoints,
+		spy:         mach.Cores[0].Spy,
 		in:          readline.NewCancelableStdin(os.Stdin),
 		out:         log.New(os.Stdout, "", 0),
-		dasm:        mach.Cores[0].CPU.Info().NewDisassembler(mach.Cores[0].Mem),
+		macros:      make(map[string]string),
+		hooks:       make(map[int]string),
+		stopped:     make(chan struct{}, 1),
 	}
+	m.dasm = m.newDisassembler(0)
 	mach.EventCallback = m.handleEvent
 	return m
 }
 
+func (m *Monitor) newDisassembler(core int) *proc.Disassembler {
+	c := m.mach.Cores[core]
+	dasm := c.CPU.Info().NewDisassembler(c.Mem)
+	if dasm != nil {
+		dasm.CodeInfo = m.mach.CodeInfo
+	}
+	return dasm
+}
+
 func (m *Monitor) Run() error {
 	usr, err := user.Current()
 	if err != nil {
@@ -114,74 +165,221 @@ func (m *Monitor) getPrompt() string {
 	if len(m.mach.Cores) > 1 {
 		c = fmt.Sprintf(":%v", m.selectedCore+1)
 	}
+	if m.assembling {
+		return fmt.Sprintf("monitor%v:a $%04x> ", c, m.asmPtr)
+	}
 	return fmt.Sprintf("monitor%v> ", c)
 }
 
+// setPrompt updates the prompt when reading from the terminal.
+func (m *Monitor) setPrompt() {
+	if m.rl != nil {
+		m.rl.SetPrompt(m.getPrompt())
+	}
+}
+
 func (m *Monitor) parse(line string) {
+	if err := m.exec(line); err != nil {
+		m.out.Println(err)
+	}
+}
+
+// exec runs the command in line.
+func (m *Monitor) exec(line string) error {
 	line = strings.TrimSpace(line)
+	if m.assembling {
+		return m.assembleInput(line)
+	}
 	if line == "" {
-		if m.lastCmd != CmdStep && m.lastCmd != CmdGo && m.lastCmd != CmdMemory {
-			return
+		if m.lastCmd != CmdStep && m.lastCmd != CmdStepOver && m.lastCmd != CmdFrames && m.lastCmd != CmdGo && m.lastCmd != CmdMemory {
+			return nil
 		}
 		line = m.lastCmd
 	}
 	fields := strings.Split(line, " ")
 
 	if len(fields) == 0 {
-		return
+		return nil
 	}
 
 	cmd := fields[0]
 	args := fields[1:]
+	if _, macro := m.macros[cmd]; m.parent != nil && !hookCmds[cmd] && !macro {
+		return fmt.Errorf("unable to use in a hook: %v", cmd)
+	}
 	var err error
 	switch cmd {
+	case CmdAssemble:
+		err = m.assemble(args)
+	case CmdAssert:
+		err = m.assert(args)
+	case CmdBacktrace:
+		err = m.backtrace(args)
 	case CmdBreakpoint:
 		err = m.breakpoint(args)
 	case CmdCore:
 		err = m.core(args)
+	case CmdCycles:
+		err = m.cycles(args)
 	case CmdDisassemble:
 		err = m.disassemble(args)
 	case CmdFill:
 		err = m.fill(args)
+	case CmdFrames:
+		err = m.frames(args)
 	case CmdGo:
 		err = m.goCmd(args)
+	case CmdGoBack:
+		err = m.goBack(args)
 	case CmdHalt:
 		err = m.halt(args)
 	case CmdHelp:
 		err = m.help(args)
+	case CmdIf:
+		err = m.ifCmd(args)
+	case CmdInput:
+		err = m.input(args)
+	case CmdLua:
+		err = m.luaCmd(args)
+	case CmdMacro:
+		err = m.macro(args)
 	case CmdMemory:
 		err = m.memory(args, m.mach.CharDecoder)
+	case CmdMovie:
+		err = m.movie(args)
 	case CmdNext:
 		err = m.next(args)
+	case CmdOn:
+		err = m.on(args)
 	case CmdPokePeek:
 		err = m.pokePeek(args)
+	case CmdProfile:
+		err = m.profile(args)
+	case CmdRecord:
+		err = m.record(args)
 	case CmdRegisters:
 		err = m.registers(args)
 	case CmdRestore:
 		err = m.restore(args)
+	case CmdRewind:
+		err = m.rewind(args)
 	case CmdSave:
 		err = m.save(args)
+	case CmdScreenshot:
+		err = m.screenshot(args)
+	case CmdSlots:
+		err = m.slots(args)
+	case CmdSource:
+		err = m.source(args)
 	case CmdStep:
 		err = m.step(args)
+	case CmdStepBack:
+		err = m.stepBack(args)
+	case CmdStepOut:
+		err = m.stepOut(args)
+	case CmdStepOver:
+		err = m.stepOver(args)
 	case CmdTrace:
 		err = m.trace(args)
+	case CmdUntil:
+		err = m.until(args)
+	case CmdWatch:
+		err = m.watch(args)
 	case CmdQuit, CmdQuitLong:
-		m.rl.Close()
+		if m.batch {
+			return errQuit
+		}
+		if m.rl != nil {
+			m.rl.Close()
+		}
 		m.mach.Send(machine.QuitCmd)
 		runtime.Goexit()
 	default:
-		err = fmt.Errorf("unknown command: %v", cmd)
+		if body, ok := m.macros[cmd]; ok {
+			err = m.runMacro(body, args)
+		} else {
+			err = fmt.Errorf("unknown command: %v", cmd)
+		}
+	}
+
+	if err == nil {
+		m.lastCmd = cmd
 	}
+	return err
+}
 
+func (m *Monitor) assemble(args []string) error {
+	if err := checkLen(args, 1, maxArgs); err != nil {
+		return err
+	}
+	if m.cpu.Info().Assemble == nil {
+		return fmt.Errorf("no assembler for this cpu")
+	}
+	var address uint16
+	var err error
+	m.mach.Do(func() { address, err = m.evalAddress(args[0]) })
 	if err != nil {
-		m.out.Println(err)
+		return err
+	}
+	m.asmPtr = address
+	if len(args) > 1 {
+		return m.assembleLine(strings.Join(args[1:], " "))
+	}
+	m.assembling = true
+	m.setPrompt()
+	return nil
+}
+
+// assembleInput handles a line entered while in assembly mode. An empty
+// line or a single period leaves assembly mode.
+func (m *Monitor) assembleInput(line string) error {
+	var err error
+	if line == "" || line == "." {
+		m.assembling = false
 	} else {
-		m.lastCmd = cmd
+		err = m.assembleLine(line)
+	}
+	m.setPrompt()
+	return err
+}
+
+func (m *Monitor) assembleLine(src string) error {
+	var err error
+	m.mach.Do(func() { err = m.doAssembleLine(src) })
+	return err
+}
+
+func (m *Monitor) doAssembleLine(src string) error {
+	eval := func(str string) (int, error) {
+		e, err := m.compile(str)
+		if err != nil {
+			return 0, err
+		}
+		return e(), nil
 	}
+	bytes, err := m.cpu.Info().Assemble(src, m.asmPtr, eval)
+	if err != nil {
+		return err
+	}
+	for i, b := range bytes {
+		m.mem.Store(m.asmPtr+uint16(i), b)
+	}
+	m.dasm.SetPC(m.asmPtr)
+	m.out.Println(m.dasm.Next())
+	m.asmPtr += uint16(len(bytes))
+	return nil
 }
 
+// breakpoint changes the breakpoints from the machine goroutine since the
+// machine checks them after each instruction.
 func (m *Monitor) breakpoint(args []string) error {
-	if err := checkLen(args, 0, 2); err != nil {
+	var err error
+	m.mach.Do(func() { err = m.doBreakpoint(args) })
+	return err
+}
+
+func (m *Monitor) doBreakpoint(args []string) error {
+	if err := checkLen(args, 0, maxArgs); err != nil {
 		return err
 	}
 	if len(args) == 0 {
@@ -190,12 +388,14 @@ func (m *Monitor) breakpoint(args []string) error {
 			m.out.Println("no breakpoints")
 			return nil
 		}
-		bp := make([]string, 0, len(m.breakpoints))
+		addrs := make([]int, 0, len(m.breakpoints))
 		for addr := range m.breakpoints {
-			bp = append(bp, fmt.Sprintf("%04x", addr))
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			m.out.Println(formatBreakpoint(uint16(addr), m.breakpoints[uint16(addr)]))
 		}
-		sort.Strings(bp)
-		m.out.Println(strings.Join(bp, "\n"))
 		return nil
 	}
 	if args[0] == "clear" {
@@ -204,7 +404,7 @@ func (m *Monitor) breakpoint(args []string) error {
 		}
 		return nil
 	}
-	address, err := parseAddress(args[0])
+	address, err := m.evalAddress(args[0])
 	if err != nil {
 		return err
 	}
@@ -218,15 +418,70 @@ func (m *Monitor) breakpoint(args []string) error {
 	}
 	switch args[1] {
 	case "on":
-		m.breakpoints[address] = struct{}{}
+		if err := checkLen(args, 2, 2); err != nil {
+			return err
+		}
+		m.breakpoints[address] = &machine.Breakpoint{}
 	case "off":
+		if err := checkLen(args, 2, 2); err != nil {
+			return err
+		}
 		delete(m.breakpoints, address)
+	case "if":
+		if err := checkLen(args, 3, maxArgs); err != nil {
+			return err
+		}
+		src := strings.Join(args[2:], " ")
+		e, err := m.compile(src)
+		if err != nil {
+			return err
+		}
+		m.breakpoints[address] = &machine.Breakpoint{
+			Cond: func() bool { return e() != 0 },
+			Expr: src,
+		}
+	case "ignore", "count":
+		if err := checkLen(args, 3, 3); err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid count: %v", args[2])
+		}
+		bp, exists := m.breakpoints[address]
+		if !exists {
+			bp = &machine.Breakpoint{}
+			m.breakpoints[address] = bp
+		}
+		if args[1] == "ignore" {
+			bp.Ignore = n
+		} else {
+			bp.Count = n
+			bp.Hits = 0
+		}
 	default:
 		return fmt.Errorf("invalid: %v", args[1])
 	}
 	return nil
 }
 
+func formatBreakpoint(addr uint16, bp *machine.Breakpoint) string {
+	str := fmt.Sprintf("%04x", addr)
+	if bp.Expr != "" {
+		str += " if " + bp.Expr
+	}
+	if bp.Ignore > 0 {
+		str += fmt.Sprintf(" ignore %v", bp.Ignore)
+	}
+	if bp.Count > 0 {
+		str += fmt.Sprintf(" count %v", bp.Count)
+	}
+	if bp.Hits > 0 {
+		str += fmt.Sprintf(" hits %v", bp.Hits)
+	}
+	return str
+}
+
 func (m *Monitor) core(args []string) error {
 	if err := checkLen(args, 1, 1); err != nil {
 		return err
@@ -243,12 +498,19 @@ func (m *Monitor) core(args []string) error {
 	m.cpu = m.mach.Cores[n].CPU
 	m.mem = m.mach.Cores[n].Mem
 	m.breakpoints = m.mach.Cores[n].Breakpoints
-	m.dasm = m.mach.Cores[n].CPU.Info().NewDisassembler(m.mach.Cores[n].Mem)
-	m.rl.SetPrompt(m.getPrompt())
+	m.spy = m.mach.Cores[n].Spy
+	m.dasm = m.newDisassembler(int(n))
+	m.setPrompt()
 	return nil
 }
 
 func (m *Monitor) disassemble(args []string) error {
+	var err error
+	m.onMach(func() { err = m.doDisassemble(args) })
+	return err
+}
+
+func (m *Monitor) doDisassemble(args []string) error {
 	if err := checkLen(args, 0, 2); err != nil {
 		return err
 	}
@@ -259,7 +521,7 @@ func (m *Monitor) disassemble(args []string) error {
 		}
 	}
 	if len(args) > 0 {
-		addr, err := parseAddress(args[0])
+		addr, err := m.evalAddress(args[0])
 		if err != nil {
 			return err
 		}
@@ -267,7 +529,7 @@ func (m *Monitor) disassemble(args []string) error {
 	}
 	addrEnd := addrStart + uint16(dasmPageLen)
 	if len(args) > 1 {
-		addr, err := parseAddress(args[1])
+		addr, err := m.evalAddress(args[1])
 		if err != nil {
 			return err
 		}
@@ -282,18 +544,24 @@ func (m *Monitor) disassemble(args []string) error {
 }
 
 func (m *Monitor) fill(args []string) error {
+	var err error
+	m.onMach(func() { err = m.doFill(args) })
+	return err
+}
+
+func (m *Monitor) doFill(args []string) error {
 	if err := checkLen(args, 3, 3); err != nil {
 		return err
 	}
-	start, err := parseAddress(args[0])
+	start, err := m.evalAddress(args[0])
 	if err != nil {
 		return err
 	}
-	end, err := parseAddress(args[1])
+	end, err := m.evalAddress(args[1])
 	if err != nil {
 		return err
 	}
-	value, err := parseValue(args[2])
+	value, err := m.evalValue(args[2])
 	if err != nil {
 		return err
 	}
@@ -308,13 +576,26 @@ func (m *Monitor) goCmd(args []string) error {
 		return err
 	}
 	if len(args) > 0 {
-		address, err := parseAddress(args[0])
+		var err error
+		m.mach.Do(func() {
+			var address uint16
+			if address, err = m.evalAddress(args[0]); err == nil {
+				m.cpu.SetPC(address)
+			}
+		})
 		if err != nil {
 			return err
 		}
-		m.cpu.SetPC(address)
 	}
-	go m.mach.Send(machine.StartCmd)
+	m.mach.Send(machine.StartCmd)
+	return nil
+}
+
+func (m *Monitor) goBack(args []string) error {
+	if err := checkLen(args, 0, 0); err != nil {
+		return err
+	}
+	m.mach.Send(machine.GoBackCmd, m.selectedCore)
 	return nil
 }
 
@@ -322,6 +603,10 @@ func (m *Monitor) halt(args []string) error {
 	if err := checkLen(args, 0, 0); err != nil {
 		return err
 	}
+	if m.parent != nil {
+		m.hookBreak = true
+		return nil
+	}
 	m.mach.Send(machine.StopCmd)
 	return nil
 }
@@ -344,6 +629,12 @@ func (m *Monitor) help(args []string) error {
 }
 
 func (m *Monitor) memory(args []string, decoder CharDecoder) error {
+	var err error
+	m.onMach(func() { err = m.doMemory(args, decoder) })
+	return err
+}
+
+func (m *Monitor) doMemory(args []string, decoder CharDecoder) error {
 	if err := checkLen(args, 0, 2); err != nil {
 		return err
 	}
@@ -354,7 +645,7 @@ func (m *Monitor) memory(args []string, decoder CharDecoder) error {
 		}
 	}
 	if len(args) > 0 {
-		addr, err := parseAddress(args[0])
+		addr, err := m.evalAddress(args[0])
 		if err != nil {
 			return err
 		}
@@ -362,7 +653,7 @@ func (m *Monitor) memory(args []string, decoder CharDecoder) error {
 	}
 	addrEnd := addrStart + uint16(memPageLen)
 	if len(args) > 1 {
-		addr, err := parseAddress(args[1])
+		addr, err := m.evalAddress(args[1])
 		if err != nil {
 			return err
 		}
@@ -373,20 +664,53 @@ func (m *Monitor) memory(args []string, decoder CharDecoder) error {
 	return nil
 }
 
+func (m *Monitor) movie(args []string) error {
+	if err := checkLen(args, 1, 2); err != nil {
+		return err
+	}
+	if args[0] == "stop" {
+		if err := checkLen(args, 1, 1); err != nil {
+			return err
+		}
+		m.mach.Send(machine.MovieStopCmd)
+		return nil
+	}
+	if err := checkLen(args, 2, 2); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "rec":
+		m.mach.Send(machine.MovieRecordCmd, args[1])
+	case "play":
+		m.mach.Send(machine.MoviePlayCmd, args[1])
+	default:
+		return fmt.Errorf("invalid: %v", args[0])
+	}
+	return nil
+}
+
 func (m *Monitor) next(args []string) error {
 	if err := checkLen(args, 0, 0); err != nil {
 		return err
 	}
-	m.dasm.SetPC(m.cpu.PC())
-	m.out.Println(m.dasm.Next())
+	m.onMach(func() {
+		m.dasm.SetPC(m.cpu.PC())
+		m.out.Println(m.dasm.Next())
+	})
 	return nil
 }
 
 func (m *Monitor) pokePeek(args []string) error {
+	var err error
+	m.onMach(func() { err = m.doPokePeek(args) })
+	return err
+}
+
+func (m *Monitor) doPokePeek(args []string) error {
 	if err := checkLen(args, 1, maxArgs); err != nil {
 		return err
 	}
-	address, err := parseAddress(args[0])
+	address, err := m.evalAddress(args[0])
 	if err != nil {
 		return err
 	}
@@ -398,7 +722,7 @@ func (m *Monitor) pokePeek(args []string) error {
 	// poke
 	values := []uint8{}
 	for _, str := range args[1:] {
-		v, err := parseValue(str)
+		v, err := m.evalValue(str)
 		if err != nil {
 			return err
 		}
@@ -410,7 +734,141 @@ func (m *Monitor) pokePeek(args []string) error {
 	return nil
 }
 
+func (m *Monitor) profile(args []string) error {
+	if err := checkLen(args, 0, 4); err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			if err := checkLen(args, 1, 2); err != nil {
+				return err
+			}
+			perFrame := false
+			if len(args) == 2 {
+				if args[1] != "frame" {
+					return fmt.Errorf("invalid option: %v", args[1])
+				}
+				perFrame = true
+			}
+			m.mach.Send(machine.ProfileCmd, perFrame)
+			return nil
+		case "off":
+			if err := checkLen(args, 1, 1); err != nil {
+				return err
+			}
+			m.mach.Send(machine.ProfileStopCmd)
+			return nil
+		case "json":
+			if err := checkLen(args, 2, 4); err != nil {
+				return err
+			}
+			return m.writeProfiles(args[1], args[2:])
+		}
+	}
+	var r *machine.ProfileReport
+	var err error
+	m.mach.Do(func() {
+		var start, end int
+		if start, end, err = m.profileRange(args); err == nil {
+			r, err = m.mach.Profile(m.selectedCore, start, end)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	r.WriteText(&b, profTop)
+	m.out.Print(b.String())
+	return nil
+}
+
+// profileRange returns the range of addresses checked for coverage. With
+// no arguments, the range is the span of executed addresses.
+func (m *Monitor) profileRange(args []string) (int, int, error) {
+	if len(args) == 0 {
+		return 0, -1, nil
+	}
+	if err := checkLen(args, 2, 2); err != nil {
+		return 0, 0, err
+	}
+	start, err := m.evalAddress(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := m.evalAddress(args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range")
+	}
+	return int(start), int(end), nil
+}
+
+func (m *Monitor) writeProfiles(path string, args []string) error {
+	reports := make([]*machine.ProfileReport, 0, len(m.mach.Cores))
+	var err error
+	m.mach.Do(func() {
+		var start, end int
+		if start, end, err = m.profileRange(args); err != nil {
+			return
+		}
+		for i := range m.mach.Cores {
+			var r *machine.ProfileReport
+			if r, err = m.mach.Profile(i, start, end); err != nil {
+				return
+			}
+			reports = append(reports, r)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := machine.WriteProfiles(f, reports); err != nil {
+		return err
+	}
+	m.out.Printf("profile saved to %v", path)
+	return nil
+}
+
+func (m *Monitor) record(args []string) error {
+	if err := checkLen(args, 1, 2); err != nil {
+		return err
+	}
+	if args[0] == "stop" {
+		if err := checkLen(args, 1, 1); err != nil {
+			return err
+		}
+		m.mach.Send(machine.RecordStopCmd)
+		return nil
+	}
+	if err := checkLen(args, 2, 2); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "audio":
+		m.mach.Send(machine.RecordAudioCmd, args[1])
+	case "video":
+		m.mach.Send(machine.RecordVideoCmd, args[1])
+	default:
+		return fmt.Errorf("invalid: %v", args[0])
+	}
+	return nil
+}
+
 func (m *Monitor) registers(args []string) error {
+	var err error
+	m.onMach(func() { err = m.doRegisters(args) })
+	return err
+}
+
+func (m *Monitor) doRegisters(args []string) error {
 	if err := checkLen(args, 0, 2); err != nil {
 		return err
 	}
@@ -444,15 +902,15 @@ func (m *Monitor) registers(args []string) error {
 	// Set value of register
 	switch put := reg.Put.(type) {
 	case func(uint8):
-		v, err := parseValue(args[1])
+		v, err := m.evalValue(args[1])
 		if err != nil {
-			return nil
+			return err
 		}
 		put(v)
 	case func(uint16):
-		v, err := parseValue16(args[1])
+		v, err := m.evalValue16(args[1])
 		if err != nil {
-			return nil
+			return err
 		}
 		put(v)
 	}
@@ -460,20 +918,87 @@ func (m *Monitor) registers(args []string) error {
 }
 
 func (m *Monitor) restore(args []string) error {
-	if err := checkLen(args, 0, 0); err != nil {
+	if err := checkLen(args, 0, 1); err != nil {
 		return err
 	}
-	fileName := PathFor(Store, m.mach.System.Spec().Name, SnapshotFileName)
-	m.mach.Send(machine.RestoreCmd, fileName)
+	path, err := m.snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	m.mach.Send(machine.RestoreCmd, path)
+	return nil
+}
+
+func (m *Monitor) rewind(args []string) error {
+	if err := checkLen(args, 1, 1); err != nil {
+		return err
+	}
+	frames, err := strconv.Atoi(args[0])
+	if err != nil || frames < 0 {
+		return fmt.Errorf("invalid number of frames: %v", args[0])
+	}
+	m.mach.Send(machine.RewindCmd, frames)
 	return nil
 }
 
 func (m *Monitor) save(args []string) error {
+	if err := checkLen(args, 0, 1); err != nil {
+		return err
+	}
+	path, err := m.snapshotPath(args)
+	if err != nil {
+		return err
+	}
+	m.mach.Send(machine.SaveCmd, path)
+	return nil
+}
+
+func (m *Monitor) snapshotPath(args []string) (string, error) {
+	name := m.mach.System.Spec().Name
+	if len(args) == 0 {
+		return PathFor(Store, name, SnapshotFileName), nil
+	}
+	if err := machine.CheckSlotName(args[0]); err != nil {
+		return "", err
+	}
+	return PathFor(Store, name, machine.SlotFileName(args[0])), nil
+}
+
+func (m *Monitor) slots(args []string) error {
 	if err := checkLen(args, 0, 0); err != nil {
 		return err
 	}
-	fileName := PathFor(Store, m.mach.System.Spec().Name, SnapshotFileName)
-	m.mach.Send(machine.SaveCmd, fileName)
+	slots, err := machine.Slots(PathFor(Store, m.mach.System.Spec().Name))
+	if err != nil {
+		return err
+	}
+	if len(slots) == 0 {
+		m.out.Println("no slots")
+		return nil
+	}
+	for _, slot := range slots {
+		if slot.Err != nil {
+			m.out.Printf("%-8v unreadable: %v\n", slot.Name, slot.Err)
+			continue
+		}
+		thumb := ""
+		if slot.Thumbnail != nil {
+			thumb = " [thumbnail]"
+		}
+		m.out.Printf("%-8v %v%v\n", slot.Name, slot.Created.Format("2006-01-02 15:04:05"), thumb)
+	}
+	return nil
+}
+
+func (m *Monitor) screenshot(args []string) error {
+	if err := checkLen(args, 0, 1); err != nil {
+		return err
+	}
+	fileName := PathFor(Store, m.mach.System.Spec().Name, machine.ScreenshotFileName())
+	if len(args) > 0 {
+		fileName = args[0]
+	}
+	m.mach.Send(machine.ScreenshotCmd, fileName)
 	return nil
 }
 
@@ -481,12 +1006,117 @@ func (m *Monitor) step(args []string) error {
 	if err := checkLen(args, 0, 0); err != nil {
 		return err
 	}
-	m.cpu.Next()
-	m.dasm.SetPC(m.cpu.PC())
-	m.out.Println(m.dasm.Next())
+	m.mach.Do(func() {
+		m.mach.Step(m.selectedCore)
+		m.dasm.SetPC(m.cpu.PC())
+		m.out.Println(m.dasm.Next())
+	})
+	return nil
+}
+
+func (m *Monitor) stepBack(args []string) error {
+	if err := checkLen(args, 0, 0); err != nil {
+		return err
+	}
+	m.mach.Send(machine.StepBackCmd, m.selectedCore)
+	return nil
+}
+
+func (m *Monitor) until(args []string) error {
+	if err := checkLen(args, 1, 1); err != nil {
+		return err
+	}
+	var address uint16
+	var err error
+	m.mach.Do(func() { address, err = m.evalAddress(args[0]) })
+	if err != nil {
+		return err
+	}
+	m.mach.Send(machine.RunToCmd, m.selectedCore, address)
+	return nil
+}
+
+func (m *Monitor) frames(args []string) error {
+	if err := checkLen(args, 0, 1); err != nil {
+		return err
+	}
+	frames := 1
+	if len(args) > 0 {
+		var err error
+		frames, err = strconv.Atoi(args[0])
+		if err != nil || frames < 1 {
+			return fmt.Errorf("invalid number of frames: %v", args[0])
+		}
+	}
+	m.mach.Send(machine.RunFramesCmd, frames)
+	return nil
+}
+
+func (m *Monitor) cycles(args []string) error {
+	if err := checkLen(args, 1, 1); err != nil {
+		return err
+	}
+	cycles, err := strconv.Atoi(args[0])
+	if err != nil || cycles < 1 {
+		return fmt.Errorf("invalid number of cycles: %v", args[0])
+	}
+	m.mach.Send(machine.RunCyclesCmd, m.selectedCore, cycles)
+	return nil
+}
+
+func (m *Monitor) stepOver(args []string) error {
+	if err := checkLen(args, 0, 0); err != nil {
+		return err
+	}
+	m.mach.Send(machine.StepOverCmd, m.selectedCore)
+	return nil
+}
+
+func (m *Monitor) stepOut(args []string) error {
+	if err := checkLen(args, 0, 0); err != nil {
+		return err
+	}
+	var ok bool
+	m.mach.Do(func() { _, ok = m.mach.CallStack(m.selectedCore) })
+	if !ok {
+		return fmt.Errorf("no call stack for this cpu")
+	}
+	m.mach.Send(machine.StepOutCmd, m.selectedCore)
+	return nil
+}
+
+func (m *Monitor) backtrace(args []string) error {
+	var err error
+	m.mach.Do(func() { err = m.doBacktrace(args) })
+	return err
+}
+
+func (m *Monitor) doBacktrace(args []string) error {
+	if err := checkLen(args, 0, 0); err != nil {
+		return err
+	}
+	calls, ok := m.mach.CallStack(m.selectedCore)
+	if !ok {
+		return fmt.Errorf("no call stack for this cpu")
+	}
+	m.out.Printf("%-4v %v\n", "pc", m.addressName(m.cpu.PC()))
+	for i := len(calls) - 1; i >= 0; i-- {
+		f := calls[i]
+		m.out.Printf("%-4v %-22v from $%04x  sp $%04x\n", f.Kind, m.addressName(f.Target), f.From, f.SP)
+	}
 	return nil
 }
 
+// addressName returns addr with its label, if it has one.
+func (m *Monitor) addressName(addr uint16) string {
+	if m.mach.CodeInfo != nil {
+		if label, ok := m.mach.CodeInfo.Labels[addr]; ok {
+			return fmt.Sprintf("$%04x %v", addr, label)
+		}
+	}
+	return fmt.Sprintf("$%04x", addr)
+}
+
 func (m *Monitor) trace(args []string) error {
 	if err := checkLen(args, 0, 0); err != nil {
 		return err
@@ -495,14 +1125,120 @@ func (m *Monitor) trace(args []string) error {
 	return nil
 }
 
+// watch changes the watchpoints from the machine goroutine since the spy
+// checks them on each memory access.
+func (m *Monitor) watch(args []string) error {
+	var err error
+	m.mach.Do(func() { err = m.doWatch(args) })
+	return err
+}
+
+func (m *Monitor) doWatch(args []string) error {
+	if err := checkLen(args, 0, 3); err != nil {
+		return err
+	}
+	if m.spy == nil {
+		return errors.New("watchpoints not supported")
+	}
+	if len(args) == 0 {
+		m.listWatchpoints()
+		return nil
+	}
+	if args[0] == "clear" {
+		if err := checkLen(args, 1, 1); err != nil {
+			return err
+		}
+		m.spy.UnwatchAll()
+		return nil
+	}
+	if err := checkLen(args, 2, 3); err != nil {
+		return err
+	}
+	addrStart, err := m.evalAddress(args[0])
+	if err != nil {
+		return err
+	}
+	addrEnd := addrStart
+	if len(args) == 3 {
+		addrEnd, err = m.evalAddress(args[1])
+		if err != nil {
+			return err
+		}
+	}
+	if addrEnd < addrStart {
+		return errors.New("end address is before start address")
+	}
+	var fn func(uint16)
+	switch mode := args[len(args)-1]; mode {
+	case "r":
+		fn = m.spy.WatchR
+	case "w":
+		fn = m.spy.WatchW
+	case "rw":
+		fn = m.spy.WatchRW
+	case "off":
+		fn = m.spy.UnwatchRW
+	default:
+		return fmt.Errorf("invalid: %v", mode)
+	}
+	for addr := int(addrStart); addr <= int(addrEnd); addr++ {
+		fn(uint16(addr))
+	}
+	return nil
+}
+
+// listWatchpoints prints the watched addresses where consecutive addresses
+// with the same mode are shown as a range.
+func (m *Monitor) listWatchpoints() {
+	reads, writes := m.spy.Watched()
+	modes := make(map[uint16]string)
+	for _, addr := range reads {
+		modes[addr] += "r"
+	}
+	for _, addr := range writes {
+		modes[addr] += "w"
+	}
+	if len(modes) == 0 {
+		m.out.Println("no watchpoints")
+		return
+	}
+	addrs := make([]int, 0, len(modes))
+	for addr := range modes {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for i := 0; i < len(addrs); {
+		start := addrs[i]
+		mode := modes[uint16(start)]
+		end := start
+		for i++; i < len(addrs) && addrs[i] == end+1 && modes[uint16(addrs[i])] == mode; i++ {
+			end = addrs[i]
+		}
+		if start == end {
+			m.out.Printf("%04x %v\n", start, mode)
+		} else {
+			m.out.Printf("%04x %04x %v\n", start, end, mode)
+		}
+	}
+}
+
+// handleEvent is called from the machine goroutine.
 func (m *Monitor) handleEvent(evt machine.EventType, arg interface{}) {
 	switch evt {
 	case machine.StatusEvent:
 		s := arg.(machine.Status)
 		if s == machine.Break {
 			fmt.Println()
-			m.registers([]string{})
-			m.rl.Refresh()
+			m.doRegisters([]string{})
+			if m.rl != nil {
+				m.rl.Refresh()
+			}
+		}
+		if s != machine.Run {
+			select {
+			case m.stopped <- struct{}{}:
+			default:
+			}
 		}
 	case machine.TraceEvent:
 		msg := arg.(string)
@@ -510,11 +1246,55 @@ func (m *Monitor) handleEvent(evt machine.EventType, arg interface{}) {
 	case machine.ErrorEvent:
 		msg := arg.(string)
 		m.out.Println(msg)
+	case machine.InfoEvent:
+		msg := arg.(string)
+		m.out.Println(msg)
 	default:
 		log.Panicf("unknown arg: %v", arg)
 	}
 }
 
+// compile compiles an expression that uses the registers and memory of
+// the selected core.
+func (m *Monitor) compile(str string) (Expr, error) {
+	regs := registerLookup(m.cpu.Info().Registers)
+	symbols := symbolLookup(m.mach.CodeInfo)
+	lookup := func(name string) (Expr, bool) {
+		if e, ok := regs(name); ok {
+			return e, true
+		}
+		return symbols(name)
+	}
+	return CompileExpr(str, lookup, m.mem)
+}
+
+func (m *Monitor) eval(str string, max int, what string) (int, error) {
+	e, err := m.compile(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v: %v: %v", what, str, err)
+	}
+	v := e()
+	if v < 0 || v > max {
+		return 0, fmt.Errorf("invalid %v: %v", what, str)
+	}
+	return v, nil
+}
+
+func (m *Monitor) evalAddress(str string) (uint16, error) {
+	v, err := m.eval(str, 0xffff, "address")
+	return uint16(v), err
+}
+
+func (m *Monitor) evalValue(str string) (uint8, error) {
+	v, err := m.eval(str, 0xff, "value")
+	return uint8(v), err
+}
+
+func (m *Monitor) evalValue16(str string) (uint16, error) {
+	v, err := m.eval(str, 0xffff, "value")
+	return uint16(v), err
+}
+
 func checkLen(args []string, min int, max int) error {
 	if len(args) < min {
 		return errors.New("not enough arguments")
@@ -539,14 +1319,6 @@ func parseUint(str string, bitSize int) (uint64, error) {
 	return strconv.ParseUint(str, base, bitSize)
 }
 
-func parseAddress(str string) (uint16, error) {
-	value, err := parseUint(str, 16)
-	if err != nil {
-		return 0, fmt.Errorf("invalid address: %v", str)
-	}
-	return uint16(value), nil
-}
-
 func parseValue(str string) (uint8, error) {
 	value, err := parseUint(str, 8)
 	if err != nil {
@@ -555,14 +1327,6 @@ func parseValue(str string) (uint8, error) {
 	return uint8(value), nil
 }
 
-func parseValue16(str string) (uint16, error) {
-	value, err := parseUint(str, 16)
-	if err != nil {
-		return 0, fmt.Errorf("invalid value: %v", str)
-	}
-	return uint16(value), nil
-}
-
 func formatValue(v uint8) string {
 	return fmt.Sprintf("$%02x +%d", v, v)
 }
@@ -617,23 +1381,76 @@ var AsciiDecoder = func(code uint8) (rune, bool) {
 }
 
 var helpList = `
+a   assemble
+assert assert expression
 b   breakpoints
+bt  backtrace
+cy  run cycles
 d   disassemble code
 f   fill memory
+fr  run frames
 g   go
+gb  go back
 h   halt
+if  conditional command
+in  set inputs
+lua lua script
 m   memory view
+macro macros
+mov input movies
 n   next
+on  hooks
 p   poke/peek memory
+prof profile
 r   registers
+rec record audio/video
+rewind go back frames
 s   step
+sb  step back
+sout step out
+sov step over
 si  state in
+sl  state slot list
 so  state out
+source run script
+ss  screenshot
 t   trace
+u   run until
+w   watchpoints
 q   quit
 `
 
 var helpCmds = map[string]string{
+	"a": `
+Assemble
+
+    a <address>
+
+Assemble instructions starting at <address>. Each line entered is an
+instruction that is stored in memory and the next line is assembled at
+the address that follows. Enter an empty line or "." to stop.
+
+    a <address> <instruction>
+
+Assemble a single instruction at <address>.
+
+An operand that could be either a register or a value, such as "b", is
+taken to be the register. Use "$b" for the value.
+`,
+
+	"assert": `
+Assert
+
+    assert <expression>
+
+Check that <expression> is true, which is any value other than zero. If
+it is not, a message is shown and the failure is counted. When running a
+script in batch mode, pac8 exits with an error if any assertion failed.
+For example:
+
+    assert (4e13)==3
+`,
+
 	"b": `
 Breakpoints
 
@@ -646,9 +1463,43 @@ List active breakpoints.
 Sets a breakpoint at <address> when using "on" and clears a breakpoint at
 <address> when using "off". The CPU stops before executing address.
 
+    b <address> if <expression>
+
+Sets a breakpoint at <address> that only stops the CPU when <expression>
+is true. For example:
+
+    b 0a2c if A==3 && (HL)==$10
+
+    b <address> ignore <n>
+
+Pass the breakpoint <n> times before stopping.
+
+    b <address> count <n>
+
+Only stop when the breakpoint is reached for the <n>th time.
+
 	b clear
 
 Clears all breakpoints.
+`,
+
+	"w": `
+Watchpoints
+
+    w
+
+List active watchpoints.
+
+    w <start-address> [end-address] {r|w|rw|off}
+
+Watch memory from <start-address> to <end-address> inclusive for reads
+with "r", writes with "w", or both with "rw". Use "off" to stop watching.
+The CPU stops after executing the instruction that accessed the memory and
+the address, value and program counter are shown.
+
+    w clear
+
+Clears all watchpoints.
 `,
 
 	"d": `
@@ -677,6 +1528,15 @@ Go
 
 Go to [address] and start execution of the CPU there. If [address] is not
 specified, use the current value of the program counter.
+`,
+
+	"gb": `
+Go back
+
+    gb
+
+Go back to the previous time the CPU stopped at a breakpoint. This only
+works as far back as the rewind buffer goes.
 `,
 
 	"h": `
@@ -685,6 +1545,28 @@ Halt
     h
 
 Halt execution of the CPU.
+`,
+
+	"if": `
+If
+
+    if <expression> <command>
+
+Run <command> only when <expression> is true, which is any value other than
+zero. The expression cannot contain spaces. For example:
+
+    if (4e14)<3 p 4e14 3
+`,
+
+	"in": `
+Input
+
+    in [input...]
+
+Set the inputs to the machine so that only the ones listed are active.
+Without any inputs, all are released. Input names are up, down, left,
+right, fire, coin and start. Add a number to select the player (e.g.
+coin2).
 `,
 
 	"m": `
@@ -695,6 +1577,48 @@ Memory view
 Dump memory contents to the screen from [start-address] to [end-address]
 inclusive. If [end-address] is not specified, show a full memory page.
 If [start-address] is not specified, continue the dump from the last command.
+`,
+
+	"macro": `
+Macros
+
+    macro
+
+List all macros.
+
+    macro <name>
+
+Show the commands run by macro <name>.
+
+    macro <name> <command> [; command...]
+
+Define macro <name> to run each command separated by a semicolon. Use
+{1} to {9} for the arguments given to the macro and {*} for all of them.
+A command that starts the CPU is allowed to finish before the next one is
+run. For example:
+
+    macro peek2 p {1} ; p {1}+1
+
+    macro <name> off
+
+Remove macro <name>.
+`,
+
+	"mov": `
+Movie
+
+    mov rec <file>
+
+Save a snapshot and then record all inputs to a movie <file>. The movie is
+written when recording is stopped.
+
+    mov play <file>
+
+Restore the snapshot in the movie <file> and replay its inputs.
+
+    mov stop
+
+Stop recording or playing a movie.
 `,
 
 	"n": `
@@ -703,6 +1627,61 @@ Next
     n
 
 Disassemble the next instruction to execute.
+`,
+
+	"lua": `
+Lua script
+
+    lua
+
+Show the Lua script that is loaded.
+
+    lua <file>
+
+Load the Lua script in <file> in place of the one that is loaded. The
+script can add callbacks that run after each frame, before an address is
+executed, or after memory is written, and can draw over the screen.
+
+    lua off
+
+Remove the Lua script and everything that it added.
+`,
+
+	"on": `
+Hooks
+
+    on
+
+List active hooks.
+
+    on frame <command> [; command...]
+
+Run the commands after each frame while the machine is running.
+
+    on exec <address> <command> [; command...]
+
+Run the commands before the CPU executes the instruction at <address>.
+
+    on write <address> <command> [; command...]
+
+Run the commands after the CPU writes to <address>. Use {1} for the value
+that was written.
+
+Only commands that act right away can be used by a hook: assert, d, f, h,
+if, in, m, n, p and r, along with macros. Use "h" to stop the machine.
+Memory changed by a hook does not trigger watchpoints or other hooks. If
+a command fails, the machine stops. For example, to keep three lives in
+Pac-Man:
+
+    on write 4e14 if {1}<3 p 4e14 3
+
+    on <n> off
+
+Remove hook <n>.
+
+    on clear
+
+Remove all hooks.
 `,
 
 	"p": `
@@ -729,6 +1708,58 @@ Display the value for the register with <name>.
     r <name> <value>
 
 Set the <value> for register with <name>.
+`,
+
+	"rec": `
+Record
+
+    rec audio <file>
+
+Record audio to a WAV file.
+
+    rec video <path>
+
+Record video to an animated GIF if <path> ends with ".gif". Otherwise,
+<path> is a directory where each frame is saved as a PNG file.
+
+    rec stop
+
+Stop all recordings.
+`,
+
+	"prof": `
+Profile
+
+    prof on [frame]
+
+Start counting how many times each instruction is executed on every core.
+Any previous counts are discarded. With "frame", counts start over at the
+beginning of each frame and reports show the last complete frame.
+
+    prof off
+
+Stop counting.
+
+    prof [<start> <end>]
+
+Show the most executed addresses, the most called subroutines, and the
+ranges of addresses from <start> to <end> that were never executed for the
+selected core. Without a range, the span of executed addresses is used.
+
+    prof json <file> [<start> <end>]
+
+Write the full report for every core to <file> as JSON.
+`,
+
+	"rewind": `
+Rewind
+
+    rewind <frames>
+
+Go back to the state of the machine <frames> ago, where <frames> is a
+decimal number. Snapshots are only kept every few frames so the machine
+goes back to the most recent snapshot taken at least <frames> ago. If the
+snapshots do not go back that far, the oldest one is used.
 `,
 
 	"s": `
@@ -737,22 +1768,122 @@ Step
     s
 
 Step through by executing the next instruction and then halting the CPU.
+`,
+
+	"u": `
+Until
+
+    u <address>
+
+Run until the program counter reaches <address> and then halt. If the
+CPU is already at <address>, run until it gets back there. A breakpoint
+stops it early.
+`,
+
+	"fr": `
+Frames
+
+    fr [frames]
+
+Run for <frames> video frames and then halt, where <frames> is a decimal
+number. Interrupts and input are handled as usual for each frame. Without
+<frames>, run one frame.
+`,
+
+	"cy": `
+Cycles
+
+    cy <cycles>
+
+Run until the CPU has executed at least <cycles> cycles and then halt,
+where <cycles> is a decimal number. The last instruction is completed so
+it may go over.
+`,
+
+	"sov": `
+Step over
+
+    sov
+
+Execute the next instruction. If it calls a subroutine, or an interrupt
+is taken, keep running until it returns. A breakpoint stops it early.
+`,
+
+	"sout": `
+Step out
+
+    sout
+
+Run until the subroutine or interrupt handler that the CPU is in returns.
+A breakpoint stops it early.
+`,
+
+	"bt": `
+Backtrace
+
+    bt
+
+Show the subroutines and interrupt handlers that have not returned, the
+innermost first. Calls made before the state was last restored are not
+known.
+`,
+
+	"sb": `
+Step back
+
+    sb
+
+Step back by undoing the last instruction executed by the CPU. This only
+works as far back as the rewind buffer goes. Changes made with the monitor,
+such as poking memory, are not undone.
 `,
 
 	"so": `
 State out
 
-    so
+    so [slot]
 
-Save the current machine state out to disk.
+Save the current machine state out to disk. If <slot> is specified, the
+state is saved to the slot with that name. Slots 1 to 8 can also be saved
+with the F1 to F8 keys.
 `,
 
 	"si": `
 State in
 
-    si
+    si [slot]
+
+Load the current machine state in from disk. If <slot> is specified, the
+state is loaded from the slot with that name. Slots 1 to 8 can also be
+loaded with shift and the F1 to F8 keys.
+`,
+
+	"sl": `
+State slot list
+
+    sl
+
+List all saved slots with the time they were created.
+`,
+
+	"source": `
+Source
+
+    source <file>
+
+Run the monitor commands in <file>, one per line. Blank lines and lines
+starting with "#" are ignored. A command that starts the CPU is allowed to
+finish before the next one is run. The script stops at the first command
+that fails.
+`,
+
+	"ss": `
+Screenshot
+
+    ss [file]
 
-Load the current machine state in from disk.
+Save the last frame rendered to a PNG file. If file is not specified, the
+screenshot is saved to the storage directory for the game.
 `,
 
 	"t": `
@@ -3,14 +3,19 @@ package app
 import (
 	"bytes"
 	"fmt"
+	"image/png"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/blackchip-org/pac8/pkg/input"
 	"github.com/blackchip-org/pac8/pkg/machine"
 	"github.com/blackchip-org/pac8/pkg/memory"
 	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
 )
 
 type fixture struct {
@@ -77,6 +82,71 @@ func TestBreakpointClear(t *testing.T) {
 	With(t).Expect(f.out.String()).ToBe("no breakpoints\n")
 }
 
+func TestBreakpointIf(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("b 0x02 if A==3 && (PC)==1 \n r A 3 \n g")
+	testMonitorRun(f.mon)
+
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0002)
+}
+
+func TestBreakpointIfFalse(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("b 0x02 if A==3 \n g")
+	testMonitorRun(f.mon)
+
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).NotToBe(0x0002)
+}
+
+func TestBreakpointListOptions(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("b 10 if A == $10 \n b 10 ignore 2 \n b 20 count 5 \n b \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("" +
+		"0010 if A == $10 ignore 2\n" +
+		"0020 count 5\n")
+}
+
+func TestBreakpointIfInvalid(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("b 10 if A == \n b \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("" +
+		"unexpected end of expression\n" +
+		"no breakpoints\n")
+}
+
+func TestWatchpointRead(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("w 0x02 r \n g")
+	testMonitorRun(f.mon)
+
+	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
+	With(t).Expect(lines[0]).ToBe("watchpoint r 0002 01 at pc 0002")
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0003)
+}
+
+func TestWatchpointList(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("w 10 1f w \n w 20 rw \n w 21 r \n w 18 off \n w \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("" +
+		"0010 0017 w\n" +
+		"0019 001f w\n" +
+		"0020 rw\n" +
+		"0021 r\n")
+}
+
+func TestWatchpointClear(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("w 10 w \n w clear \n w \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("no watchpoints")
+}
+
 func TestDisassembleFirstLine(t *testing.T) {
 	f := newTestMonitor()
 	f.cursor.PutN(0x20, 0xcd, 0xab)
@@ -89,6 +159,30 @@ func TestDisassembleFirstLine(t *testing.T) {
 	)
 }
 
+func TestDisassembleLabel(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x20, 0xcd, 0xab)
+	f.mon.mach.CodeInfo.Labels[0x0000] = "start"
+	f.mon.mach.CodeInfo.Comments[0x0000] = "reset"
+	f.mon.in = testMonitorInput("d \n q")
+	testMonitorRun(f.mon)
+	lines := strings.Split(f.out.String(), "\n")
+	With(t).Expect(lines[0]).ToBe("start:")
+	With(t).Expect(lines[1]).ToBe(
+		"$0000:  20 cd ab  i20 $abcd              ; reset",
+	)
+}
+
+func TestBreakpointSymbol(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.mach.CodeInfo.Symbols["loop"] = 0x0002
+	f.mon.in = testMonitorInput("b loop on \n g")
+	testMonitorRun(f.mon)
+
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0002)
+}
+
 func TestDisassembleLastLine(t *testing.T) {
 	f := newTestMonitor()
 	f.cursor.Pos = 0x3f
@@ -121,6 +215,7 @@ func TestDisassembleRange(t *testing.T) {
 	f.cursor.PutN(0x20, 0xcd, 0xab)
 	f.mon.in = testMonitorInput("d 0100 0112 \n q")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	With(t).Expect(lines[len(lines)-1]).ToBe(
 		"$0112:  20 cd ab  i20 $abcd",
@@ -167,6 +262,7 @@ func TestMemoryLastLine(t *testing.T) {
 	f := newTestMonitor()
 	f.mon.in = testMonitorInput("m \n q")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	With(t).Expect(lines[len(lines)-1]).ToBe(
 		"$00f0 00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00 ................",
@@ -177,6 +273,7 @@ func TestMemoryPage(t *testing.T) {
 	f := newTestMonitor()
 	f.mon.in = testMonitorInput("m 0100 \n q")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	want := "$01f0 00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00 ................"
 	have := lines[len(lines)-1]
@@ -189,6 +286,7 @@ func TestMemoryNextPage(t *testing.T) {
 	f := newTestMonitor()
 	f.mon.in = testMonitorInput("m 0100 \n m \n q")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	want := "$02f0 00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00 ................"
 	have := lines[len(lines)-1]
@@ -201,6 +299,7 @@ func TestMemoryRange(t *testing.T) {
 	f := newTestMonitor()
 	f.mon.in = testMonitorInput("m 0100 018f \n q")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	want := "$0180 00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00 ................"
 	have := lines[len(lines)-1]
@@ -223,6 +322,14 @@ func TestPokeN(t *testing.T) {
 	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x904)).ToBe(0x34)
 }
 
+func TestPokeExpr(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("r BC 20 \n p BC+1 +10 [A+2]*3 \n q")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x21)).ToBe(uint8(10))
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x22)).ToBe(uint8(6))
+}
+
 func TestPeek(t *testing.T) {
 	f := newTestMonitor()
 	f.mon.mem.Store(0x0900, 0xab)
@@ -232,15 +339,194 @@ func TestPeek(t *testing.T) {
 	With(t).Expect(lines[0]).ToBe("$ab +171")
 }
 
+func TestRecordVideo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("rec video " + dir + " \n g")
+	testMonitorRun(f.mon)
+	t.Log(f.out.String())
+	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
+	With(t).Expect(lines[0]).ToBe("recording video to " + dir)
+	With(t).Expect(lines[1]).ToBe("video recording stopped")
+	_, err = os.Stat(filepath.Join(dir, "frame-000000.png"))
+	With(t).Expect(err).ToBe(nil)
+}
+
+func TestRecordAudioNotSupported(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("rec audio test.wav \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("audio does not support recording")
+}
+
+func TestMovieRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.movie")
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("mov rec " + path + " \n g")
+	testMonitorRun(f.mon)
+	t.Log(f.out.String())
+	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
+	With(t).Expect(lines[0]).ToBe("recording movie to " + path)
+	With(t).Expect(lines[1]).ToBe("movie saved to " + path)
+
+	movie, err := input.LoadMovie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(movie.Frames).ToBe(1)
+}
+
+func TestMoviePlayWrongGame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.movie")
+	if err := input.SaveMovie(path, &input.Movie{Game: "pacman"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("mov play " + path + " \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("movie is for pacman, not fixture")
+}
+
+func TestRestoreWrongGame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home = dir
+	defer func() { home = "" }()
+
+	path := PathFor(Store, "fixture", SnapshotFileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := state.NewWriter("pacman", nil).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("si \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("unable to load snapshot: snapshot is for pacman, not fixture")
+}
+
+func TestSlots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home = dir
+	defer func() { home = "" }()
+	if err := os.MkdirAll(PathFor(Store, "fixture"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := PathFor(Store, "fixture", machine.SlotFileName("2"))
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("sl \n so 2 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("no slots\nsnapshot saved to " + path)
+
+	f = newTestMonitor()
+	f.mon.in = testMonitorInput("sl \n q")
+	testMonitorRun(f.mon)
+	out := strings.TrimSpace(f.out.String())
+	With(t).Expect(strings.HasPrefix(out, "2 ")).ToBe(true)
+	With(t).Expect(strings.HasSuffix(out, "[thumbnail]")).ToBe(true)
+
+	// A slot that cannot be read does not hide the others
+	bad := PathFor(Store, "fixture", machine.SlotFileName("1"))
+	if err := ioutil.WriteFile(bad, []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f = newTestMonitor()
+	f.mon.in = testMonitorInput("sl \n q")
+	testMonitorRun(f.mon)
+	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
+	With(t).Expect(len(lines)).ToBe(2)
+	With(t).Expect(strings.HasPrefix(lines[0], "1        unreadable: ")).ToBe(true)
+	With(t).Expect(strings.HasPrefix(lines[1], "2 ")).ToBe(true)
+}
+
+func TestSlotName(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("so ../x \n si a/b \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("invalid slot name: ../x\ninvalid slot name: a/b")
+}
+
+func TestRewindEmpty(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("rewind 30 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("unable to rewind: rewind buffer is empty")
+}
+
+func TestStepBackNothing(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("sb \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("unable to go back: no instructions have been executed")
+}
+
+func TestScreenshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.png")
+
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("ss " + path + " \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.TrimSpace(f.out.String())).ToBe("screenshot saved to " + path)
+
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	img, err := png.Decode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(img.Bounds().Dx()).ToBe(4)
+	With(t).Expect(img.Bounds().Dy()).ToBe(3)
+}
+
 func TestTrace(t *testing.T) {
 	f := newTestMonitor()
 	f.cursor.PutN(
 		0x20, 0x34, 0x12,
 		0x10, 0x56,
 	)
-	f.mon.breakpoints[0x0005] = struct{}{}
+	f.mon.breakpoints[0x0005] = &machine.Breakpoint{}
 	f.mon.in = testMonitorInput("t \n g")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	fmt.Println(lines[1])
 	With(t).Expect(lines[0]).ToBe(
@@ -257,11 +543,12 @@ func TestTraceOff(t *testing.T) {
 		0x20, 0x34, 0x12,
 		0x10, 0x56,
 	)
-	f.mon.breakpoints[0x0005] = struct{}{}
+	f.mon.breakpoints[0x0005] = &machine.Breakpoint{}
 	f.mon.in = testMonitorInput("t \n q")
 	testMonitorRun(f.mon)
 	f.mon.in = testMonitorInput("t \n g")
 	testMonitorRun(f.mon)
+	t.Log(f.out.String())
 	lines := strings.Split(strings.TrimSpace(f.out.String()), "\n")
 	With(t).Expect(lines[0]).ToBe("[break]")
 }
@@ -333,3 +620,376 @@ func TestDump(t *testing.T) {
 		})
 	}
 }
+
+func TestAssemble(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("a 10 \n i10 +10 \n i20 [BC+1] \n . \n q")
+	f.mon.cpu.Info().Registers["BC"].Put.(func(uint16))(0x1233)
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("" +
+		"$0010:  10 0a     i10 $0a\n" +
+		"$0012:  20 34 12  i20 $1234\n")
+	With(t).Expect(f.mon.mem.Load(0x14)).ToBe(uint8(0x12))
+}
+
+func TestAssembleOne(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("a 10 i20 abcd \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("$0010:  20 cd ab  i20 $abcd\n")
+}
+
+func TestAssembleInvalid(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("a 10 \n x \n \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("unknown instruction: x\n")
+}
+
+func TestProfileOff(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("prof \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("profiling is not on\n")
+}
+
+// runningSys is the fixture without the tick callback so that the
+// machine does not quit when it stops.
+type runningSys struct {
+	fixtureSys
+}
+
+func (s runningSys) Spec() *machine.Spec {
+	spec := s.fixtureSys.Spec()
+	spec.TickCallback = nil
+	return spec
+}
+
+func TestProfileAfterOn(t *testing.T) {
+	sys := newFixtureCab(nil).(*fixtureSys)
+	c := memory.NewCursor(sys.mem)
+	c.PutN(0x2c, 0x10, 0x00, 0x2c, 0x10, 0x00, 0x2c, 0x10, 0x00)
+	c.Pos = 0x10
+	c.PutN(0x0c)
+	mon := NewMonitor(machine.New(runningSys{*sys}))
+	var out bytes.Buffer
+	mon.out.SetOutput(&out)
+
+	// The fixture runs one instruction per frame
+	path := testScript(t, "prof on\nfr 6\nprof\n")
+	defer os.Remove(path)
+	mon.in = testMonitorInput("source " + path + " \n q")
+	testMonitorRun(mon)
+	lines := strings.Split(out.String(), "\n")
+	report := []string{}
+	for i, line := range lines {
+		if line == "monitor> prof" {
+			report = lines[i+1:]
+			break
+		}
+	}
+	if len(report) < 4 {
+		t.Fatalf("no report: %v", out.String())
+	}
+	With(t).Expect(report[0]).ToBe("core 1: 6 instructions in 6 frames")
+	With(t).Expect(report[2]).ToBe("hot addresses                          count    per frame")
+	With(t).Expect(strings.Fields(report[3])).ToBe([]string{"$0010", "3", "0.5"})
+}
+
+// putCalls writes a program for the fixture that calls a subroutine at
+// $10 that calls another at $20.
+func putCalls(c *memory.Cursor) {
+	c.PutN(0x2c, 0x10, 0x00, 0x01)
+	c.Pos = 0x10
+	c.PutN(0x01, 0x2c, 0x20, 0x00, 0x0c)
+	c.Pos = 0x20
+	c.PutN(0x01, 0x0c)
+}
+
+func TestStepOver(t *testing.T) {
+	f := newTestMonitor()
+	putCalls(f.cursor)
+	f.mon.in = testMonitorInput("sov")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0003)
+}
+
+func TestStepOut(t *testing.T) {
+	f := newTestMonitor()
+	putCalls(f.cursor)
+	f.mon.in = testMonitorInput("s \n s \n s \n sout")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0014)
+}
+
+func TestStepOutTopLevel(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("sout \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("unable to step out: not in a subroutine\n")
+}
+
+func TestBacktrace(t *testing.T) {
+	f := newTestMonitor()
+	putCalls(f.cursor)
+	f.mon.mach.CodeInfo.Labels[0x0020] = "inner"
+	f.mon.in = testMonitorInput("s \n s \n s \n bt \n q")
+	testMonitorRun(f.mon)
+	want := "" +
+		"pc   $0020 inner\n" +
+		"call $0020 inner            from $0011  sp $0ffc\n" +
+		"call $0010                  from $0000  sp $0ffe\n"
+	With(t).Expect(strings.HasSuffix(f.out.String(), want)).ToBe(true)
+}
+
+func TestUntil(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("u 06")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0006)
+}
+
+func TestFrames(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("fr 3")
+	testMonitorRun(f.mon)
+	// One instruction is executed in each frame
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0003)
+}
+
+func TestCycles(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x10, 0x01, 0x10, 0x01, 0x10, 0x01, 0x10, 0x01)
+	f.mon.in = testMonitorInput("cy 2")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0004)
+}
+
+func TestFramesInvalid(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("fr 0 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("invalid number of frames: 0\n")
+}
+
+func testScript(t *testing.T, text string) string {
+	file, err := ioutil.TempFile("", "pac8-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestSource(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "# set a value\n\np 0900 ab\np 0900\n")
+	defer os.Remove(path)
+	f.mon.in = testMonitorInput("source " + path + " \n q")
+	testMonitorRun(f.mon)
+	want := "" +
+		"monitor> p 0900 ab\n" +
+		"monitor> p 0900\n" +
+		"$ab +171\n"
+	With(t).Expect(f.out.String()).ToBe(want)
+}
+
+func TestSourceAssemble(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "a 0900\ni01\ni01\n")
+	defer os.Remove(path)
+	f.mon.in = testMonitorInput("source " + path + " \n p 0901 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.HasSuffix(f.out.String(), "$01 +1\n")).ToBe(true)
+}
+
+func TestSourceError(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "p 0900 ab\nfoo\np 0900\n")
+	defer os.Remove(path)
+	f.mon.in = testMonitorInput("source " + path + " \n q")
+	testMonitorRun(f.mon)
+	want := "" +
+		"monitor> p 0900 ab\n" +
+		"monitor> foo\n" +
+		path + ":2: unknown command: foo\n"
+	With(t).Expect(f.out.String()).ToBe(want)
+}
+
+func TestSourceNested(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "")
+	defer os.Remove(path)
+	ioutil.WriteFile(path, []byte("source "+path+"\n"), 0644)
+	f.mon.in = testMonitorInput("source " + path + " \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.HasSuffix(f.out.String(), ": scripts nested too deeply\n")).ToBe(true)
+}
+
+func TestMacro(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("macro poke2 p {1} {2} ; p {1}+1 {2} \n poke2 0900 cd \n p 0901 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("$cd +205\n")
+}
+
+func TestMacroList(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("macro b2 b {*} \n macro a1 s \n macro a1 \n macro \n macro a1 off \n macro \n q")
+	testMonitorRun(f.mon)
+	want := "" +
+		"s\n" +
+		"a1: s\n" +
+		"b2: b {*}\n" +
+		"b2: b {*}\n"
+	With(t).Expect(f.out.String()).ToBe(want)
+}
+
+func TestMacroCommand(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("macro s p 0900 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("macro cannot replace a command: s\n")
+}
+
+func TestAssert(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("p 0900 3 \n assert (0900)==3 \n assert (0900)==4 \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.out.String()).ToBe("assertion failed: (0900)==4\n")
+	With(t).Expect(f.mon.Failures()).ToBe(1)
+}
+
+func TestRunBatch(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "p 0900 3\nassert (0900)==3\nassert (0900)==4\n")
+	defer os.Remove(path)
+	go f.mon.mach.Run()
+	err := f.mon.RunBatch(path)
+	f.mon.mach.Send(machine.QuitCmd)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	With(t).Expect(err.Error()).ToBe("1 assertion(s) failed")
+}
+
+func TestRunBatchWaits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home = dir
+	defer func() { home = "" }()
+	if err := os.MkdirAll(PathFor(Store, "fixture"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestMonitor()
+	path := testScript(t, "p 0900 3\nso 1\np 0900 4\nsi 1\nassert (0900)==3\n")
+	defer os.Remove(path)
+	go f.mon.mach.Run()
+	err = f.mon.RunBatch(path)
+	f.mon.mach.Send(machine.QuitCmd)
+	With(t).Expect(err).ToBe(nil)
+}
+
+func TestRunBatchQuit(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "p 0900 3\nassert (0900)==3\nq\nassert (0900)==4\n")
+	defer os.Remove(path)
+	go f.mon.mach.Run()
+	err := f.mon.RunBatch(path)
+	f.mon.mach.Send(machine.QuitCmd)
+	With(t).Expect(err).ToBe(nil)
+}
+
+func TestHookExec(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("on exec 02 p 0900 ab \n g")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x0900)).ToBe(0xab)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0003)
+}
+
+func TestHookHalt(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("on exec 02 h \n g")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0002)
+}
+
+func TestHookCommand(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	f.mon.in = testMonitorInput("on exec 02 g \n g")
+	testMonitorRun(f.mon)
+	With(t).Expect(strings.HasPrefix(f.out.String(), "hook 1: unable to use in a hook: g\n")).ToBe(true)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0002)
+}
+
+func TestHookList(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("on \n on frame p 0900 1 ; p 0901 2 \n on exec 02 h \n on \n on 1 off \n on \n on clear \n on \n q")
+	testMonitorRun(f.mon)
+	want := "" +
+		"no hooks\n" +
+		"1 frame: p 0900 1 ; p 0901 2\n" +
+		"2 exec $0002: h\n" +
+		"2 exec $0002: h\n" +
+		"no hooks\n"
+	With(t).Expect(f.out.String()).ToBe(want)
+}
+
+func TestLua(t *testing.T) {
+	f := newTestMonitor()
+	f.cursor.PutN(0x01, 0x01, 0x01)
+	path := testScript(t, `
+		emu.on_exec(2, function()
+			mem.write(0x0900, 0xab)
+			print("hit")
+			return true
+		end)
+	`)
+	defer os.Remove(path)
+	f.mon.in = testMonitorInput("lua " + path + " \n g")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x0900)).ToBe(0xab)
+	WithFormat(t, "%04x").Expect(f.mon.cpu.PC()).ToBe(0x0002)
+	With(t).Expect(strings.HasPrefix(f.out.String(), "hit\n")).ToBe(true)
+}
+
+func TestLuaOff(t *testing.T) {
+	f := newTestMonitor()
+	path := testScript(t, "emu.on_frame(function() end)\n")
+	defer os.Remove(path)
+	f.mon.in = testMonitorInput("lua \n lua " + path + " \n lua \n lua off \n lua \n q")
+	testMonitorRun(f.mon)
+	want := "no lua script\n" + path + "\nno lua script\n"
+	With(t).Expect(f.out.String()).ToBe(want)
+	With(t).Expect(len(f.mon.mach.Hooks())).ToBe(0)
+}
+
+func TestIf(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("p 0900 3 \n if (0900)==3 p 0901 7 \n if (0900)==4 p 0902 7 \n q")
+	testMonitorRun(f.mon)
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x0901)).ToBe(0x07)
+	WithFormat(t, "%02x").Expect(f.mon.mem.Load(0x0902)).ToBe(0x00)
+}
+
+func TestInput(t *testing.T) {
+	f := newTestMonitor()
+	f.mon.in = testMonitorInput("in coin left2 \n in jump \n q")
+	testMonitorRun(f.mon)
+	With(t).Expect(f.mon.mach.In.CoinSlot[0].Active).ToBe(true)
+	With(t).Expect(f.mon.mach.In.Joysticks[1].Left).ToBe(true)
+	With(t).Expect(f.out.String()).ToBe("invalid input: jump\n")
+}
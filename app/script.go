@@ -0,0 +1,214 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+)
+
+// maxDepth is the number of scripts and macros that can be run from
+// within each other. It stops a script that sources itself or a macro
+// that invokes itself.
+const maxDepth = 16
+
+// errQuit is returned when a script in batch mode quits.
+var errQuit = errors.New("quit")
+
+// runCmds start the machine. A script waits for the machine to stop before
+// running the next command.
+var runCmds = map[string]bool{
+	CmdCycles:   true,
+	CmdFrames:   true,
+	CmdGo:       true,
+	CmdStepOut:  true,
+	CmdStepOver: true,
+	CmdUntil:    true,
+}
+
+// Failures returns the number of assertions that have failed.
+func (m *Monitor) Failures() int {
+	return m.failures
+}
+
+// Source runs the monitor commands found in the file at path. Blank lines
+// and lines starting with "#" are ignored. Each command is shown before it
+// is run and a command that starts the machine is allowed to finish before
+// the next one is run. The script stops at the first command that fails.
+func (m *Monitor) Source(path string) error {
+	if m.depth >= maxDepth {
+		return errors.New("scripts nested too deeply")
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || (line == "" && !m.assembling) {
+			continue
+		}
+		m.out.Println(m.getPrompt() + line)
+		if err := m.run(line); err == errQuit {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("%v:%v: %v", path, n, err)
+		}
+	}
+	if m.assembling {
+		m.assembling = false
+		m.setPrompt()
+	}
+	return scanner.Err()
+}
+
+// RunBatch runs the script at path once the machine has processed any
+// commands already sent to it. A quit command ends the script. An error
+// is returned if the script stops early or if any assertions failed.
+func (m *Monitor) RunBatch(path string) error {
+	m.batch = true
+	m.wait()
+	if err := m.Source(path); err != nil && err != errQuit {
+		return err
+	}
+	if m.failures > 0 {
+		return fmt.Errorf("%v assertion(s) failed", m.failures)
+	}
+	return nil
+}
+
+// run executes line and waits for the machine to handle any commands sent
+// to it. If the machine was started, it waits for the machine to stop.
+func (m *Monitor) run(line string) error {
+	fields := strings.Fields(line)
+	started := !m.assembling && len(fields) > 0 && runCmds[fields[0]]
+	if err := m.exec(line); err != nil {
+		return err
+	}
+	if started {
+		m.wait()
+	} else {
+		m.onMach(func() {})
+	}
+	return nil
+}
+
+// wait blocks until the machine is no longer running.
+func (m *Monitor) wait() {
+	for {
+		running := false
+		m.mach.Do(func() { running = m.mach.Status == machine.Run })
+		if !running {
+			return
+		}
+		<-m.stopped
+	}
+}
+
+func (m *Monitor) source(args []string) error {
+	if err := checkLen(args, 1, 1); err != nil {
+		return err
+	}
+	return m.Source(args[0])
+}
+
+func (m *Monitor) assert(args []string) error {
+	if err := checkLen(args, 1, maxArgs); err != nil {
+		return err
+	}
+	str := strings.Join(args, " ")
+	e, err := m.compile(str)
+	if err != nil {
+		return fmt.Errorf("invalid expression: %v: %v", str, err)
+	}
+	var v int
+	m.onMach(func() { v = e() })
+	if v == 0 {
+		if m.parent != nil {
+			m.parent.failures++
+		} else {
+			m.failures++
+		}
+		m.out.Printf("assertion failed: %v", str)
+	}
+	return nil
+}
+
+func (m *Monitor) macro(args []string) error {
+	if len(args) == 0 {
+		names := make([]string, 0, len(m.macros))
+		for name := range m.macros {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			m.out.Printf("%v: %v", name, m.macros[name])
+		}
+		return nil
+	}
+	name := args[0]
+	if len(args) == 1 {
+		body, ok := m.macros[name]
+		if !ok {
+			return fmt.Errorf("no such macro: %v", name)
+		}
+		m.out.Println(body)
+		return nil
+	}
+	if len(args) == 2 && args[1] == "off" {
+		if _, ok := m.macros[name]; !ok {
+			return fmt.Errorf("no such macro: %v", name)
+		}
+		delete(m.macros, name)
+		return nil
+	}
+	if _, ok := helpCmds[name]; ok || name == CmdHelp || name == CmdQuitLong {
+		return fmt.Errorf("macro cannot replace a command: %v", name)
+	}
+	m.macros[name] = strings.Join(args[1:], " ")
+	return nil
+}
+
+// runMacro runs each command, separated by semicolons, in body. The
+// arguments given to the macro replace {1} to {9} and {*} is replaced
+// with all of the arguments.
+func (m *Monitor) runMacro(body string, args []string) error {
+	if m.depth >= maxDepth {
+		return errors.New("macros nested too deeply")
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	pairs := []string{"{*}", strings.Join(args, " ")}
+	for i := 1; i <= 9; i++ {
+		arg := ""
+		if i <= len(args) {
+			arg = args[i-1]
+		}
+		pairs = append(pairs, "{"+strconv.Itoa(i)+"}", arg)
+	}
+	body = strings.NewReplacer(pairs...).Replace(body)
+	for _, line := range strings.Split(body, ";") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := m.run(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+// Entry points used by the CPU when it resets or is interrupted
+var resetVectors = []uint16{0x0000, 0x0038, 0x0066}
+
+const (
+	indent     = "        "
+	bytesPerDB = 8
+)
+
+type exporter struct {
+	mem     memory.Memory
+	info    *proc.CodeInfo
+	trace   *proc.Disassembler
+	code    map[uint16]proc.Statement // instructions by address
+	words   map[uint16]bool           // interrupt vectors in the table
+	used    []bool                    // bytes that are code or vectors
+	targets map[uint16]bool           // addresses jumped to or called
+	queue   []uint16
+	regI    int // value of the I register, or -1 if not known
+}
+
+func newExporter(mem memory.Memory, info *proc.CodeInfo) *exporter {
+	if info == nil {
+		info = proc.NewCodeInfo()
+	}
+	trace := z80.NewDisassembler(mem)
+	trace.CodeInfo = nil
+	return &exporter{
+		mem:     mem,
+		info:    info,
+		trace:   trace,
+		code:    make(map[uint16]proc.Statement),
+		words:   make(map[uint16]bool),
+		used:    make([]bool, mem.Length()),
+		targets: make(map[uint16]bool),
+		regI:    -1,
+	}
+}
+
+func (x *exporter) inRange(addr int) bool {
+	return addr >= 0 && addr < x.mem.Length()
+}
+
+func (x *exporter) jump(addr uint16) {
+	if !x.inRange(int(addr)) {
+		return
+	}
+	x.targets[addr] = true
+	x.queue = append(x.queue, addr)
+}
+
+// vector adds an entry in the IM 2 vector table at addr and follows the
+// code that it points to.
+func (x *exporter) vector(addr uint16) {
+	if !x.inRange(int(addr)+1) || x.words[addr] {
+		return
+	}
+	if !x.used[addr] && !x.used[addr+1] {
+		x.words[addr] = true
+		x.used[addr] = true
+		x.used[addr+1] = true
+	}
+	x.jump(uint16(x.mem.Load(addr)) | uint16(x.mem.Load(addr+1))<<8)
+}
+
+// run follows the flow of code from all addresses in the queue. Each
+// instruction found is decoded once. Decoding stops at an instruction that
+// ends the flow, at an instruction that overlaps one already found, or at
+// the end of memory.
+func (x *exporter) run() {
+	for len(x.queue) > 0 {
+		addr := x.queue[0]
+		x.queue = x.queue[1:]
+		x.follow(addr)
+	}
+}
+
+func (x *exporter) follow(addr uint16) {
+	lastA := -1
+	for {
+		if _, done := x.code[addr]; done || !x.inRange(int(addr)) {
+			return
+		}
+		x.trace.SetPC(addr)
+		s := x.trace.NextStatement()
+		end := int(addr) + len(s.Bytes)
+		if !x.inRange(end - 1) {
+			return
+		}
+		for i := int(addr); i < end; i++ {
+			if x.used[i] {
+				return
+			}
+		}
+		for i := int(addr); i < end; i++ {
+			x.used[i] = true
+		}
+		x.code[addr] = s
+
+		next, cont := x.flow(s)
+		switch {
+		case s.Bytes[0] == 0x3e: // ld a,n
+			lastA = int(s.Bytes[1])
+			addr = uint16(end)
+			continue
+		case len(s.Bytes) == 2 && s.Bytes[0] == 0xed && s.Bytes[1] == 0x47: // ld i,a
+			if lastA >= 0 {
+				x.regI = lastA
+			}
+		case s.Bytes[0] == 0xd3: // out (n),a
+			// The low byte of the IM 2 vector is put on the data bus by
+			// writing to an output port.
+			if lastA >= 0 && x.regI >= 0 {
+				x.vector(uint16(x.regI)<<8 | uint16(lastA&0xfe))
+			}
+		default:
+			lastA = -1
+		}
+		if next >= 0 {
+			x.jump(uint16(next))
+		}
+		if !cont {
+			return
+		}
+		addr = uint16(end)
+	}
+}
+
+// flow returns the target of a jump or call in s, or -1 if there is none
+// or it is not known. The flag is true if execution can continue with the
+// instruction that follows.
+func (x *exporter) flow(s proc.Statement) (int, bool) {
+	fields := strings.Fields(s.Op)
+	if len(fields) == 0 {
+		return -1, true
+	}
+	mnemonic := fields[0]
+	var args []string
+	if len(fields) > 1 {
+		args = strings.Split(fields[1], ",")
+	}
+	target := -1
+	if len(args) > 0 && strings.HasPrefix(args[len(args)-1], "$") {
+		v, err := strconv.ParseUint(args[len(args)-1][1:], 16, 16)
+		if err == nil {
+			target = int(v)
+		}
+	}
+	switch mnemonic {
+	case "jp", "jr":
+		if len(args) == 1 {
+			// Unconditional jump. The target of jp (hl) is not known.
+			return target, false
+		}
+		return target, true
+	case "djnz", "call", "rst":
+		return target, true
+	case "ret":
+		return -1, len(args) > 0
+	case "reti", "retn":
+		return -1, false
+	}
+	return -1, true
+}
+
+var indexRegexp = regexp.MustCompile(`\((i[xy])\+\$([0-9a-f]{2})\)`)
+
+// source returns the statement as it should be written for an assembler.
+// The flag is false if the statement has no standard form that assembles
+// back to the same bytes and it must be written as data instead.
+func source(s proc.Statement) (string, bool) {
+	op := s.Op
+	fields := strings.Fields(op)
+	if len(fields) == 0 || strings.HasPrefix(op, "?") {
+		return op, false
+	}
+	switch fields[0] {
+	case "sls":
+		return op, false
+	case "oti":
+		op = "outi"
+	case "otd":
+		op = "outd"
+	}
+	for _, reg := range []string{"ixh", "ixl", "iyh", "iyl"} {
+		if strings.Contains(op, reg) {
+			return op, false
+		}
+	}
+	if len(s.Bytes) == 2 && s.Bytes[0] == 0xed {
+		switch s.Bytes[1] {
+		case 0x63, 0x6b:
+			// ld (nn),hl and ld hl,(nn) have a shorter encoding
+			return op, false
+		case 0x70, 0x71:
+			// in f,(c) and out (c),f are undocumented
+			return op, false
+		}
+	}
+	// Displacements are signed
+	op = indexRegexp.ReplaceAllStringFunc(op, func(m string) string {
+		parts := indexRegexp.FindStringSubmatch(m)
+		d, _ := strconv.ParseUint(parts[2], 16, 8)
+		if d < 0x80 {
+			return m
+		}
+		return fmt.Sprintf("(%v-$%02x)", parts[1], 0x100-d)
+	})
+	return op, true
+}
+
+func (x *exporter) label(addr uint16) string {
+	if name, ok := x.info.Labels[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("L%04x", addr)
+}
+
+// write emits the listing. Jump and call targets are given labels before
+// the instructions are decoded again for output so the labels are used
+// as operands.
+func (x *exporter) write(w io.Writer, title string) error {
+	for addr := range x.targets {
+		x.info.Labels[addr] = x.label(addr)
+	}
+
+	// Labels that are not at the start of an instruction or data are
+	// given values instead.
+	starts := func(addr uint16) bool {
+		if !x.inRange(int(addr)) {
+			return false
+		}
+		_, isCode := x.code[addr]
+		return isCode || x.words[addr] || !x.used[addr]
+	}
+	equs := make([]int, 0, 0)
+	for addr := range x.info.Labels {
+		if !starts(addr) {
+			equs = append(equs, int(addr))
+		}
+	}
+	sort.Ints(equs)
+
+	out := &listing{w: w}
+	out.printf("; %v\n", title)
+	out.printf("; generated by pac8-dasm\n\n")
+	for _, addr := range equs {
+		out.printf("%-15s equ  $%04x\n", x.info.Labels[uint16(addr)], addr)
+	}
+	if len(equs) > 0 {
+		out.printf("\n")
+	}
+	out.printf("%vorg  $0000\n\n", indent)
+
+	dasm := z80.NewDisassembler(x.mem)
+	dasm.CodeInfo = x.info
+	var data []string
+	var dataComment string
+	flush := func() {
+		if len(data) > 0 {
+			out.line("db   "+strings.Join(data, ","), dataComment)
+		}
+		data, dataComment = nil, ""
+	}
+	for i := 0; i < x.mem.Length(); {
+		addr := uint16(i)
+		label, hasLabel := x.info.Labels[addr]
+		comment := x.info.Comments[addr]
+		if hasLabel || comment != "" || len(data) == bytesPerDB || x.used[i] {
+			flush()
+		}
+		if hasLabel {
+			out.printf("%v:\n", label)
+		}
+		if s, ok := x.code[addr]; ok {
+			dasm.SetPC(addr)
+			s = dasm.NextStatement()
+			op, ok := source(s)
+			if ok {
+				out.line(op, s.Comment)
+			} else {
+				vals := make([]string, len(s.Bytes))
+				for j, b := range s.Bytes {
+					vals[j] = fmt.Sprintf("$%02x", b)
+				}
+				out.line("db   "+strings.Join(vals, ","), op)
+			}
+			i += len(s.Bytes)
+			continue
+		}
+		if x.words[addr] {
+			target := uint16(x.mem.Load(addr)) | uint16(x.mem.Load(addr+1))<<8
+			value := fmt.Sprintf("$%04x", target)
+			if name, ok := x.info.Labels[target]; ok {
+				value = name
+			}
+			out.line("dw   "+value, comment)
+			i += 2
+			continue
+		}
+		if len(data) == 0 {
+			dataComment = comment
+		}
+		data = append(data, fmt.Sprintf("$%02x", x.mem.Load(addr)))
+		i++
+	}
+	flush()
+	return out.err
+}
+
+type listing struct {
+	w   io.Writer
+	err error
+}
+
+func (l *listing) printf(format string, a ...interface{}) {
+	if l.err != nil {
+		return
+	}
+	_, l.err = fmt.Fprintf(l.w, format, a...)
+}
+
+func (l *listing) line(op string, comment string) {
+	if comment == "" {
+		l.printf("%v%v\n", indent, op)
+		return
+	}
+	l.printf("%v%-32s ; %v\n", indent, op, comment)
+}
+
+// export writes a listing of the program in mem that can be assembled
+// back to the same bytes. Code is found by following the flow of
+// instructions from the reset and interrupt vectors, the addresses in
+// entries, and the IM 2 vector tables at the addresses in vectors. The
+// names and comments in info are used in the listing. Everything else is
+// written as data.
+func export(w io.Writer, title string, mem memory.Memory, entries []uint16, vectors []uint16, info *proc.CodeInfo) error {
+	x := newExporter(mem, info)
+	for _, addr := range vectors {
+		x.vector(addr)
+	}
+	for _, addr := range resetVectors {
+		x.jump(addr)
+	}
+	for _, addr := range entries {
+		x.jump(addr)
+	}
+	x.run()
+	return x.write(w, title)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+func TestExport(t *testing.T) {
+	rom := make([]uint8, 0x50)
+	copy(rom[0x00:], []uint8{
+		0x3e, 0x00, // ld a,$00
+		0xed, 0x47, // ld i,a
+		0x3e, 0x40, // ld a,$40
+		0xd3, 0x00, // out ($00),a
+		0xed, 0x5e, // im 2
+		0x18, 0xfe, // jr $000a
+	})
+	copy(rom[0x10:], []uint8{
+		0xdd, 0x7e, 0xff, // ld a,(ix-$01)
+		0xdd, 0x7c, // ld a,ixh
+		0xc9, // ret
+		0xaa, 0xbb,
+	})
+	copy(rom[0x38:], []uint8{
+		0xcd, 0x10, 0x00, // call $0010
+		0xfb, // ei
+		0xc9, // ret
+	})
+	copy(rom[0x40:], []uint8{0x10, 0x00}) // vector
+	rom[0x4f] = 0xed
+	info := proc.NewCodeInfo()
+	info.Comments[0x0038] = "interrupt"
+	info.Labels[0x4e00] = "credits"
+
+	var out bytes.Buffer
+	mem := memory.NewROM(rom)
+	if err := export(&out, "test", mem, nil, nil, info); err != nil {
+		t.Fatal(err)
+	}
+	want := `; test
+; generated by pac8-dasm
+
+credits         equ  $4e00
+
+        org  $0000
+
+L0000:
+        ld   a,$00
+        ld   i,a
+        ld   a,$40
+        out  ($00),a
+        im   2
+L000a:
+        jr   L000a
+        db   $00,$00,$00,$00
+L0010:
+        ld   a,(ix-$01)
+        db   $dd,$7c                     ; ld   a,ixh
+        ret
+        db   $aa,$bb,$00,$00,$00,$00,$00,$00
+        db   $00,$00,$00,$00,$00,$00,$00,$00
+        db   $00,$00,$00,$00,$00,$00,$00,$00
+        db   $00,$00,$00,$00,$00,$00,$00,$00
+        db   $00,$00
+L0038:
+        call L0010                       ; interrupt
+        ei
+        ret
+        db   $00,$00,$00
+        dw   L0010
+        db   $00,$00,$00,$00,$00,$00,$00,$00
+        db   $00,$00,$00,$00,$00,$ed
+`
+	With(t).Expect(out.String()).ToBe(want)
+
+	rebuilt, err := assemble(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(fmt.Sprintf("% x", rebuilt)).ToBe(fmt.Sprintf("% x", rom))
+}
+
+// assemble builds a listing written by export. The first pass finds the
+// address of each label and the second pass emits the bytes.
+func assemble(listing string) ([]uint8, error) {
+	labels := make(map[string]int)
+	var out []uint8
+	for pass := 1; pass <= 2; pass++ {
+		out = nil
+		eval := func(str string) (int, error) {
+			if v, ok := labels[str]; ok {
+				return v, nil
+			}
+			if pass == 1 {
+				if _, err := z80.ParseValue(str); err != nil {
+					return len(out), nil
+				}
+			}
+			return z80.ParseValue(str)
+		}
+		for _, line := range strings.Split(listing, "\n") {
+			if i := strings.Index(line, ";"); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case strings.HasSuffix(fields[0], ":"):
+				labels[strings.TrimSuffix(fields[0], ":")] = len(out)
+			case len(fields) == 3 && fields[1] == "equ":
+				v, err := z80.ParseValue(fields[2])
+				if err != nil {
+					return nil, err
+				}
+				labels[fields[0]] = v
+			case fields[0] == "org":
+			case fields[0] == "db":
+				for _, v := range strings.Split(fields[1], ",") {
+					b, err := eval(v)
+					if err != nil {
+						return nil, err
+					}
+					out = append(out, uint8(b))
+				}
+			case fields[0] == "dw":
+				w, err := eval(fields[1])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, uint8(w), uint8(w>>8))
+			default:
+				b, err := z80.Assemble(line, uint16(len(out)), eval)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, b...)
+			}
+		}
+	}
+	return out, nil
+}
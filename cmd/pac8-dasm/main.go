@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/app"
+	"github.com/blackchip-org/pac8/pkg/proc"
+)
+
+var (
+	gameName string
+	romName  string
+	outFile  string
+	entries  string
+	vectors  string
+)
+
+func init() {
+	flag.StringVar(&gameName, "g", "pacman", "use this game")
+	flag.StringVar(&romName, "r", "", "disassemble this ROM `group` (default code or code1)")
+	flag.StringVar(&outFile, "o", "", "write listing to this `file` instead of standard output")
+	flag.StringVar(&entries, "entry", "", "comma separated `addresses` of additional code")
+	flag.StringVar(&vectors, "im2", "", "comma separated `addresses` of IM 2 vectors")
+
+	flag.Usage = func() {
+		o := flag.CommandLine.Output()
+		fmt.Fprintf(o, "Usage: pac8-dasm [options]\n\n")
+		flag.PrintDefaults()
+	}
+}
+
+func parseAddresses(str string) ([]uint16, error) {
+	addrs := make([]uint16, 0, 0)
+	for _, field := range strings.Split(str, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		value := strings.TrimPrefix(strings.TrimPrefix(field, "$"), "0x")
+		addr, err := strconv.ParseUint(value, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address: %v", field)
+		}
+		addrs = append(addrs, uint16(addr))
+	}
+	return addrs, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	game, ok := app.Games[gameName]
+	if !ok {
+		log.Fatalf("no such game: %v", gameName)
+	}
+	roms, err := game.ROM.Load(app.PathFor(app.ROM, gameName))
+	if err != nil {
+		log.Fatalf("unable to load roms: %v", err)
+	}
+	if romName == "" {
+		romName = "code"
+		if _, ok := roms[romName]; !ok {
+			romName = "code1"
+		}
+	}
+	mem, ok := roms[romName]
+	if !ok {
+		names := make([]string, 0, 0)
+		for name := range roms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Fatalf("no such rom: %v (available: %v)", romName, strings.Join(names, ", "))
+	}
+
+	entryAddrs, err := parseAddresses(entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	vectorAddrs, err := parseAddresses(vectors)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	info := proc.NewCodeInfo()
+	symbols := app.PathFor(app.Ext, gameName, app.SymbolFileName)
+	if codeInfo, err := proc.LoadSymbols(symbols); err == nil {
+		info = codeInfo
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("unable to load symbols: %v", err)
+	}
+
+	out := os.Stdout
+	if outFile != "" {
+		out, err = os.Create(outFile)
+		if err != nil {
+			log.Fatalf("unable to create listing: %v", err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	title := fmt.Sprintf("%v %v", gameName, romName)
+	if err := export(w, title, mem, entryAddrs, vectorAddrs, info); err != nil {
+		log.Fatalf("unable to write listing: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("unable to write listing: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("unable to write listing: %v", err)
+	}
+}
@@ -25,6 +25,26 @@ type view struct {
 }
 
 var views = map[string]view{
+	"galaga:colors": view{
+		system: "galaga",
+		roms:   game.Galaga.ROM,
+		render: func(r *sdl.Renderer, roms memory.Set) (video.Sheet, error) {
+			config := galaga.VideoConfig
+			colors := namco.ColorTable(roms["color"], config)
+			return video.NewColorSheet(r, []video.Palette{colors})
+		},
+	},
+	"galaga:palettes": view{
+		system: "galaga",
+		roms:   game.Galaga.ROM,
+		render: func(r *sdl.Renderer, roms memory.Set) (video.Sheet, error) {
+			config := galaga.VideoConfig
+			colors := namco.ColorTable(roms["color"], config)
+			palettes := namco.PaletteTable(roms["palette"], config, colors[config.TileColors:])
+			sprites := namco.PaletteTable(roms["sprite-palette"], config, colors)
+			return video.NewColorSheet(r, append(palettes, sprites...))
+		},
+	},
 	"galaga:sprites": view{
 		system: "galaga",
 		roms:   game.Galaga.ROM,
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"crypto/sha1"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime/pprof"
 
 	"github.com/blackchip-org/pac8/app"
+	"github.com/blackchip-org/pac8/pkg/api"
+	"github.com/blackchip-org/pac8/pkg/gdb"
+	"github.com/blackchip-org/pac8/pkg/input"
+	"github.com/blackchip-org/pac8/pkg/lua"
 	"github.com/blackchip-org/pac8/pkg/machine"
 	"github.com/blackchip-org/pac8/pkg/pac8"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	"github.com/blackchip-org/pac8/pkg/video"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -20,11 +28,24 @@ const (
 
 var (
 	gameName      string
+	apiAddr       string
 	cprof         bool
+	dumpFile      string
+	frames        int
+	gdbAddr       string
+	headless      bool
+	inputScript   string
+	luaFile       string
+	moviePlay     string
+	movieRec      string
+	profFile      string
+	recAudio      string
+	recVideo      string
 	monitorEnable bool
 	noAudio       bool
 	noVideo       bool
 	restore       bool
+	scriptFile    string
 	slowStart     bool
 	trace         bool
 	wait          bool
@@ -32,12 +53,25 @@ var (
 
 func init() {
 	flag.StringVar(&gameName, "g", "pacman", "use this game")
+	flag.StringVar(&apiAddr, "api", "", "serve the JSON-RPC API at this `address` (e.g. :8080)")
 	flag.BoolVar(&cprof, "cprof", false, "enable cpu profiling")
+	flag.StringVar(&dumpFile, "dump", "", "write memory to this file after a headless run")
+	flag.IntVar(&frames, "frames", 600, "number of frames to run when headless")
+	flag.StringVar(&gdbAddr, "gdb", "", "listen for a remote debugger at this `address` (e.g. :1234)")
+	flag.BoolVar(&headless, "headless", false, "run without video, audio, or monitor and then report")
+	flag.StringVar(&inputScript, "input", "", "use input from this script when headless")
+	flag.StringVar(&luaFile, "lua", "", "run the Lua script in this file")
 	flag.BoolVar(&monitorEnable, "m", false, "start monitor")
+	flag.StringVar(&moviePlay, "movie-play", "", "play the input movie in this file")
+	flag.StringVar(&movieRec, "movie-rec", "", "record an input movie to this file")
 	flag.BoolVar(&noAudio, "no-audio", false, "disable audio device")
 	flag.BoolVar(&noVideo, "no-video", false, "disable video device")
+	flag.StringVar(&profFile, "prof", "", "count executed instructions and write a JSON report to this file on exit")
 	flag.BoolVar(&restore, "r", false, "restore from previous snapshot")
+	flag.StringVar(&recAudio, "rec-audio", "", "record audio to this WAV file")
+	flag.StringVar(&recVideo, "rec-video", "", "record video to this GIF file or directory of PNG files")
 	flag.BoolVar(&slowStart, "s", false, "slow start -- skip any POST bypass")
+	flag.StringVar(&scriptFile, "script", "", "run monitor commands from this file, then quit unless -m is used")
 	flag.BoolVar(&trace, "t", false, "enable tracing on start")
 	flag.BoolVar(&wait, "w", false, "wait for go command")
 }
@@ -61,7 +95,26 @@ func main() {
 		}()
 	}
 
-	if noVideo || trace || wait {
+	if headless && gdbAddr != "" {
+		log.Fatal("unable to use -gdb with -headless")
+	}
+	if headless && apiAddr != "" {
+		log.Fatal("unable to use -api with -headless")
+	}
+	if headless && scriptFile != "" {
+		log.Fatal("unable to use -script with -headless")
+	}
+	if !headless && inputScript != "" {
+		log.Fatal("unable to use -input without -headless")
+	}
+	// Without the monitor, a script is run in batch mode and the machine
+	// only runs when the script says so
+	batch := scriptFile != "" && !monitorEnable
+	if headless {
+		noVideo, noAudio, monitorEnable, wait = true, true, false, false
+	} else if batch {
+		monitorEnable, wait = true, true
+	} else if noVideo || trace || wait {
 		monitorEnable = true
 	}
 
@@ -69,8 +122,11 @@ func main() {
 	if !ok {
 		log.Fatalf("no such game: %v", gameName)
 	}
-	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
-		log.Fatalf("unable to initialize sdl: %v", err)
+	if !headless {
+		if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
+			log.Fatalf("unable to initialize sdl: %v", err)
+		}
+		defer sdl.Quit()
 	}
 
 	romDir := app.PathFor(app.ROM, gameName)
@@ -79,7 +135,13 @@ func main() {
 		log.Fatalf("unable to load roms\n%v\n", err)
 	}
 
-	defer sdl.Quit()
+	var script *input.Script
+	if inputScript != "" {
+		script, err = input.LoadScript(inputScript)
+		if err != nil {
+			log.Fatalf("unable to load input script: %v", err)
+		}
+	}
 
 	var env = pac8.Env{}
 	if !noVideo {
@@ -130,10 +192,28 @@ func main() {
 		log.Fatalf("unable to start game: %v", err)
 	}
 	m := machine.New(sys)
+	m.StoreDir = runtimeDir
+	m.ROM = game.ROM
+
+	symbols := app.PathFor(app.Ext, gameName, app.SymbolFileName)
+	if codeInfo, err := proc.LoadSymbols(symbols); err == nil {
+		*m.CodeInfo = *codeInfo
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("unable to load symbols: %v", err)
+	}
 
 	if trace {
 		m.Send(machine.TraceCmd)
 	}
+	if profFile != "" {
+		m.Send(machine.ProfileCmd, false)
+	}
+	if recAudio != "" {
+		m.Send(machine.RecordAudioCmd, recAudio)
+	}
+	if recVideo != "" {
+		m.Send(machine.RecordVideoCmd, recVideo)
+	}
 
 	var mon *app.Monitor
 	if monitorEnable {
@@ -141,13 +221,34 @@ func main() {
 		defer func() {
 			mon.Close()
 		}()
+	}
+	if gdbAddr != "" {
+		server := gdb.NewServer(m)
 		go func() {
-			err := mon.Run()
-			if err != nil {
-				log.Fatalf("monitor error: %v", err)
+			if err := server.ListenAndServe(gdbAddr); err != nil {
+				log.Fatalf("gdb server error: %v", err)
+			}
+		}()
+	}
+	if apiAddr != "" {
+		server := api.NewServer(m)
+		go func() {
+			if err := server.ListenAndServe(apiAddr); err != nil {
+				log.Fatalf("api server error: %v", err)
 			}
 		}()
 	}
+	// The machine is not running yet so the script can be loaded here
+	// instead of on the machine goroutine
+	if luaFile != "" {
+		script, err := lua.New(m)
+		if err != nil {
+			log.Fatalf("unable to load lua script: %v", err)
+		}
+		if err := script.DoFile(luaFile); err != nil {
+			log.Fatalf("lua error: %v", err)
+		}
+	}
 	if !wait {
 		m.Send(machine.StartCmd)
 	}
@@ -161,5 +262,102 @@ func main() {
 			m.Send(machine.RestoreCmd, filename)
 		}
 	}
-	m.Run()
+	// Movies start from the state of the machine so send these after
+	// any snapshot is restored
+	if moviePlay != "" {
+		m.Send(machine.MoviePlayCmd, moviePlay)
+	} else if movieRec != "" {
+		m.Send(machine.MovieRecordCmd, movieRec)
+	}
+	batchDone := make(chan error, 1)
+	if batch {
+		go func() {
+			batchDone <- mon.RunBatch(scriptFile)
+			m.Send(machine.QuitCmd)
+		}()
+	} else if monitorEnable {
+		go func() {
+			if scriptFile != "" {
+				if err := mon.Source(scriptFile); err != nil {
+					log.Printf("script error: %v", err)
+				}
+			}
+			err := mon.Run()
+			if err != nil {
+				log.Fatalf("monitor error: %v", err)
+			}
+		}()
+	}
+	if headless {
+		runHeadless(m, script)
+	} else {
+		m.Run()
+	}
+	if profFile != "" {
+		writeProfiles(m)
+	}
+	if batch {
+		select {
+		case err := <-batchDone:
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal("script did not run to the end")
+		}
+	}
+}
+
+func writeProfiles(m *machine.Mach) {
+	reports := make([]*machine.ProfileReport, 0, len(m.Cores))
+	for i := range m.Cores {
+		r, err := m.Profile(i, 0, -1)
+		if err != nil {
+			log.Printf("unable to create profile: %v", err)
+			return
+		}
+		reports = append(reports, r)
+		if headless {
+			fmt.Println()
+			r.WriteText(os.Stdout, 10)
+		}
+	}
+	f, err := os.Create(profFile)
+	if err != nil {
+		log.Fatalf("unable to create profile: %v", err)
+	}
+	defer f.Close()
+	if err := machine.WriteProfiles(f, reports); err != nil {
+		log.Fatalf("unable to write profile: %v", err)
+	}
+}
+
+func runHeadless(m *machine.Mach, script *input.Script) {
+	m.EventCallback = func(evt machine.EventType, arg interface{}) {
+		if evt == machine.ErrorEvent {
+			log.Fatal(arg)
+		}
+	}
+	m.RunHeadless(frames, script)
+
+	// Only the RAM is read. Reading through the memory of a core would
+	// also read the I/O devices mapped there, which changes them.
+	ram := make([]byte, 0)
+	for _, mem := range m.System.Spec().RAM {
+		for addr := 0; addr < mem.Length(); addr++ {
+			ram = append(ram, mem.Load(uint16(addr)))
+		}
+	}
+	fmt.Printf("frames: %v\n", frames)
+	fmt.Printf("ram:    %x\n", sha1.Sum(ram))
+	if f, ok := m.Display.(video.Framer); ok {
+		fmt.Printf("frame:  %x\n", sha1.Sum(f.Frame().Pix))
+	} else {
+		fmt.Printf("frame:  none\n")
+	}
+	if dumpFile != "" {
+		if err := ioutil.WriteFile(dumpFile, ram, 0644); err != nil {
+			log.Fatalf("unable to write memory dump: %v", err)
+		}
+	}
 }
@@ -374,7 +374,7 @@ func processMain(tab *regtab, op uint8) string {
 					return "calla(c, c.loadImm16)"
 				}
 				if p == 1 && tab.name == "un" {
-					return "ddfd(c, opsDD, opsDDCB)"
+					return "ddfd(c, opsDD, opsDDCB, cyclesDD, cyclesDDCB)"
 				}
 				if p == 1 && tab.name != "un" {
 					return "noni(c)"
@@ -383,7 +383,7 @@ func processMain(tab *regtab, op uint8) string {
 					return "ed(c)"
 				}
 				if p == 3 && tab.name == "un" {
-					return "ddfd(c, opsFD, opsFDCB)"
+					return "ddfd(c, opsFD, opsFDCB, cyclesFD, cyclesFDCB)"
 				}
 				if p == 3 && tab.name != "un" {
 					return "noni(c)"
@@ -744,6 +744,209 @@ func processXCB(tab *regtab, op uint8) string {
 	return ""
 }
 
+// Number of T-states for each unprefixed instruction. For conditional
+// instructions, this is the number of T-states when the condition is not
+// met. The prefix opcodes of cb, dd, ed, and fd are zero since the cost is
+// found in the table for the prefixed instruction.
+func cyclesMain(tab *regtab, op uint8) int {
+	x := int(bits.Slice(op, 6, 7))
+	y := int(bits.Slice(op, 3, 5))
+	z := int(bits.Slice(op, 0, 2))
+	p := int(bits.Slice(op, 4, 5))
+	q := int(bits.Slice(op, 3, 3))
+
+	if x == 0 {
+		if z == 0 {
+			switch y {
+			case 0, 1:
+				return 4 // nop, ex af, af'
+			case 2:
+				return 8 // djnz
+			case 3:
+				return 12 // jr
+			}
+			return 7 // jr cc
+		}
+		if z == 1 {
+			if q == 0 {
+				return 10 // ld rp, nn
+			}
+			return 11 // add hl, rp
+		}
+		if z == 2 {
+			if p == 2 {
+				return 16 // ld (nn), hl; ld hl, (nn)
+			}
+			if p == 3 {
+				return 13 // ld (nn), a; ld a, (nn)
+			}
+			return 7
+		}
+		if z == 3 {
+			return 6 // inc/dec rp
+		}
+		if z == 4 || z == 5 {
+			if y == 6 {
+				return 11 // inc/dec (hl)
+			}
+			return 4
+		}
+		if z == 6 {
+			if y == 6 {
+				return 10 // ld (hl), n
+			}
+			return 7
+		}
+		return 4
+	}
+	if x == 1 {
+		if y == 6 && z == 6 {
+			return 4 // halt
+		}
+		if y == 6 || z == 6 {
+			return 7
+		}
+		return 4
+	}
+	if x == 2 {
+		if z == 6 {
+			return 7
+		}
+		return 4
+	}
+	// x == 3
+	switch z {
+	case 0:
+		return 5 // ret cc
+	case 1:
+		if q == 0 {
+			return 10 // pop
+		}
+		switch p {
+		case 0:
+			return 10 // ret
+		case 1, 2:
+			return 4 // exx, jp (hl)
+		}
+		return 6 // ld sp, hl
+	case 2:
+		return 10 // jp cc
+	case 3:
+		switch y {
+		case 0:
+			return 10 // jp nn
+		case 1:
+			return 0 // cb prefix
+		case 2, 3:
+			return 11 // out (n), a; in a, (n)
+		case 4:
+			return 19 // ex (sp), hl
+		}
+		return 4 // ex de, hl; di; ei
+	case 4:
+		return 10 // call cc
+	case 5:
+		if q == 0 {
+			return 11 // push
+		}
+		if p == 0 {
+			return 17 // call
+		}
+		return 0 // dd, ed, fd prefix
+	case 6:
+		return 7
+	}
+	return 11 // rst
+}
+
+// Number of T-states for each dd or fd prefixed instruction. This includes
+// the four T-states for fetching the prefix.
+func cyclesIndex(tab *regtab, op uint8) int {
+	if op == 0xcb {
+		return 0 // ddcb and fdcb prefix
+	}
+	if op == 0xdd || op == 0xfd {
+		return 8 // prefix ignored, next prefix consumed
+	}
+	fn := processMain(tab, op)
+	n := 4 + cyclesMain(un, op)
+	if strings.Contains(fn, "IndIX") || strings.Contains(fn, "IndIY") {
+		// Fetch of displacement and the address calculation. When loading
+		// an immediate value, the address calculation overlaps with the
+		// fetch of that value.
+		if strings.Contains(fn, "loadImm") {
+			return n + 5
+		}
+		return n + 8
+	}
+	return n
+}
+
+// Number of T-states for each cb prefixed instruction, including the
+// prefix.
+func cyclesCB(tab *regtab, op uint8) int {
+	x := int(bits.Slice(op, 6, 7))
+	z := int(bits.Slice(op, 0, 2))
+	if z != 6 {
+		return 8
+	}
+	if x == 1 {
+		return 12 // bit n, (hl)
+	}
+	return 15
+}
+
+// Number of T-states for each ed prefixed instruction, including the
+// prefix. For block instructions that repeat, this is the number of
+// T-states for the final iteration.
+func cyclesED(tab *regtab, op uint8) int {
+	x := int(bits.Slice(op, 6, 7))
+	y := int(bits.Slice(op, 3, 5))
+	z := int(bits.Slice(op, 0, 2))
+
+	if x == 1 {
+		switch z {
+		case 0, 1:
+			return 12 // in r, (c); out (c), r
+		case 2:
+			return 15 // sbc/adc hl, rp
+		case 3:
+			return 20 // ld (nn), rp; ld rp, (nn)
+		case 4, 6:
+			return 8 // neg; im
+		case 5:
+			return 14 // retn, reti
+		}
+		switch y {
+		case 0, 1, 2, 3:
+			return 9 // ld i, a; ld r, a; ld a, i; ld a, r
+		case 4, 5:
+			return 18 // rrd, rld
+		}
+		return 8
+	}
+	if x == 2 && y >= 4 && z <= 3 {
+		return 16
+	}
+	return 8 // invalid, acts as two nops
+}
+
+// Number of T-states for each ddcb or fdcb prefixed instruction, including
+// the prefixes.
+func cyclesXCB(tab *regtab, op uint8) int {
+	x := int(bits.Slice(op, 6, 7))
+	if x == 1 {
+		return 20 // bit n, (ix+d)
+	}
+	return 23
+}
+
+func processCycles(out *bytes.Buffer, getFn func(*regtab, uint8) int, tab *regtab) {
+	for i := 0; i < 0x100; i++ {
+		out.WriteString(fmt.Sprintf("0x%02x: %v,\n", i, getFn(tab, uint8(i))))
+	}
+}
+
 func process(out *bytes.Buffer, getFn func(*regtab, uint8) string, tab *regtab) {
 	for i := 0; i < 0x100; i++ {
 		fn := getFn(tab, uint8(i))
@@ -818,6 +1021,34 @@ package z80
 	process(&out, processXCB, fdcb)
 	out.WriteString("}\n")
 
+	out.WriteString("var cycles = map[uint8]int{\n")
+	processCycles(&out, cyclesMain, un)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesCB = map[uint8]int{\n")
+	processCycles(&out, cyclesCB, un)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesED = map[uint8]int{\n")
+	processCycles(&out, cyclesED, un)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesDD = map[uint8]int{\n")
+	processCycles(&out, cyclesIndex, dd)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesFD = map[uint8]int{\n")
+	processCycles(&out, cyclesIndex, fd)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesDDCB = map[uint8]int{\n")
+	processCycles(&out, cyclesXCB, ddcb)
+	out.WriteString("}\n")
+
+	out.WriteString("var cyclesFDCB = map[uint8]int{\n")
+	processCycles(&out, cyclesXCB, fdcb)
+	out.WriteString("}\n")
+
 	filename := filepath.Join(targetDir, "ops.go")
 	err := ioutil.WriteFile(filename, out.Bytes(), 0644)
 	if err != nil {
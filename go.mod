@@ -5,5 +5,6 @@ require (
 	github.com/derekparker/delve v1.1.0
 	github.com/sirupsen/logrus v1.1.1 // indirect
 	github.com/veandco/go-sdl2 v0.0.0-20180925095440-75ff82abc4e3
+	github.com/yuin/gopher-lua v0.0.0-20180827083657-b942cacc89fe
 	golang.org/x/arch v0.0.0-20180920145803-b19384d3c130 // indirect
 )
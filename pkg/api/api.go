@@ -0,0 +1,385 @@
+// Package api lets other processes control a running machine with
+// JSON-RPC 2.0 over HTTP or a WebSocket.
+//
+// Requests are posted to /rpc or sent as text messages on a WebSocket
+// opened at /ws. Only a WebSocket can subscribe to events from the
+// machine, which arrive as notifications with the method "event".
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+	"github.com/blackchip-org/pac8/pkg/machine"
+)
+
+// Error codes defined by JSON-RPC
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeNoMethod       = -32601
+	codeInvalidParams  = -32602
+	codeServer         = -32000
+)
+
+// Names of the machine events
+var eventNames = map[machine.EventType]string{
+	machine.StatusEvent: "status",
+	machine.TraceEvent:  "trace",
+	machine.ErrorEvent:  "error",
+	machine.InfoEvent:   "info",
+}
+
+// eventQueue is the number of events held for a subscriber that is not
+// keeping up. Events beyond that are dropped.
+const eventQueue = 256
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(err error) error {
+	return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// Event is the parameter of an event notification.
+type Event struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type subscriber struct {
+	types  map[string]bool
+	events chan Event
+}
+
+// Server handles requests for a machine.
+type Server struct {
+	mach        *machine.Mach
+	mutex       sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+// NewServer creates a server for the machine. Events are passed on from
+// the EventCallback of the machine so it must be created after anything
+// else that sets the callback, such as the monitor.
+func NewServer(mach *machine.Mach) *Server {
+	s := &Server{
+		mach:        mach,
+		subscribers: make(map[*subscriber]bool),
+	}
+	next := mach.EventCallback
+	mach.EventCallback = func(evt machine.EventType, arg interface{}) {
+		next(evt, arg)
+		s.publish(Event{Type: eventNames[evt], Value: fmt.Sprint(arg)})
+	}
+	return s
+}
+
+func (s *Server) publish(e Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for sub := range s.subscribers {
+		if !sub.types[e.Type] {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+		}
+	}
+}
+
+// Handler returns the handler for /rpc and /ws.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", s.serveHTTP)
+	mux.HandleFunc("/ws", s.serveWebSocket)
+	return mux
+}
+
+// ListenAndServe handles requests at addr. If addr has no host, it
+// listens on localhost only.
+func (s *Server) ListenAndServe(addr string) error {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// localOrigin is true if the request does not come from a web page or
+// comes from a page served from this host. This keeps a page on another
+// site from controlling the machine through the browser of the user.
+func localOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !localOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	// A browser only sends JSON to another site after a preflight request,
+	// which is refused, so this also stops forms and scripts from other
+	// pages that do not send an Origin
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		http.Error(w, "use application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMessage))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := s.call(body, nil)
+	w.Header().Set("Content-Type", "application/json")
+	if reply == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Write(reply)
+}
+
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := &subscriber{
+		types:  make(map[string]bool),
+		events: make(chan Event, eventQueue),
+	}
+	s.mutex.Lock()
+	s.subscribers[sub] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, sub)
+		s.mutex.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case e := <-sub.events:
+				note, _ := json.Marshal(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  "event",
+					"params":  e,
+				})
+				if err := conn.write(wsText, note); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		message, err := conn.read()
+		if err != nil {
+			if err != errClosed {
+				log.Printf("api: %v", err)
+			}
+			return
+		}
+		if reply := s.call(message, sub); reply != nil {
+			if err := conn.write(wsText, reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// call handles a request, or a batch of them, and returns the reply. The
+// reply is nil if there is nothing to send back. The subscriber is nil
+// unless the request came from a WebSocket.
+func (s *Server) call(message []byte, sub *subscriber) []byte {
+	var batch []json.RawMessage
+	trimmed := strings.TrimSpace(string(message))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(message, &batch); err != nil {
+			return errorReply(nil, &rpcError{Code: codeParse, Message: err.Error()})
+		}
+		if len(batch) == 0 {
+			return errorReply(nil, &rpcError{Code: codeInvalidRequest, Message: "empty batch"})
+		}
+		replies := make([]json.RawMessage, 0, len(batch))
+		for _, req := range batch {
+			if reply := s.callOne(req, sub); reply != nil {
+				replies = append(replies, reply)
+			}
+		}
+		if len(replies) == 0 {
+			return nil
+		}
+		out, _ := json.Marshal(replies)
+		return out
+	}
+	return s.callOne(message, sub)
+}
+
+func (s *Server) callOne(message []byte, sub *subscriber) []byte {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return errorReply(nil, &rpcError{Code: codeParse, Message: err.Error()})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorReply(req.ID, &rpcError{Code: codeInvalidRequest, Message: "invalid request"})
+	}
+	var result interface{}
+	var err error
+	if req.Method == "subscribe" {
+		result, err = s.subscribe(sub, req.Params)
+	} else if method, ok := methods[req.Method]; ok {
+		result, err = method(s.mach, req.Params)
+	} else {
+		err = &rpcError{Code: codeNoMethod, Message: fmt.Sprintf("no such method: %v", req.Method)}
+	}
+	// A request without an id is a notification and gets no reply
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		rerr, ok := err.(*rpcError)
+		if !ok {
+			rerr = &rpcError{Code: codeServer, Message: err.Error()}
+		}
+		return errorReply(req.ID, rerr)
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+		"result":  result,
+	})
+	return out
+}
+
+func errorReply(id json.RawMessage, err *rpcError) []byte {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   err,
+	})
+	return out
+}
+
+func (s *Server) subscribe(sub *subscriber, params json.RawMessage) (interface{}, error) {
+	if sub == nil {
+		return nil, fmt.Errorf("events are only sent over a websocket")
+	}
+	var p struct {
+		Events []string `json:"events"`
+	}
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Events) == 0 {
+		for _, name := range eventNames {
+			p.Events = append(p.Events, name)
+		}
+	}
+	types := make(map[string]bool)
+	for _, name := range p.Events {
+		found := false
+		for _, known := range eventNames {
+			found = found || name == known
+		}
+		if !found {
+			return nil, invalidParams(fmt.Errorf("no such event: %v", name))
+		}
+		types[name] = true
+	}
+	s.mutex.Lock()
+	sub.types = types
+	s.mutex.Unlock()
+	sort.Strings(p.Events)
+	return p.Events, nil
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return invalidParams(err)
+	}
+	return nil
+}
+
+// hexBytes is memory that is encoded as a string of hexadecimal digits.
+type hexBytes []byte
+
+func (b hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+func (b *hexBytes) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	v, err := hex.DecodeString(str)
+	if err != nil {
+		return fmt.Errorf("invalid data: %v", str)
+	}
+	*b = v
+	return nil
+}
+
+// inputs returns the state of the inputs for names.
+func inputs(names []string) (input.Input, error) {
+	in, err := input.ParseNames(names)
+	if err != nil {
+		return in, invalidParams(err)
+	}
+	return in, nil
+}
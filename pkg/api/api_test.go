@@ -0,0 +1,443 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+type testSys struct {
+	spec *machine.Spec
+}
+
+func (s testSys) Spec() *machine.Spec {
+	return s.spec
+}
+
+func (s testSys) Save(w *state.Writer) {}
+
+func (s testSys) Restore(r *state.Reader) {}
+
+func newTestServer(t *testing.T) (*machine.Mach, *httptest.Server) {
+	mem := memory.NewRAM(0x10000)
+	memory.NewCursor(mem).PutN(
+		0x31, 0x00, 0x80, // ld sp,$8000
+		0x21, 0x34, 0x12, // ld hl,$1234
+		0x00,       // nop
+		0x00,       // nop
+		0x18, 0xfe, // jr $0008
+	)
+	sys := testSys{spec: &machine.Spec{
+		Name:     "test",
+		CPU:      []proc.CPU{z80.New(mem)},
+		Mem:      []memory.Memory{mem},
+		TickRate: time.Millisecond,
+	}}
+	m := machine.New(sys)
+	s := NewServer(m)
+	go m.Run()
+	ts := httptest.NewServer(s.Handler())
+	return m, ts
+}
+
+func post(t *testing.T, ts *httptest.Server, body string) string {
+	resp, err := http.Post(ts.URL+"/rpc", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func call(t *testing.T, ts *httptest.Server, method string, params string) string {
+	return post(t, ts, fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"%v","params":%v}`, method, params))
+}
+
+func result(value string) string {
+	return `{"id":1,"jsonrpc":"2.0","result":` + value + `}`
+}
+
+func TestHTTP(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	tests := []struct {
+		method string
+		params string
+		want   string
+	}{
+		{"status", `{}`, result(`{"status":"halt","pc":[0]}`)},
+		{"step", `{"core":0}`, result(`{"pc":3}`)},
+		{"step", `{}`, result(`{"pc":6}`)},
+		{"writeMemory", `{"address":16384,"data":"abcd"}`, result(`null`)},
+		{"readMemory", `{"address":16384,"length":3}`, result(`{"data":"abcd00"}`)},
+		{"writeRegisters", `{"registers":{"A":1,"DE":4660}}`, result(`null`)},
+		{"setBreakpoint", `{"address":8}`, result(`null`)},
+		{"setBreakpoint", `{"address":4}`, result(`null`)},
+		{"clearBreakpoint", `{"address":4}`, result(`null`)},
+		{"breakpoints", `{}`, result(`[8]`)},
+		{"input", `{"inputs":["coin","left2"]}`, result(`null`)},
+		{"step", `{"core":1}`, `{"error":{"code":-32602,"message":"no such core: 1"},"id":1,"jsonrpc":"2.0"}`},
+		{"writeRegisters", `{"registers":{"A":256}}`, `{"error":{"code":-32602,"message":"value out of range for A: 256"},"id":1,"jsonrpc":"2.0"}`},
+		{"input", `{"inputs":["jump"]}`, `{"error":{"code":-32602,"message":"invalid input: jump"},"id":1,"jsonrpc":"2.0"}`},
+		{"saveState", `{}`, `{"error":{"code":-32602,"message":"no path"},"id":1,"jsonrpc":"2.0"}`},
+		{"saveState", `{"path":"../a.state"}`, `{"error":{"code":-32602,"message":"path is outside of the store directory: ../a.state"},"id":1,"jsonrpc":"2.0"}`},
+		{"restoreState", `{"path":"/tmp/a.state"}`, `{"error":{"code":-32602,"message":"path is outside of the store directory: /tmp/a.state"},"id":1,"jsonrpc":"2.0"}`},
+		{"subscribe", `{}`, `{"error":{"code":-32000,"message":"events are only sent over a websocket"},"id":1,"jsonrpc":"2.0"}`},
+		{"fly", `{}`, `{"error":{"code":-32601,"message":"no such method: fly"},"id":1,"jsonrpc":"2.0"}`},
+	}
+	for _, test := range tests {
+		With(t).Expect(call(t, ts, test.method, test.params)).ToBe(test.want)
+	}
+
+	var regs map[string]int
+	reply := call(t, ts, "readRegisters", `{}`)
+	if err := json.Unmarshal([]byte(reply), &struct {
+		Result *map[string]int `json:"result"`
+	}{&regs}); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(regs["A"]).ToBe(1)
+	With(t).Expect(regs["DE"]).ToBe(0x1234)
+	With(t).Expect(regs["HL"]).ToBe(0x1234)
+
+	var in input.Input
+	m.Do(func() { in = m.In })
+	With(t).Expect(in.CoinSlot[0].Active).ToBe(true)
+	With(t).Expect(in.Joysticks[1].Left).ToBe(true)
+
+	With(t).Expect(call(t, ts, "clearBreakpoint", `{"address":8}`)).ToBe(result(`null`))
+	With(t).Expect(call(t, ts, "start", `{}`)).ToBe(result(`null`))
+	With(t).Expect(call(t, ts, "step", `{}`)).ToBe(`{"error":{"code":-32000,"message":"machine is running"},"id":1,"jsonrpc":"2.0"}`)
+}
+
+func TestHTTPBatch(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	reply := post(t, ts, `[
+		{"jsonrpc":"2.0","id":1,"method":"step"},
+		{"jsonrpc":"2.0","method":"step"},
+		{"jsonrpc":"2.0","id":2,"method":"status"}
+	]`)
+	want := `[{"id":1,"jsonrpc":"2.0","result":{"pc":3}},` +
+		`{"id":2,"jsonrpc":"2.0","result":{"status":"halt","pc":[6]}}]`
+	With(t).Expect(reply).ToBe(want)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	With(t).Expect(post(t, ts, `{`)).ToBe(`{"error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null,"jsonrpc":"2.0"}`)
+	With(t).Expect(post(t, ts, `{"id":3}`)).ToBe(`{"error":{"code":-32600,"message":"invalid request"},"id":3,"jsonrpc":"2.0"}`)
+	With(t).Expect(post(t, ts, `{"jsonrpc":"2.0","method":"stop"}`)).ToBe("")
+}
+
+func TestHTTPOrigin(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"http://localhost:8080", http.StatusOK},
+		{"http://127.0.0.1:8080", http.StatusOK},
+		{"https://[::1]", http.StatusOK},
+		{"http://example.com", http.StatusForbidden},
+		{"http://localhost.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/rpc", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", test.origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		With(t).Expect(resp.StatusCode).ToBe(test.want)
+	}
+}
+
+func TestHTTPContentType(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	tests := []struct {
+		contentType string
+		want        int
+	}{
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		resp, err := http.Post(ts.URL+"/rpc", test.contentType, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		With(t).Expect(resp.StatusCode).ToBe(test.want)
+	}
+}
+
+func TestSaveStateInStore(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m.Do(func() { m.StoreDir = dir })
+
+	With(t).Expect(call(t, ts, "saveState", `{"path":"a.state"}`)).ToBe(result(`null`))
+	m.Do(func() {})
+	if _, err := os.Stat(filepath.Join(dir, "a.state")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsClient is a scripted WebSocket client.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, ts *httptest.Server) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %v\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n", key)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(resp.StatusCode).ToBe(http.StatusSwitchingProtocols)
+	// Example from RFC 6455
+	With(t).Expect(resp.Header.Get("Sec-WebSocket-Accept")).ToBe("s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	return &wsClient{t: t, conn: conn, r: r}
+}
+
+func (c *wsClient) writeFrame(fin bool, opcode int, payload []byte) {
+	head := byte(opcode)
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) send(message string) {
+	c.writeFrame(true, wsText, []byte(message))
+}
+
+func (c *wsClient) read() (int, string) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	n := int(head[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return int(head[0] & 0x0f), string(payload)
+}
+
+// waitFor reads messages until one is want.
+func (c *wsClient) waitFor(want string) {
+	for i := 0; i < 100; i++ {
+		if _, got := c.read(); got == want {
+			return
+		}
+	}
+	c.t.Fatalf("did not receive %v", want)
+}
+
+func TestWebSocket(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	c := dialWebSocket(t, ts)
+	defer c.conn.Close()
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"subscribe","params":{"events":["status"]}}`)
+	_, reply := c.read()
+	With(t).Expect(reply).ToBe(result(`["status"]`))
+
+	// Split a request across frames
+	c.writeFrame(false, wsText, []byte(`{"jsonrpc":"2.0","id":1,`))
+	c.writeFrame(true, wsContinue, []byte(`"method":"setBreakpoint","params":{"address":8}}`))
+	_, reply = c.read()
+	With(t).Expect(reply).ToBe(result(`null`))
+
+	c.send(`{"jsonrpc":"2.0","method":"start"}`)
+	c.waitFor(`{"jsonrpc":"2.0","method":"event","params":{"type":"status","value":"run"}}`)
+	c.waitFor(`{"jsonrpc":"2.0","method":"event","params":{"type":"status","value":"break"}}`)
+
+	c.writeFrame(true, wsPing, []byte("hello"))
+	opcode, payload := c.read()
+	With(t).Expect(opcode).ToBe(wsPong)
+	With(t).Expect(payload).ToBe("hello")
+
+	c.writeFrame(true, wsClose, nil)
+	opcode, _ = c.read()
+	With(t).Expect(opcode).ToBe(wsClose)
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	resp, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	With(t).Expect(resp.StatusCode).ToBe(http.StatusBadRequest)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	m, ts := newTestServer(t)
+	defer ts.Close()
+	defer m.Send(machine.QuitCmd)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	With(t).Expect(resp.StatusCode).ToBe(http.StatusForbidden)
+}
+
+func TestHexBytes(t *testing.T) {
+	var b hexBytes
+	if err := json.Unmarshal([]byte(`"0aff"`), &b); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(bytes.Equal(b, []byte{0x0a, 0xff})).ToBe(true)
+	if err := json.Unmarshal([]byte(`"xyz"`), &b); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// flagCPU is a Z80 with an extra register that is a flag.
+type flagCPU struct {
+	*z80.CPU
+	flag bool
+}
+
+func (c *flagCPU) Info() proc.Info {
+	info := c.CPU.Info()
+	regs := make(map[string]proc.Value)
+	for name, reg := range info.Registers {
+		regs[name] = reg
+	}
+	regs["X"] = proc.Value{
+		Get: func() bool { return c.flag },
+		Put: func(v bool) { c.flag = v },
+	}
+	info.Registers = regs
+	return info
+}
+
+func TestFlagRegister(t *testing.T) {
+	mem := memory.NewRAM(0x10000)
+	cpu := &flagCPU{CPU: z80.New(mem)}
+	m := machine.New(testSys{spec: &machine.Spec{
+		Name:     "test",
+		CPU:      []proc.CPU{cpu},
+		Mem:      []memory.Memory{mem},
+		TickRate: time.Millisecond,
+	}})
+	go m.Run()
+	defer m.Send(machine.QuitCmd)
+	ts := httptest.NewServer(NewServer(m).Handler())
+	defer ts.Close()
+
+	With(t).Expect(call(t, ts, "writeRegisters", `{"registers":{"X":1}}`)).ToBe(result(`null`))
+	var flag bool
+	m.Do(func() { flag = cpu.flag })
+	With(t).Expect(flag).ToBe(true)
+	With(t).Expect(strings.Contains(call(t, ts, "readRegisters", `{}`), `"X":1`)).ToBe(true)
+	With(t).Expect(call(t, ts, "writeRegisters", `{"registers":{"X":2}}`)).ToBe(`{"error":{"code":-32602,"message":"value out of range for X: 2"},"id":1,"jsonrpc":"2.0"}`)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+)
+
+type method func(*machine.Mach, json.RawMessage) (interface{}, error)
+
+var methods = map[string]method{
+	"start":           start,
+	"stop":            stop,
+	"status":          status,
+	"step":            step,
+	"readMemory":      readMemory,
+	"writeMemory":     writeMemory,
+	"readRegisters":   readRegisters,
+	"writeRegisters":  writeRegisters,
+	"breakpoints":     breakpoints,
+	"setBreakpoint":   setBreakpoint,
+	"clearBreakpoint": clearBreakpoint,
+	"saveState":       saveState,
+	"restoreState":    restoreState,
+	"input":           setInput,
+	"trace":           trace,
+}
+
+type coreParams struct {
+	Core int `json:"core"`
+}
+
+type addressParams struct {
+	Core    int    `json:"core"`
+	Address uint16 `json:"address"`
+}
+
+// core decodes params that select a core and checks that it exists.
+func core(m *machine.Mach, params json.RawMessage, p interface{}, n *int) error {
+	if err := decode(params, p); err != nil {
+		return err
+	}
+	if *n < 0 || *n >= len(m.Cores) {
+		return invalidParams(fmt.Errorf("no such core: %v", *n))
+	}
+	return nil
+}
+
+// halted calls f from the machine goroutine but only if the machine is
+// not running.
+func halted(m *machine.Mach, f func()) error {
+	var err error
+	m.Do(func() {
+		if m.Status == machine.Run {
+			err = fmt.Errorf("machine is running")
+			return
+		}
+		f()
+	})
+	return err
+}
+
+func start(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	m.Send(machine.StartCmd)
+	return nil, nil
+}
+
+func stop(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	m.Send(machine.StopCmd)
+	return nil, nil
+}
+
+type statusResult struct {
+	Status string   `json:"status"`
+	PC     []uint16 `json:"pc"` // program counter of each core
+}
+
+func status(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var r statusResult
+	m.Do(func() {
+		r.Status = m.Status.String()
+		for _, c := range m.Cores {
+			r.PC = append(r.PC, c.CPU.PC())
+		}
+	})
+	return r, nil
+}
+
+func step(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p coreParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	var pc uint16
+	err := halted(m, func() {
+		m.Step(p.Core)
+		pc = m.Cores[p.Core].CPU.PC()
+	})
+	return map[string]uint16{"pc": pc}, err
+}
+
+func readMemory(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Core    int    `json:"core"`
+		Address uint16 `json:"address"`
+		Length  int    `json:"length"`
+	}
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	if p.Length < 0 || p.Length > 0x10000 {
+		return nil, invalidParams(fmt.Errorf("invalid length: %v", p.Length))
+	}
+	data := make(hexBytes, p.Length)
+	m.Do(func() {
+		mem := m.Cores[p.Core].Mem
+		for i := range data {
+			data[i] = mem.Load(p.Address + uint16(i))
+		}
+	})
+	return map[string]hexBytes{"data": data}, nil
+}
+
+func writeMemory(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Core    int      `json:"core"`
+		Address uint16   `json:"address"`
+		Data    hexBytes `json:"data"`
+	}
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	m.Do(func() {
+		mem := m.Cores[p.Core].Mem
+		for i, b := range p.Data {
+			mem.Store(p.Address+uint16(i), b)
+		}
+	})
+	return nil, nil
+}
+
+func readRegisters(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p coreParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	regs := make(map[string]int)
+	m.Do(func() {
+		for name, reg := range m.Cores[p.Core].CPU.Info().Registers {
+			switch get := reg.Get.(type) {
+			case func() uint8:
+				regs[name] = int(get())
+			case func() uint16:
+				regs[name] = int(get())
+			case func() bool:
+				regs[name] = 0
+				if get() {
+					regs[name] = 1
+				}
+			}
+		}
+	})
+	return regs, nil
+}
+
+func writeRegisters(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Core      int            `json:"core"`
+		Registers map[string]int `json:"registers"`
+	}
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	regs := m.Cores[p.Core].CPU.Info().Registers
+	for name, v := range p.Registers {
+		reg, ok := regs[name]
+		if !ok {
+			return nil, invalidParams(fmt.Errorf("no such register: %v", name))
+		}
+		var max int
+		switch reg.Put.(type) {
+		case func(uint8):
+			max = 0xff
+		case func(uint16):
+			max = 0xffff
+		case func(bool):
+			max = 1
+		default:
+			return nil, invalidParams(fmt.Errorf("unable to change register: %v", name))
+		}
+		if v < 0 || v > max {
+			return nil, invalidParams(fmt.Errorf("value out of range for %v: %v", name, v))
+		}
+	}
+	m.Do(func() {
+		for name, v := range p.Registers {
+			switch put := regs[name].Put.(type) {
+			case func(uint8):
+				put(uint8(v))
+			case func(uint16):
+				put(uint16(v))
+			case func(bool):
+				put(v != 0)
+			}
+		}
+	})
+	return nil, nil
+}
+
+func breakpoints(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p coreParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	addrs := make([]int, 0, 0)
+	m.Do(func() {
+		for addr := range m.Cores[p.Core].Breakpoints {
+			addrs = append(addrs, int(addr))
+		}
+	})
+	sort.Ints(addrs)
+	return addrs, nil
+}
+
+func setBreakpoint(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p addressParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	m.Do(func() {
+		m.Cores[p.Core].Breakpoints[p.Address] = &machine.Breakpoint{}
+	})
+	return nil, nil
+}
+
+func clearBreakpoint(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p addressParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	m.Do(func() {
+		delete(m.Cores[p.Core].Breakpoints, p.Address)
+	})
+	return nil, nil
+}
+
+type pathParams struct {
+	Path string `json:"path"`
+}
+
+// path returns the path in params joined to the store directory of the
+// machine. It is an error for the path to be outside of that directory.
+func path(m *machine.Mach, params json.RawMessage) (string, error) {
+	var p pathParams
+	if err := decode(params, &p); err != nil {
+		return "", err
+	}
+	if p.Path == "" {
+		return "", invalidParams(fmt.Errorf("no path"))
+	}
+	clean := filepath.Clean(p.Path)
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", invalidParams(fmt.Errorf("path is outside of the store directory: %v", p.Path))
+	}
+	return filepath.Join(m.StoreDir, clean), nil
+}
+
+// saveState and restoreState report any errors as error events.
+func saveState(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	path, err := path(m, params)
+	if err != nil {
+		return nil, err
+	}
+	m.Send(machine.SaveCmd, path)
+	return nil, nil
+}
+
+func restoreState(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	path, err := path(m, params)
+	if err != nil {
+		return nil, err
+	}
+	m.Send(machine.RestoreCmd, path)
+	return nil, nil
+}
+
+func setInput(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Inputs []string `json:"inputs"`
+	}
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	in, err := inputs(p.Inputs)
+	if err != nil {
+		return nil, err
+	}
+	m.Do(func() {
+		m.In = in
+	})
+	return nil, nil
+}
+
+func trace(m *machine.Mach, params json.RawMessage) (interface{}, error) {
+	var p coreParams
+	if err := core(m, params, &p, &p.Core); err != nil {
+		return nil, err
+	}
+	m.Send(machine.TraceCmd, p.Core)
+	return nil, nil
+}
@@ -2,6 +2,7 @@ package audio
 
 import (
 	"fmt"
+	"io"
 	"math"
 
 	"github.com/veandco/go-sdl2/sdl"
@@ -12,12 +13,31 @@ const (
 	Channels   = 2
 	Format     = sdl.AUDIO_U16LSB
 	Buffer     = 5
+	// FrameRate is the number of times per second that Queue is called
+	// when there is no audio device.
+	FrameRate = 60
 )
 
 type Audio interface {
 	Queue() error
 }
 
+// Tapper is Audio that can copy the samples it generates to a writer. The
+// samples are stereo, signed 16-bit, and little endian.
+type Tapper interface {
+	SetTap(io.Writer)
+	SampleRate() int
+}
+
+// DefaultSpec is used when there is no audio device. The number of
+// samples is the amount needed for one frame at 60 Hz, rounded down.
+var DefaultSpec = sdl.AudioSpec{
+	Freq:     SampleRate,
+	Format:   sdl.AUDIO_S16LSB,
+	Channels: Channels,
+	Samples:  367,
+}
+
 type NullAudio struct{}
 
 func (n NullAudio) Queue() error {
@@ -72,16 +92,30 @@ type Synth struct {
 	samples [][]float64
 	mixed   []float64
 	data    []byte
+	tap     io.Writer
+	offline bool
+	owed    int // samples not yet generated, in 1/FrameRate of a sample
 }
 
+// NewSynth creates a synthesizer that queues audio to the device opened
+// with spec. If the frequency in spec is zero, there is no device and
+// DefaultSpec is used instead. Without a device, each call to Queue
+// generates a frame of samples which are only sent to the tap. A frame
+// is not a whole number of samples so the fraction left over is carried
+// into the next call.
 func NewSynth(spec sdl.AudioSpec, voiceN int) (*Synth, error) {
+	offline := false
+	if spec.Freq == 0 {
+		spec = DefaultSpec
+		offline = true
+	}
 	if spec.Format != sdl.AUDIO_S16LSB {
 		return nil, fmt.Errorf("expecting format %x but got %x", sdl.AUDIO_U16LSB, spec.Format)
 	}
 	if spec.Channels != 2 {
 		return nil, fmt.Errorf("expecting 2 channels but got %x", spec.Channels)
 	}
-	s := &Synth{}
+	s := &Synth{offline: offline}
 	s.Spec = spec
 	s.V = make([]*Voice, voiceN)
 	samplesLen := s.Spec.Samples * Buffer
@@ -97,11 +131,41 @@ func NewSynth(spec sdl.AudioSpec, voiceN int) (*Synth, error) {
 }
 
 func (s *Synth) Queue() error {
+	if s.offline {
+		s.owed += int(s.Spec.Freq)
+		n := s.owed / FrameRate
+		s.owed -= n * FrameRate
+		s.tapWrite(s.generate(n))
+		return nil
+	}
 	q := sdl.GetQueuedAudioSize(1) / 4
 	n := int(s.Spec.Samples*Buffer) - int(q)
 	if n <= 0 {
 		return nil
 	}
+	data := s.generate(n)
+	s.tapWrite(data)
+	return sdl.QueueAudio(1, data)
+}
+
+// SetTap sets the writer that receives a copy of the samples generated.
+// Use nil to remove the tap. Write errors are not checked here and should
+// be tracked by the writer.
+func (s *Synth) SetTap(w io.Writer) {
+	s.tap = w
+}
+
+func (s *Synth) SampleRate() int {
+	return int(s.Spec.Freq)
+}
+
+func (s *Synth) tapWrite(data []byte) {
+	if s.tap != nil {
+		s.tap.Write(data)
+	}
+}
+
+func (s *Synth) generate(n int) []byte {
 	for i := 0; i < len(s.V); i++ {
 		s.V[i].Fill(s.samples[i], n)
 	}
@@ -119,7 +183,7 @@ func (s *Synth) Queue() error {
 		s.data[d+2] = byte(sample & 0xff)
 		s.data[d+3] = byte(sample >> 8)
 	}
-	return sdl.QueueAudio(1, s.data[0:n*4])
+	return s.data[0 : n*4]
 }
 
 func convert(f float64) int16 {
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/veandco/go-sdl2/sdl"
 )
 
 func TestFill(t *testing.T) {
@@ -59,3 +60,27 @@ func TestConvertSample(t *testing.T) {
 		})
 	}
 }
+
+func TestSynthOfflineTap(t *testing.T) {
+	s, err := NewSynth(sdl.AudioSpec{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf countWriter
+	s.SetTap(&buf)
+	s.Queue()
+	With(t).Expect(int(buf)).ToBe(4 * 367)
+	s.Queue()
+	With(t).Expect(int(buf)).ToBe(4 * 735)
+	for i := 2; i < FrameRate; i++ {
+		s.Queue()
+	}
+	With(t).Expect(int(buf)).ToBe(4 * SampleRate)
+}
+
+type countWriter int
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,83 @@
+package audio
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+const wavHeaderLen = 44
+
+// WAVWriter writes stereo, signed 16-bit samples to a WAV file. The sizes
+// in the header are filled in when the writer is closed. The first error
+// encountered is kept in Err and all writes after that are ignored.
+type WAVWriter struct {
+	Err  error
+	f    *os.File
+	freq int
+	n    int
+}
+
+func CreateWAV(path string, freq int) (*WAVWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAVWriter{f: f, freq: freq}
+	w.writeHeader()
+	if w.Err != nil {
+		f.Close()
+		return nil, w.Err
+	}
+	return w, nil
+}
+
+func (w *WAVWriter) Write(p []byte) (int, error) {
+	if w.Err != nil {
+		return 0, w.Err
+	}
+	n, err := w.f.Write(p)
+	w.n += n
+	w.Err = err
+	return n, err
+}
+
+func (w *WAVWriter) Close() error {
+	if w.Err == nil {
+		if _, err := w.f.Seek(0, 0); err != nil {
+			w.Err = err
+		} else {
+			w.writeHeader()
+		}
+	}
+	if err := w.f.Close(); err != nil && w.Err == nil {
+		w.Err = err
+	}
+	return w.Err
+}
+
+func (w *WAVWriter) writeHeader() {
+	channels := 2
+	bytesPerSample := 2
+	blockAlign := channels * bytesPerSample
+	header := []interface{}{
+		[]byte("RIFF"),
+		uint32(wavHeaderLen - 8 + w.n),
+		[]byte("WAVE"),
+		[]byte("fmt "),
+		uint32(16), // size of fmt chunk
+		uint16(1),  // PCM
+		uint16(channels),
+		uint32(w.freq),
+		uint32(w.freq * blockAlign), // bytes per second
+		uint16(blockAlign),
+		uint16(bytesPerSample * 8),
+		[]byte("data"),
+		uint32(w.n),
+	}
+	for _, v := range header {
+		if err := binary.Write(w.f, binary.LittleEndian, v); err != nil {
+			w.Err = err
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestWAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.wav")
+
+	w, err := CreateWAV(path, 22050)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte{1, 2, 3, 4})
+	w.Write([]byte{5, 6, 7, 8})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	With(t).Expect(len(data)).ToBe(44 + 8)
+	With(t).Expect(string(data[0:4])).ToBe("RIFF")
+	With(t).Expect(le.Uint32(data[4:8])).ToBe(uint32(36 + 8))
+	With(t).Expect(string(data[8:16])).ToBe("WAVEfmt ")
+	With(t).Expect(le.Uint16(data[22:24])).ToBe(uint16(2))
+	With(t).Expect(le.Uint32(data[24:28])).ToBe(uint32(22050))
+	With(t).Expect(le.Uint32(data[28:32])).ToBe(uint32(22050 * 4))
+	With(t).Expect(le.Uint16(data[34:36])).ToBe(uint16(16))
+	With(t).Expect(string(data[36:40])).ToBe("data")
+	With(t).Expect(le.Uint32(data[40:44])).ToBe(uint32(8))
+	With(t).Expect(data[44:]).ToBe([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+}
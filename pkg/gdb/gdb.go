@@ -0,0 +1,566 @@
+// Package gdb is a stub for the GDB Remote Serial Protocol. It lets a
+// debugger that understands the protocol, such as gdb with
+// "set architecture z80", inspect and control the cores of a machine.
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/proc"
+)
+
+// Z80Registers is the order that gdb expects the registers of a Z80 in.
+// Each is 16 bits. A register that is a pair of 8-bit registers lists the
+// high register first.
+var Z80Registers = [][]string{
+	{"AF"}, {"BC"}, {"DE"}, {"HL"},
+	{"SP"}, {"PC"}, {"IX"}, {"IY"},
+	{"AF1"}, {"BC1"}, {"DE1"}, {"HL1"},
+	{"I", "R"},
+}
+
+// Signals reported to the debugger when the machine stops
+const (
+	sigInt  = 2 // stopped by the debugger
+	sigTrap = 5 // stopped at a breakpoint or after a step
+)
+
+// Server handles debugger connections for a machine.
+type Server struct {
+	Registers [][]string // register layout, Z80Registers by default
+	mach      *machine.Mach
+	stops     chan machine.Status
+	core      int // core selected by the debugger
+}
+
+// NewServer creates a server for the machine. It listens for the status
+// of the machine through its EventCallback so it must be created after
+// anything else that sets the callback, such as the monitor.
+func NewServer(mach *machine.Mach) *Server {
+	s := &Server{
+		Registers: Z80Registers,
+		mach:      mach,
+		stops:     make(chan machine.Status, 1),
+	}
+	next := mach.EventCallback
+	mach.EventCallback = func(evt machine.EventType, arg interface{}) {
+		next(evt, arg)
+		if evt != machine.StatusEvent {
+			return
+		}
+		if status := arg.(machine.Status); status != machine.Run {
+			select {
+			case s.stops <- status:
+			default:
+			}
+		}
+	}
+	return s
+}
+
+// ListenAndServe accepts debugger connections at addr, one at a time. If
+// addr has no host, it listens on localhost only.
+func (s *Server) ListenAndServe(addr string) error {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("gdb: connection from %v", conn.RemoteAddr())
+		if err := s.Serve(conn); err != nil {
+			log.Printf("gdb: %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// Serve handles the session of a debugger connected through rw. The
+// machine is stopped when the session starts and is left running when
+// the debugger detaches.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	c := newConn(rw)
+	go c.read()
+	s.stop()
+	for p := range c.in {
+		if p.interrupt {
+			continue
+		}
+		reply, done := s.handle(c, p.data)
+		if err := c.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// stop halts the machine and waits for it to report that it has.
+func (s *Server) stop() {
+	s.drain()
+	s.mach.Send(machine.StopCmd)
+	<-s.stops
+}
+
+func (s *Server) drain() {
+	select {
+	case <-s.stops:
+	default:
+	}
+}
+
+// resume runs the machine until it stops at a breakpoint or the debugger
+// interrupts it.
+func (s *Server) resume(c *conn) (string, bool) {
+	s.drain()
+	s.mach.Send(machine.StartCmd)
+	for {
+		select {
+		case status := <-s.stops:
+			if status == machine.Halt {
+				return s.stopReply(sigInt), false
+			}
+			return s.stopReply(sigTrap), false
+		case p, ok := <-c.in:
+			if !ok {
+				return "", true
+			}
+			if p.interrupt {
+				s.mach.Send(machine.StopCmd)
+			}
+		}
+	}
+}
+
+func (s *Server) stopReply(signal int) string {
+	return fmt.Sprintf("T%02xthread:%02x;", signal, s.core+1)
+}
+
+// handle returns the reply to the packet in data. The flag is true when
+// the session is over.
+func (s *Server) handle(c *conn, data string) (string, bool) {
+	if data == "" {
+		return "", false
+	}
+	cmd, args := data[0], data[1:]
+	switch cmd {
+	case '?':
+		return s.stopReply(sigTrap), false
+	case 'c':
+		if reply := s.do(func() string { return result(s.setPC(args)) }); reply != "OK" {
+			return reply, false
+		}
+		return s.resume(c)
+	case 's':
+		return s.do(func() string { return s.step(args) }), false
+	case 'g':
+		return s.do(s.readRegisters), false
+	case 'G':
+		return s.do(func() string { return result(s.writeRegisters(args)) }), false
+	case 'p':
+		return s.do(func() string { return s.readRegister(args) }), false
+	case 'P':
+		return s.do(func() string { return result(s.writeRegister(args)) }), false
+	case 'm':
+		return s.do(func() string { return s.readMemory(args) }), false
+	case 'M':
+		return s.do(func() string { return result(s.writeMemory(args)) }), false
+	case 'Z', 'z':
+		return s.do(func() string { return s.breakpoint(cmd == 'Z', args) }), false
+	case 'H':
+		if args == "" {
+			return "E01", false
+		}
+		return result(s.selectThread(args[1:])), false
+	case 'T':
+		if _, err := s.thread(args); err != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case 'q', 'Q':
+		return s.query(c, data), false
+	case 'D':
+		s.mach.Send(machine.StartCmd)
+		return "OK", true
+	case 'k':
+		s.mach.Send(machine.QuitCmd)
+		return "", true
+	}
+	return "", false
+}
+
+// do calls f from the machine goroutine and returns its reply. Anything
+// that reads or changes the cores must be done this way.
+func (s *Server) do(f func() string) string {
+	var reply string
+	s.mach.Do(func() { reply = f() })
+	return reply
+}
+
+func (s *Server) query(c *conn, data string) string {
+	name := data
+	if i := strings.IndexAny(data, ":,"); i >= 0 {
+		name = data[:i]
+	}
+	switch name {
+	case "qSupported":
+		return "PacketSize=4000;QStartNoAckMode+"
+	case "QStartNoAckMode":
+		c.setNoAck()
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return fmt.Sprintf("QC%02x", s.core+1)
+	case "qfThreadInfo":
+		ids := make([]string, len(s.mach.Cores))
+		for i := range ids {
+			ids[i] = fmt.Sprintf("%02x", i+1)
+		}
+		return "m" + strings.Join(ids, ",")
+	case "qsThreadInfo":
+		return "l"
+	}
+	return ""
+}
+
+func result(err error) string {
+	if err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// thread returns the core for a thread id. Thread ids start at one.
+func (s *Server) thread(id string) (int, error) {
+	n, err := strconv.ParseInt(id, 16, 32)
+	if err != nil || n < 1 || int(n) > len(s.mach.Cores) {
+		return 0, fmt.Errorf("no such thread: %v", id)
+	}
+	return int(n) - 1, nil
+}
+
+func (s *Server) selectThread(id string) error {
+	// Zero and -1 mean any thread so keep the one that is selected
+	if id == "0" || id == "-1" {
+		return nil
+	}
+	core, err := s.thread(id)
+	if err != nil {
+		return err
+	}
+	s.core = core
+	return nil
+}
+
+func (s *Server) step(addr string) string {
+	if err := s.setPC(addr); err != nil {
+		return "E01"
+	}
+	s.mach.Step(s.core)
+	return s.stopReply(sigTrap)
+}
+
+func (s *Server) cpu() proc.CPU {
+	return s.mach.Cores[s.core].CPU
+}
+
+func (s *Server) setPC(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	pc, err := strconv.ParseUint(addr, 16, 16)
+	if err != nil {
+		return err
+	}
+	s.cpu().SetPC(uint16(pc))
+	return nil
+}
+
+// register returns the value of register n and false if the CPU does not
+// have it.
+func (s *Server) register(n int) (uint16, bool) {
+	regs := s.cpu().Info().Registers
+	var value uint16
+	for _, name := range s.Registers[n] {
+		reg, ok := regs[name]
+		if !ok {
+			return 0, false
+		}
+		switch get := reg.Get.(type) {
+		case func() uint8:
+			value = value<<8 | uint16(get())
+		case func() uint16:
+			value = get()
+		default:
+			return 0, false
+		}
+	}
+	return value, true
+}
+
+func (s *Server) setRegister(n int, value uint16) {
+	regs := s.cpu().Info().Registers
+	names := s.Registers[n]
+	for i := len(names) - 1; i >= 0; i-- {
+		reg, ok := regs[names[i]]
+		if !ok {
+			return
+		}
+		switch put := reg.Put.(type) {
+		case func(uint8):
+			put(uint8(value))
+			value >>= 8
+		case func(uint16):
+			put(value)
+		}
+	}
+}
+
+func formatRegister(value uint16, ok bool) string {
+	if !ok {
+		return "xxxx"
+	}
+	return fmt.Sprintf("%02x%02x", uint8(value), uint8(value>>8))
+}
+
+func parseRegister(str string) (uint16, error) {
+	b, err := hex.DecodeString(str)
+	if err != nil || len(b) != 2 {
+		return 0, fmt.Errorf("invalid register value: %v", str)
+	}
+	return uint16(b[0]) | uint16(b[1])<<8, nil
+}
+
+func (s *Server) readRegisters() string {
+	var b strings.Builder
+	for i := range s.Registers {
+		b.WriteString(formatRegister(s.register(i)))
+	}
+	return b.String()
+}
+
+func (s *Server) writeRegisters(args string) error {
+	if len(args) != len(s.Registers)*4 {
+		return fmt.Errorf("invalid registers: %v", args)
+	}
+	values := make([]uint16, len(s.Registers))
+	for i := range values {
+		v, err := parseRegister(args[i*4 : i*4+4])
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	for i, v := range values {
+		s.setRegister(i, v)
+	}
+	return nil
+}
+
+func (s *Server) registerNumber(str string) (int, error) {
+	n, err := strconv.ParseUint(str, 16, 16)
+	if err != nil || int(n) >= len(s.Registers) {
+		return 0, fmt.Errorf("no such register: %v", str)
+	}
+	return int(n), nil
+}
+
+func (s *Server) readRegister(args string) string {
+	n, err := s.registerNumber(args)
+	if err != nil {
+		return "E01"
+	}
+	return formatRegister(s.register(n))
+}
+
+func (s *Server) writeRegister(args string) error {
+	parts := strings.SplitN(args, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid register: %v", args)
+	}
+	n, err := s.registerNumber(parts[0])
+	if err != nil {
+		return err
+	}
+	v, err := parseRegister(parts[1])
+	if err != nil {
+		return err
+	}
+	s.setRegister(n, v)
+	return nil
+}
+
+// memoryRange parses "addr,length".
+func memoryRange(args string) (uint16, int, error) {
+	parts := strings.SplitN(args, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range: %v", args)
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address: %v", parts[0])
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid length: %v", parts[1])
+	}
+	return uint16(addr), int(n), nil
+}
+
+func (s *Server) readMemory(args string) string {
+	addr, n, err := memoryRange(args)
+	if err != nil {
+		return "E01"
+	}
+	mem := s.mach.Cores[s.core].Mem
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = mem.Load(addr + uint16(i))
+	}
+	return hex.EncodeToString(data)
+}
+
+func (s *Server) writeMemory(args string) error {
+	parts := strings.SplitN(args, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid write: %v", args)
+	}
+	addr, n, err := memoryRange(parts[0])
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(parts[1])
+	if err != nil || len(data) != n {
+		return fmt.Errorf("invalid data: %v", parts[1])
+	}
+	mem := s.mach.Cores[s.core].Mem
+	for i, b := range data {
+		mem.Store(addr+uint16(i), b)
+	}
+	return nil
+}
+
+// breakpoint sets or clears a software or hardware breakpoint. Both kinds
+// are the same to the machine. Watchpoints are not supported.
+func (s *Server) breakpoint(set bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 2 {
+		return "E01"
+	}
+	if parts[0] != "0" && parts[0] != "1" {
+		return ""
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	breakpoints := s.mach.Cores[s.core].Breakpoints
+	if set {
+		breakpoints[uint16(addr)] = &machine.Breakpoint{}
+	} else {
+		delete(breakpoints, uint16(addr))
+	}
+	return "OK"
+}
+
+type packet struct {
+	data      string
+	interrupt bool // the debugger sent a break
+}
+
+// conn frames packets sent to and from the debugger.
+type conn struct {
+	rw    io.ReadWriter
+	in    chan packet
+	mutex sync.Mutex
+	noAck bool
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{
+		rw: rw,
+		in: make(chan packet, 1),
+	}
+}
+
+func (c *conn) setNoAck() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.noAck = true
+}
+
+func (c *conn) ack(b byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.noAck {
+		c.rw.Write([]byte{b})
+	}
+}
+
+// read sends each packet from the debugger to the in channel. The channel
+// is closed when the connection is.
+func (c *conn) read() {
+	defer close(c.in)
+	r := bufio.NewReader(c.rw)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			c.in <- packet{interrupt: true}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return
+			}
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			if err != nil || uint8(want) != checksum(data) {
+				c.ack('-')
+				continue
+			}
+			c.ack('+')
+			c.in <- packet{data: data}
+		}
+	}
+}
+
+func (c *conn) send(data string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := fmt.Fprintf(c.rw, "$%v#%02x", data, checksum(data))
+	return err
+}
+
+func checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
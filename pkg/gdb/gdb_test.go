@@ -0,0 +1,187 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+type testSys struct {
+	spec *machine.Spec
+}
+
+func (s testSys) Spec() *machine.Spec {
+	return s.spec
+}
+
+func (s testSys) Save(w *state.Writer) {}
+
+func (s testSys) Restore(r *state.Reader) {}
+
+func newTestMach() *machine.Mach {
+	mem := memory.NewRAM(0x10000)
+	memory.NewCursor(mem).PutN(
+		0x31, 0x00, 0x80, // ld sp,$8000
+		0x21, 0x34, 0x12, // ld hl,$1234
+		0x00,       // nop
+		0x00,       // nop
+		0x18, 0xfe, // jr $0008
+	)
+	sys := testSys{spec: &machine.Spec{
+		Name:     "test",
+		CPU:      []proc.CPU{z80.New(mem)},
+		Mem:      []memory.Memory{mem},
+		TickRate: time.Millisecond,
+	}}
+	return machine.New(sys)
+}
+
+// client is a scripted debugger.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) write(str string) {
+	if _, err := io.WriteString(c.conn, str); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) expect(b byte) {
+	got, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if got != b {
+		c.t.Fatalf("expected %q, got %q", b, got)
+	}
+}
+
+// reply reads a packet from the server and acknowledges it.
+func (c *client) reply() string {
+	c.expect('$')
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+	sum := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, sum); err != nil {
+		c.t.Fatal(err)
+	}
+	if string(sum) != fmt.Sprintf("%02x", checksum(data)) {
+		c.t.Fatalf("bad checksum for %v: %s", data, sum)
+	}
+	c.write("+")
+	return data
+}
+
+func (c *client) send(data string) string {
+	c.write(fmt.Sprintf("$%v#%02x", data, checksum(data)))
+	c.expect('+')
+	return c.reply()
+}
+
+func newTestSession(t *testing.T) (*machine.Mach, *client, chan error) {
+	m := newTestMach()
+	s := NewServer(m)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		l.Close()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- s.Serve(conn)
+		conn.Close()
+	}()
+	go m.Run()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+	return m, c, done
+}
+
+func TestSession(t *testing.T) {
+	m, c, done := newTestSession(t)
+	defer m.Send(machine.QuitCmd)
+
+	With(t).Expect(strings.Contains(c.send("qSupported:swbreak+"), "QStartNoAckMode+")).ToBe(true)
+	With(t).Expect(c.send("?")).ToBe("T05thread:01;")
+	With(t).Expect(c.send("qfThreadInfo")).ToBe("m01")
+	With(t).Expect(c.send("s")).ToBe("T05thread:01;")
+	With(t).Expect(c.send("s")).ToBe("T05thread:01;")
+	With(t).Expect(c.send("p5")).ToBe("0600")
+	With(t).Expect(c.send("p3")).ToBe("3412")
+	With(t).Expect(c.send("P3=7856")).ToBe("OK")
+	With(t).Expect(c.send("p3")).ToBe("7856")
+	With(t).Expect(c.send("g")).ToBe("" +
+		"0000" + "0000" + "0000" + "7856" +
+		"0080" + "0600" + "0000" + "0000" +
+		"0000" + "0000" + "0000" + "0000" +
+		"0200") // the refresh register counts instruction fetches
+	With(t).Expect(c.send("M4000,2:abcd")).ToBe("OK")
+	With(t).Expect(c.send("m4000,3")).ToBe("abcd00")
+
+	With(t).Expect(c.send("Z0,8,1")).ToBe("OK")
+	With(t).Expect(c.send("c")).ToBe("T05thread:01;")
+	With(t).Expect(c.send("p5")).ToBe("0800")
+	With(t).Expect(c.send("z0,8,1")).ToBe("OK")
+
+	c.write(fmt.Sprintf("$c#%02x", checksum("c")))
+	c.expect('+')
+	c.write("\x03")
+	With(t).Expect(c.reply()).ToBe("T02thread:01;")
+
+	With(t).Expect(c.send("D")).ToBe("OK")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoAck(t *testing.T) {
+	m, c, _ := newTestSession(t)
+	defer m.Send(machine.QuitCmd)
+
+	With(t).Expect(c.send("QStartNoAckMode")).ToBe("OK")
+	c.write(fmt.Sprintf("$p5#%02x", checksum("p5")))
+	With(t).Expect(c.reply()).ToBe("0000")
+}
+
+func TestBadChecksum(t *testing.T) {
+	m, c, _ := newTestSession(t)
+	defer m.Send(machine.QuitCmd)
+
+	c.write("$p5#00")
+	c.expect('-')
+	With(t).Expect(c.send("p5")).ToBe("0000")
+}
+
+func TestUnsupported(t *testing.T) {
+	m, c, _ := newTestSession(t)
+	defer m.Send(machine.QuitCmd)
+
+	With(t).Expect(c.send("vMustReplyEmpty")).ToBe("")
+	With(t).Expect(c.send("Z2,4000,1")).ToBe("")
+	With(t).Expect(c.send("Hg2")).ToBe("E01")
+}
@@ -9,6 +9,7 @@ type Joystick struct {
 	Down  bool
 	Right bool
 	Left  bool
+	Fire  bool
 }
 
 type Coin struct {
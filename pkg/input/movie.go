@@ -0,0 +1,90 @@
+package input
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	movieMagic = "pac8-movie"
+	// MovieVersion is the version of the movie file format
+	MovieVersion = 1
+)
+
+type movieHeader struct {
+	Magic   string
+	Version int
+}
+
+// Movie is a recording of all inputs starting from a snapshot of the
+// machine. Each change to the inputs is stored as an entry in the script.
+type Movie struct {
+	Game     string
+	ROM      map[string]string // checksums by ROM path
+	Snapshot []byte
+	Frames   int
+	Input    Script
+}
+
+// WriteMovie writes the movie and its header to w.
+func WriteMovie(w io.Writer, m *Movie) error {
+	enc := gob.NewEncoder(w)
+	header := movieHeader{Magic: movieMagic, Version: MovieVersion}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	return enc.Encode(m)
+}
+
+// ReadMovie reads a movie from r. An error is returned if r does not
+// contain a movie or the movie version is not supported.
+func ReadMovie(r io.Reader) (*Movie, error) {
+	dec := gob.NewDecoder(r)
+	var header movieHeader
+	if err := dec.Decode(&header); err != nil || header.Magic != movieMagic {
+		return nil, fmt.Errorf("not a movie file")
+	}
+	if header.Version != MovieVersion {
+		return nil, fmt.Errorf("unsupported movie version: %v", header.Version)
+	}
+	m := &Movie{}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SaveMovie writes the movie to the file at path.
+func SaveMovie(path string, m *Movie) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteMovie(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadMovie reads a movie from the file at path.
+func LoadMovie(path string) (*Movie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMovie(f)
+}
+
+// Record adds the state of the inputs at the next frame to the movie.
+// An entry is only added to the script when the state changes.
+func (m *Movie) Record(in Input) {
+	n := len(m.Input.Entries)
+	if n == 0 || m.Input.Entries[n-1].In != in {
+		m.Input.Entries = append(m.Input.Entries, ScriptEntry{Frame: m.Frames, In: in})
+	}
+	m.Frames++
+}
@@ -0,0 +1,57 @@
+package input
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestMovieRecord(t *testing.T) {
+	coin := Input{}
+	coin.CoinSlot[0].Active = true
+	m := &Movie{}
+	m.Record(Input{})
+	m.Record(Input{})
+	m.Record(coin)
+	m.Record(coin)
+	m.Record(Input{})
+	With(t).Expect(m.Frames).ToBe(5)
+	With(t).Expect(len(m.Input.Entries)).ToBe(3)
+	With(t).Expect(m.Input.At(1)).ToBe(Input{})
+	With(t).Expect(m.Input.At(3)).ToBe(coin)
+	With(t).Expect(m.Input.At(4)).ToBe(Input{})
+}
+
+func TestMovieReadWrite(t *testing.T) {
+	in := Input{}
+	in.Joysticks[0].Left = true
+	m := &Movie{
+		Game:     "pacman",
+		ROM:      map[string]string{"pacman.6e": "1234"},
+		Snapshot: []byte{1, 2, 3},
+	}
+	m.Record(in)
+	var buf bytes.Buffer
+	if err := WriteMovie(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := ReadMovie(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(m2).ToBe(m)
+}
+
+func TestMovieNotAMovie(t *testing.T) {
+	_, err := ReadMovie(bytes.NewReader([]byte("hello")))
+	With(t).Expect(err.Error()).ToBe("not a movie file")
+}
+
+func TestMovieVersion(t *testing.T) {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(movieHeader{Magic: movieMagic, Version: 99})
+	_, err := ReadMovie(&buf)
+	With(t).Expect(err.Error()).ToBe("unsupported movie version: 99")
+}
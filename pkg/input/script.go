@@ -0,0 +1,151 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScriptEntry is the state of all inputs starting at Frame.
+type ScriptEntry struct {
+	Frame int
+	In    Input
+}
+
+// Script is a series of input states by frame number. The state of an entry
+// is held until the frame of the next entry.
+//
+// In text form, each line starts with a frame number and is followed by the
+// names of the inputs that are active starting at that frame. A line with
+// only a frame number releases all inputs. Blank lines and text after a '#'
+// are ignored:
+//
+//	# insert a coin and start a one player game
+//	300 coin
+//	305
+//	400 start1
+//	405
+//	420 left
+//
+// Input names are up, down, left, right, fire, coin and start. A player
+// number may be added to the name to select the joystick, coin slot, or
+// start button. If the number is omitted, player 1 is used.
+type Script struct {
+	Entries []ScriptEntry
+}
+
+// At returns the state of the inputs at frame.
+func (s *Script) At(frame int) Input {
+	i := sort.Search(len(s.Entries), func(i int) bool {
+		return s.Entries[i].Frame > frame
+	})
+	if i == 0 {
+		return Input{}
+	}
+	return s.Entries[i-1].In
+}
+
+// ParseScript reads a script in text form from r.
+func ParseScript(r io.Reader) (*Script, error) {
+	s := &Script{Entries: make([]ScriptEntry, 0, 0)}
+	scanner := bufio.NewScanner(r)
+	lineN := 0
+	for scanner.Scan() {
+		lineN++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("line %v: invalid frame: %v", lineN, fields[0])
+		}
+		n := len(s.Entries)
+		if n > 0 && frame <= s.Entries[n-1].Frame {
+			return nil, fmt.Errorf("line %v: frame %v is out of order", lineN, frame)
+		}
+		entry := ScriptEntry{Frame: frame}
+		for _, name := range fields[1:] {
+			if err := setInput(&entry.In, name); err != nil {
+				return nil, fmt.Errorf("line %v: %v", lineN, err)
+			}
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadScript reads a script in text form from the file at path.
+func LoadScript(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(f)
+}
+
+// ParseNames returns the state of the inputs when only the inputs named
+// are active. Names are the same as in a script.
+func ParseNames(names []string) (Input, error) {
+	in := Input{}
+	for _, name := range names {
+		if err := setInput(&in, name); err != nil {
+			return Input{}, err
+		}
+	}
+	return in, nil
+}
+
+func setInput(in *Input, name string) error {
+	base := strings.TrimRight(name, "0123456789")
+	player := 1
+	if base != name {
+		n, err := strconv.Atoi(name[len(base):])
+		if err != nil {
+			return fmt.Errorf("invalid input: %v", name)
+		}
+		player = n
+	}
+	max := len(in.Joysticks)
+	switch base {
+	case "coin":
+		max = len(in.CoinSlot)
+	case "start":
+		max = len(in.PlayerStart)
+	}
+	if player < 1 || player > max {
+		return fmt.Errorf("invalid player for input: %v", name)
+	}
+	i := player - 1
+	switch base {
+	case "up":
+		in.Joysticks[i].Up = true
+	case "down":
+		in.Joysticks[i].Down = true
+	case "left":
+		in.Joysticks[i].Left = true
+	case "right":
+		in.Joysticks[i].Right = true
+	case "fire":
+		in.Joysticks[i].Fire = true
+	case "coin":
+		in.CoinSlot[i].Active = true
+	case "start":
+		in.PlayerStart[i].Active = true
+	default:
+		return fmt.Errorf("invalid input: %v", name)
+	}
+	return nil
+}
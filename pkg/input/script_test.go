@@ -0,0 +1,54 @@
+package input
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+const testScript = `
+# insert a coin and start
+10 coin
+12
+20 start1 # one player
+22 left up2
+`
+
+func TestScriptAt(t *testing.T) {
+	s, err := ParseScript(strings.NewReader(testScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(s.At(0)).ToBe(Input{})
+	With(t).Expect(s.At(10).CoinSlot[0].Active).ToBe(true)
+	With(t).Expect(s.At(11).CoinSlot[0].Active).ToBe(true)
+	With(t).Expect(s.At(12)).ToBe(Input{})
+	With(t).Expect(s.At(21).PlayerStart[0].Active).ToBe(true)
+	in := s.At(1000)
+	With(t).Expect(in.Joysticks[0].Left).ToBe(true)
+	With(t).Expect(in.Joysticks[1].Up).ToBe(true)
+	With(t).Expect(in.PlayerStart[0].Active).ToBe(false)
+}
+
+func TestScriptErrors(t *testing.T) {
+	var tests = []struct {
+		script string
+		err    string
+	}{
+		{"x coin", "line 1: invalid frame: x"},
+		{"10\n5", "line 2: frame 5 is out of order"},
+		{"10 jump", "line 1: invalid input: jump"},
+		{"10 coin3", "line 1: invalid player for input: coin3"},
+		{"10 left0", "line 1: invalid player for input: left0"},
+	}
+	for _, test := range tests {
+		t.Run(test.script, func(t *testing.T) {
+			_, err := ParseScript(strings.NewReader(test.script))
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			With(t).Expect(err.Error()).ToBe(test.err)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package lua
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// Size of a character drawn with gui.text, including the space after it
+const (
+	charW = 4
+	charH = 6
+)
+
+// font has the characters that gui.text can draw. Each is five rows of
+// three pixels with the leftmost pixel in bit 2. Lowercase letters are
+// drawn as uppercase and anything else as a question mark.
+var font = map[rune][5]uint8{
+	' ': {0, 0, 0, 0, 0},
+	'!': {2, 2, 2, 0, 2},
+	'+': {0, 2, 7, 2, 0},
+	'-': {0, 0, 7, 0, 0},
+	'.': {0, 0, 0, 0, 2},
+	'/': {1, 1, 2, 4, 4},
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 3, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	':': {0, 2, 0, 2, 0},
+	'=': {0, 7, 0, 7, 0},
+	'?': {6, 1, 2, 0, 2},
+	'A': {2, 5, 7, 5, 5},
+	'B': {6, 5, 6, 5, 6},
+	'C': {3, 4, 4, 4, 3},
+	'D': {6, 5, 5, 5, 6},
+	'E': {7, 4, 6, 4, 7},
+	'F': {7, 4, 6, 4, 4},
+	'G': {3, 4, 5, 5, 3},
+	'H': {5, 5, 7, 5, 5},
+	'I': {7, 2, 2, 2, 7},
+	'J': {1, 1, 1, 5, 2},
+	'K': {5, 5, 6, 5, 5},
+	'L': {4, 4, 4, 4, 7},
+	'M': {5, 7, 7, 5, 5},
+	'N': {6, 5, 5, 5, 5},
+	'O': {2, 5, 5, 5, 2},
+	'P': {6, 5, 6, 4, 4},
+	'Q': {2, 5, 5, 6, 3},
+	'R': {6, 5, 6, 5, 5},
+	'S': {3, 4, 2, 1, 6},
+	'T': {7, 2, 2, 2, 2},
+	'U': {5, 5, 5, 5, 7},
+	'V': {5, 5, 5, 5, 2},
+	'W': {5, 5, 7, 7, 5},
+	'X': {5, 5, 2, 5, 5},
+	'Y': {5, 5, 2, 2, 2},
+	'Z': {7, 1, 2, 4, 7},
+}
+
+// overlay draws what the script has drawn since the last frame.
+func (s *Script) overlay(img *image.RGBA) {
+	for _, d := range s.draws {
+		d(img)
+	}
+}
+
+// optColor returns the color in argument n, given as 0xRRGGBB. The color
+// is white if not given.
+func (s *Script) optColor(n int) color.RGBA {
+	v := s.l.OptInt(n, 0xffffff)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
+
+// gui.pixel(x, y [, color]) draws a pixel.
+func (s *Script) guiPixel(l *glua.LState) int {
+	x, y := l.CheckInt(1), l.CheckInt(2)
+	c := s.optColor(3)
+	s.draws = append(s.draws, func(img *image.RGBA) {
+		img.SetRGBA(x, y, c)
+	})
+	return 0
+}
+
+// gui.box(x, y, w, h [, color]) draws a filled rectangle.
+func (s *Script) guiBox(l *glua.LState) int {
+	x, y := l.CheckInt(1), l.CheckInt(2)
+	w, h := l.CheckInt(3), l.CheckInt(4)
+	c := s.optColor(5)
+	s.draws = append(s.draws, func(img *image.RGBA) {
+		r := image.Rect(x, y, x+w, y+h)
+		draw.Draw(img, r, &image.Uniform{c}, image.ZP, draw.Src)
+	})
+	return 0
+}
+
+// gui.text(x, y, text [, color]) draws text with its top left corner at
+// x, y. Each character is four pixels wide and a newline starts a line
+// six pixels down.
+func (s *Script) guiText(l *glua.LState) int {
+	x, y := l.CheckInt(1), l.CheckInt(2)
+	text := l.CheckString(3)
+	c := s.optColor(4)
+	s.draws = append(s.draws, func(img *image.RGBA) {
+		drawText(img, x, y, text, c)
+	})
+	return 0
+}
+
+func drawText(img *image.RGBA, x int, y int, text string, c color.RGBA) {
+	cx := x
+	for _, ch := range text {
+		if ch == '\n' {
+			cx = x
+			y += charH
+			continue
+		}
+		glyph, ok := font[unicode.ToUpper(ch)]
+		if !ok {
+			glyph = font['?']
+		}
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>uint(col)) != 0 {
+					img.SetRGBA(cx+col, y+row, c)
+				}
+			}
+		}
+		cx += charW
+	}
+}
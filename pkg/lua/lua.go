@@ -0,0 +1,362 @@
+// Package lua runs Lua scripts that read and change a running machine.
+//
+// A script adds callbacks that are run after each frame, before the
+// instruction at an address is executed, or after memory is written. The
+// callbacks can read and write memory and registers, set the inputs, and
+// draw over the screen. This is enough for cheats, bots, tests, and
+// heads-up displays without rebuilding pac8.
+//
+// Only the base, string, table, and math libraries are available to a
+// script.
+package lua
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/video"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// Script is a Lua interpreter attached to a machine. It must only be used
+// from the machine goroutine, such as with Do, or before the machine is
+// run.
+type Script struct {
+	Print     func(string) // used by print in the script, fmt.Println by default
+	mach      *machine.Mach
+	l         *glua.LState
+	hooks     []int               // callbacks added by the script
+	frameHook int                 // counts frames and clears the drawing
+	draws     []func(*image.RGBA) // drawn over the next frame
+	frame     int                 // frames run since the script was created
+	stop      bool                // the script asked for the machine to stop
+}
+
+// New creates an interpreter for mach. Call Close to remove everything that
+// its scripts have added to the machine.
+func New(mach *machine.Mach) (*Script, error) {
+	s := &Script{
+		Print: func(str string) { fmt.Println(str) },
+		mach:  mach,
+		l:     glua.NewState(glua.Options{SkipOpenLibs: true}),
+	}
+	for _, lib := range []struct {
+		name string
+		open glua.LGFunction
+	}{
+		{glua.BaseLibName, glua.OpenBase},
+		{glua.TabLibName, glua.OpenTable},
+		{glua.StringLibName, glua.OpenString},
+		{glua.MathLibName, glua.OpenMath},
+	} {
+		s.l.Push(s.l.NewFunction(lib.open))
+		s.l.Push(glua.LString(lib.name))
+		s.l.Call(1, 0)
+	}
+	s.l.SetGlobal("print", s.l.NewFunction(s.print))
+	s.l.SetGlobal("emu", s.l.SetFuncs(s.l.NewTable(), map[string]glua.LGFunction{
+		"cores":    s.cores,
+		"frame":    s.frameCount,
+		"on_exec":  s.onExec,
+		"on_frame": s.onFrame,
+		"on_write": s.onWrite,
+		"remove":   s.remove,
+		"status":   s.status,
+		"stop":     s.stopMach,
+	}))
+	s.l.SetGlobal("mem", s.l.SetFuncs(s.l.NewTable(), map[string]glua.LGFunction{
+		"read":    s.memRead,
+		"read16":  s.memRead16,
+		"write":   s.memWrite,
+		"write16": s.memWrite16,
+	}))
+	s.l.SetGlobal("reg", s.l.SetFuncs(s.l.NewTable(), map[string]glua.LGFunction{
+		"get": s.regGet,
+		"set": s.regSet,
+	}))
+	s.l.SetGlobal("input", s.l.SetFuncs(s.l.NewTable(), map[string]glua.LGFunction{
+		"set": s.inputSet,
+	}))
+	s.l.SetGlobal("gui", s.l.SetFuncs(s.l.NewTable(), map[string]glua.LGFunction{
+		"box":   s.guiBox,
+		"pixel": s.guiPixel,
+		"text":  s.guiText,
+	}))
+
+	// Count frames and start each one with nothing drawn. This is added
+	// first so that it runs before the frame callbacks of the script.
+	id, err := mach.AddHook(machine.Hook{
+		Type: machine.FrameHook,
+		Func: func(uint8) bool {
+			s.frame++
+			s.draws = s.draws[:0]
+			return false
+		},
+	})
+	if err != nil {
+		s.l.Close()
+		return nil, err
+	}
+	s.frameHook = id
+	if o, ok := mach.Display.(video.Overlayer); ok {
+		o.SetOverlay(s.overlay)
+	}
+	return s, nil
+}
+
+// DoFile runs the script in the file at path.
+func (s *Script) DoFile(path string) error {
+	return s.l.DoFile(path)
+}
+
+// DoString runs the script in src.
+func (s *Script) DoString(src string) error {
+	return s.l.DoString(src)
+}
+
+// Close removes the callbacks and drawing of the script from the machine.
+func (s *Script) Close() {
+	s.mach.RemoveHook(s.frameHook)
+	for _, id := range s.hooks {
+		s.mach.RemoveHook(id)
+	}
+	s.hooks = nil
+	if o, ok := s.mach.Display.(video.Overlayer); ok {
+		o.SetOverlay(nil)
+	}
+	s.l.Close()
+}
+
+// call runs a callback of the script and returns true if the machine
+// should stop. An error in the callback stops the machine.
+func (s *Script) call(fn *glua.LFunction, args ...glua.LValue) bool {
+	s.stop = false
+	if err := s.l.CallByParam(glua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		s.mach.EventCallback(machine.ErrorEvent, fmt.Sprintf("lua: %v", err))
+		return true
+	}
+	ret := s.l.Get(-1)
+	s.l.Pop(1)
+	return s.stop || glua.LVAsBool(ret)
+}
+
+func (s *Script) addHook(h machine.Hook) int {
+	id, err := s.mach.AddHook(h)
+	if err != nil {
+		s.l.RaiseError("%v", err)
+	}
+	s.hooks = append(s.hooks, id)
+	s.l.Push(glua.LNumber(id))
+	return 1
+}
+
+// checkAddr returns the address in argument n.
+func (s *Script) checkAddr(n int) uint16 {
+	addr := s.l.CheckInt(n)
+	if addr < 0 || addr > 0xffff {
+		s.l.ArgError(n, "address out of range")
+	}
+	return uint16(addr)
+}
+
+// optCore returns the core in argument n, which is zero if not given.
+func (s *Script) optCore(n int) int {
+	core := s.l.OptInt(n, 0)
+	if core < 0 || core >= len(s.mach.Cores) {
+		s.l.ArgError(n, fmt.Sprintf("no such core: %v", core))
+	}
+	return core
+}
+
+// checkByte returns the 8-bit value in argument n.
+func (s *Script) checkByte(n int) uint8 {
+	v := s.l.CheckInt(n)
+	if v < 0 || v > 0xff {
+		s.l.ArgError(n, "value out of range")
+	}
+	return uint8(v)
+}
+
+func (s *Script) print(l *glua.LState) int {
+	args := make([]string, l.GetTop())
+	for i := range args {
+		args[i] = l.ToStringMeta(l.Get(i + 1)).String()
+	}
+	s.Print(strings.Join(args, "\t"))
+	return 0
+}
+
+// emu.cores() returns the number of cores.
+func (s *Script) cores(l *glua.LState) int {
+	l.Push(glua.LNumber(len(s.mach.Cores)))
+	return 1
+}
+
+// emu.frame() returns the number of frames run since the script was loaded.
+func (s *Script) frameCount(l *glua.LState) int {
+	l.Push(glua.LNumber(s.frame))
+	return 1
+}
+
+// emu.on_frame(fn) calls fn after each frame.
+func (s *Script) onFrame(l *glua.LState) int {
+	fn := l.CheckFunction(1)
+	return s.addHook(machine.Hook{
+		Type: machine.FrameHook,
+		Func: func(uint8) bool { return s.call(fn) },
+	})
+}
+
+// emu.on_exec(addr, fn [, core]) calls fn with the address before the
+// instruction there is executed.
+func (s *Script) onExec(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	fn := l.CheckFunction(2)
+	return s.addHook(machine.Hook{
+		Type: machine.ExecHook,
+		Core: s.optCore(3),
+		Addr: addr,
+		Func: func(uint8) bool { return s.call(fn, glua.LNumber(addr)) },
+	})
+}
+
+// emu.on_write(addr, fn [, core]) calls fn with the address and the value
+// after the value is written there.
+func (s *Script) onWrite(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	fn := l.CheckFunction(2)
+	return s.addHook(machine.Hook{
+		Type: machine.WriteHook,
+		Core: s.optCore(3),
+		Addr: addr,
+		Func: func(value uint8) bool {
+			return s.call(fn, glua.LNumber(addr), glua.LNumber(value))
+		},
+	})
+}
+
+// emu.remove(id) removes a callback.
+func (s *Script) remove(l *glua.LState) int {
+	id := l.CheckInt(1)
+	for i, hook := range s.hooks {
+		if hook == id {
+			s.mach.RemoveHook(id)
+			s.hooks = append(s.hooks[:i], s.hooks[i+1:]...)
+			return 0
+		}
+	}
+	l.ArgError(1, fmt.Sprintf("no such callback: %v", id))
+	return 0
+}
+
+// emu.status() returns "run", "break", or "halt".
+func (s *Script) status(l *glua.LState) int {
+	l.Push(glua.LString(s.mach.Status.String()))
+	return 1
+}
+
+// emu.stop() stops the machine when the callback returns. It does nothing
+// outside of a callback.
+func (s *Script) stopMach(l *glua.LState) int {
+	s.stop = true
+	return 0
+}
+
+// mem.read(addr [, core]) returns the byte at addr.
+func (s *Script) memRead(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	mem := s.mach.Cores[s.optCore(2)].Mem
+	l.Push(glua.LNumber(mem.Load(addr)))
+	return 1
+}
+
+// mem.read16(addr [, core]) returns the little endian word at addr.
+func (s *Script) memRead16(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	mem := s.mach.Cores[s.optCore(2)].Mem
+	l.Push(glua.LNumber(uint16(mem.Load(addr)) | uint16(mem.Load(addr+1))<<8))
+	return 1
+}
+
+// mem.write(addr, value [, core]) stores a byte at addr.
+func (s *Script) memWrite(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	value := s.checkByte(2)
+	s.mach.Cores[s.optCore(3)].Mem.Store(addr, value)
+	return 0
+}
+
+// mem.write16(addr, value [, core]) stores a little endian word at addr.
+func (s *Script) memWrite16(l *glua.LState) int {
+	addr := s.checkAddr(1)
+	value := l.CheckInt(2)
+	if value < 0 || value > 0xffff {
+		l.ArgError(2, "value out of range")
+	}
+	mem := s.mach.Cores[s.optCore(3)].Mem
+	mem.Store(addr, uint8(value))
+	mem.Store(addr+1, uint8(value>>8))
+	return 0
+}
+
+// reg.get(name [, core]) returns the value of a register.
+func (s *Script) regGet(l *glua.LState) int {
+	name := l.CheckString(1)
+	reg, ok := s.mach.Cores[s.optCore(2)].CPU.Info().Registers[strings.ToUpper(name)]
+	if !ok {
+		l.ArgError(1, fmt.Sprintf("no such register: %v", name))
+	}
+	switch get := reg.Get.(type) {
+	case func() uint8:
+		l.Push(glua.LNumber(get()))
+	case func() uint16:
+		l.Push(glua.LNumber(get()))
+	case func() bool:
+		l.Push(glua.LBool(get()))
+	default:
+		l.ArgError(1, fmt.Sprintf("unable to read register: %v", name))
+	}
+	return 1
+}
+
+// reg.set(name, value [, core]) changes the value of a register.
+func (s *Script) regSet(l *glua.LState) int {
+	name := l.CheckString(1)
+	reg, ok := s.mach.Cores[s.optCore(3)].CPU.Info().Registers[strings.ToUpper(name)]
+	if !ok {
+		l.ArgError(1, fmt.Sprintf("no such register: %v", name))
+	}
+	switch put := reg.Put.(type) {
+	case func(uint8):
+		put(s.checkByte(2))
+	case func(uint16):
+		v := l.CheckInt(2)
+		if v < 0 || v > 0xffff {
+			l.ArgError(2, "value out of range")
+		}
+		put(uint16(v))
+	case func(bool):
+		put(glua.LVAsBool(l.Get(2)))
+	default:
+		l.ArgError(1, fmt.Sprintf("unable to change register: %v", name))
+	}
+	return 0
+}
+
+// input.set(name...) makes only the inputs named active. Names are the
+// same as in an input script.
+func (s *Script) inputSet(l *glua.LState) int {
+	names := make([]string, l.GetTop())
+	for i := range names {
+		names[i] = l.CheckString(i + 1)
+	}
+	in, err := input.ParseNames(names)
+	if err != nil {
+		l.RaiseError("%v", err)
+	}
+	s.mach.In = in
+	return 0
+}
@@ -0,0 +1,248 @@
+package lua
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+type testSys struct {
+	spec *machine.Spec
+}
+
+func (s testSys) Spec() *machine.Spec {
+	return s.spec
+}
+
+func (s testSys) Save(w *state.Writer) {}
+
+func (s testSys) Restore(r *state.Reader) {}
+
+// testDisplay draws a black frame and then the overlay.
+type testDisplay struct {
+	img     *image.RGBA
+	overlay func(*image.RGBA)
+}
+
+func (d *testDisplay) Render() {
+	draw.Draw(d.img, d.img.Bounds(), image.Black, image.ZP, draw.Src)
+	if d.overlay != nil {
+		d.overlay(d.img)
+	}
+}
+
+func (d *testDisplay) SetOverlay(draw func(*image.RGBA)) {
+	d.overlay = draw
+}
+
+// newTestMach returns a running machine that increments $4000 in a loop.
+func newTestMach() (*machine.Mach, *testDisplay) {
+	mem := memory.NewSpy(memory.NewRAM(0x10000))
+	memory.NewCursor(mem).PutN(
+		0x21, 0x00, 0x40, // ld hl,$4000
+		0x34,       // loop: inc (hl)
+		0x18, 0xfd, // jr loop
+	)
+	display := &testDisplay{img: image.NewRGBA(image.Rect(0, 0, 16, 16))}
+	sys := testSys{spec: &machine.Spec{
+		Name:     "test",
+		CPU:      []proc.CPU{z80.New(mem)},
+		Mem:      []memory.Memory{mem},
+		Display:  display,
+		TickRate: 16670 * time.Microsecond,
+	}}
+	m := machine.New(sys)
+	m.Status = machine.Run
+	return m, display
+}
+
+func newTestScript(t *testing.T, m *machine.Mach, src string) *Script {
+	s, err := New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DoString(src); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestOnFrame(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		emu.on_frame(function()
+			mem.write(0x5000, emu.frame())
+			return emu.frame() == 3
+		end)
+	`)
+	m.RunHeadless(10, nil)
+	With(t).Expect(m.Status).ToBe(machine.Break)
+	With(t).Expect(m.Cores[0].Mem.Load(0x5000)).ToBe(uint8(3))
+}
+
+func TestOnWrite(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		emu.on_write(0x4000, function(addr, value)
+			if value == 3 then
+				mem.write(addr, 0x80)
+				emu.stop()
+			end
+		end)
+	`)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(machine.Break)
+	With(t).Expect(m.Cores[0].Mem.Load(0x4000)).ToBe(uint8(0x80))
+}
+
+func TestOnExec(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		n = 0
+		emu.on_exec(0x0003, function(addr)
+			n = n + 1
+			return n == 4
+		end)
+	`)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(machine.Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0003))
+	With(t).Expect(m.Cores[0].Mem.Load(0x4000)).ToBe(uint8(3))
+}
+
+func TestRemove(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		id = emu.on_exec(0x0003, function() return true end)
+		emu.remove(id)
+	`)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(machine.Run)
+}
+
+func TestRemoveUnknown(t *testing.T) {
+	m, _ := newTestMach()
+	s, err := New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hook that counts frames belongs to the interpreter, not the script
+	if err := s.DoString(fmt.Sprintf("emu.remove(%v)", s.frameHook)); err == nil {
+		t.Fatal("expected error")
+	}
+	With(t).Expect(len(m.Hooks())).ToBe(1)
+}
+
+func TestClose(t *testing.T) {
+	m, _ := newTestMach()
+	s := newTestScript(t, m, `emu.on_exec(0x0003, function() return true end)`)
+	s.Close()
+	With(t).Expect(len(m.Hooks())).ToBe(0)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(machine.Run)
+}
+
+func TestMemory(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		mem.write16(0x5000, 0x1234)
+		mem.write(0x5002, mem.read(0x5000) + 1)
+		mem.write16(0x5004, mem.read16(0x5000))
+	`)
+	mem := m.Cores[0].Mem
+	With(t).Expect(mem.Load(0x5000)).ToBe(uint8(0x34))
+	With(t).Expect(mem.Load(0x5001)).ToBe(uint8(0x12))
+	With(t).Expect(mem.Load(0x5002)).ToBe(uint8(0x35))
+	With(t).Expect(mem.Load(0x5005)).ToBe(uint8(0x12))
+}
+
+func TestRegisters(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `
+		reg.set("hl", 0x1234)
+		reg.set("a", reg.get("H"))
+	`)
+	regs := m.Cores[0].CPU.Info().Registers
+	With(t).Expect(regs["HL"].Get.(func() uint16)()).ToBe(uint16(0x1234))
+	With(t).Expect(regs["A"].Get.(func() uint8)()).ToBe(uint8(0x12))
+}
+
+func TestInput(t *testing.T) {
+	m, _ := newTestMach()
+	newTestScript(t, m, `input.set("coin", "left2")`)
+	With(t).Expect(m.In.CoinSlot[0].Active).ToBe(true)
+	With(t).Expect(m.In.Joysticks[1].Left).ToBe(true)
+}
+
+func TestGui(t *testing.T) {
+	m, display := newTestMach()
+	newTestScript(t, m, `
+		gui.box(0, 0, 2, 2, 0xff0000)
+		emu.on_frame(function()
+			gui.text(4, 0, "1")
+		end)
+	`)
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+
+	// The box is only drawn over the first frame
+	m.RunHeadless(1, nil)
+	With(t).Expect(display.img.RGBAAt(1, 1)).ToBe(red)
+	With(t).Expect(display.img.RGBAAt(5, 0)).ToBe(black)
+
+	m.RunHeadless(1, nil)
+	With(t).Expect(display.img.RGBAAt(1, 1)).ToBe(black)
+	With(t).Expect(display.img.RGBAAt(5, 0)).ToBe(white)
+	With(t).Expect(display.img.RGBAAt(4, 0)).ToBe(black)
+}
+
+func TestError(t *testing.T) {
+	m, _ := newTestMach()
+	var msg string
+	m.EventCallback = func(evt machine.EventType, arg interface{}) {
+		if evt == machine.ErrorEvent {
+			msg = arg.(string)
+		}
+	}
+	newTestScript(t, m, `emu.on_frame(function() mem.write(0x5000, 256) end)`)
+	m.RunHeadless(10, nil)
+	With(t).Expect(m.Status).ToBe(machine.Break)
+	With(t).Expect(strings.Contains(msg, "value out of range")).ToBe(true)
+}
+
+func TestPrint(t *testing.T) {
+	m, _ := newTestMach()
+	s, err := New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	s.Print = func(str string) { out = append(out, str) }
+	if err := s.DoString(`print("a", 1)`); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(out).ToBe([]string{"a\t1"})
+}
+
+func TestNoOS(t *testing.T) {
+	m, _ := newTestMach()
+	s, err := New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DoString(`os.exit(1)`); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,45 @@
+package machine
+
+import (
+	"github.com/blackchip-org/pac8/pkg/proc"
+)
+
+// CallStack returns the calls and interrupts on core that have not
+// returned, with the innermost last. The flag is false if the CPU does not
+// keep track of its calls.
+func (m *Mach) CallStack(core int) ([]proc.Frame, bool) {
+	cs, ok := m.Cores[core].CPU.(proc.CallStacker)
+	if !ok {
+		return nil, false
+	}
+	return cs.CallStack(), true
+}
+
+func (m *Mach) callDepth(core int) int {
+	calls, _ := m.CallStack(core)
+	return len(calls)
+}
+
+// stepOver executes the next instruction on core. If that instruction
+// calls a subroutine, or an interrupt is taken, the machine runs until it
+// returns.
+func (m *Mach) stepOver(core int) {
+	depth := m.callDepth(core)
+	m.Step(core)
+	if m.callDepth(core) <= depth {
+		m.setStatus(Break)
+		return
+	}
+	m.runUntil(core, func() bool { return m.callDepth(core) <= depth })
+}
+
+// stepOut runs the machine until the subroutine or interrupt handler that
+// core is in returns.
+func (m *Mach) stepOut(core int) {
+	depth := m.callDepth(core)
+	if depth == 0 {
+		m.EventCallback(ErrorEvent, "unable to step out: not in a subroutine")
+		return
+	}
+	m.runUntil(core, func() bool { return m.callDepth(core) < depth })
+}
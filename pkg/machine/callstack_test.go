@@ -0,0 +1,87 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+// newCallMach returns a halted machine with a main program that calls a
+// subroutine that calls another.
+func newCallMach() *Mach {
+	mem := memory.NewRAM(0x10000)
+	memory.NewCursor(mem).PutN(
+		0x31, 0x00, 0x80, // ld sp,$8000
+		0xcd, 0x10, 0x00, // call $0010
+		0x00,       // nop
+		0x18, 0xfe, // jr $0007
+	)
+	c := memory.NewCursor(mem)
+	c.Pos = 0x10
+	c.PutN(
+		0xcd, 0x20, 0x00, // call $0020
+		0xc9, // ret
+	)
+	c.Pos = 0x20
+	c.PutN(
+		0x00, // nop
+		0xc9, // ret
+	)
+	return newMemMach(mem, nil)
+}
+
+func TestStepOver(t *testing.T) {
+	m := newCallMach()
+	m.Step(0)
+	m.stepOver(0)
+	With(t).Expect(m.Status).ToBe(Run)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0006))
+}
+
+func TestStepOverNoCall(t *testing.T) {
+	m := newCallMach()
+	m.stepOver(0)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0003))
+}
+
+func TestStepOverBreakpoint(t *testing.T) {
+	m := newCallMach()
+	m.Cores[0].Breakpoints[0x0020] = &Breakpoint{}
+	m.Step(0)
+	m.stepOver(0)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0020))
+	With(t).Expect(m.Cores[0].until == nil).ToBe(true)
+}
+
+func TestStepOut(t *testing.T) {
+	m := newCallMach()
+	m.Step(0)
+	m.Step(0)
+	m.Step(0)
+	calls, _ := m.CallStack(0)
+	With(t).Expect(len(calls)).ToBe(2)
+	m.stepOut(0)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0013))
+	calls, _ = m.CallStack(0)
+	With(t).Expect(len(calls)).ToBe(1)
+}
+
+func TestStepOutTopLevel(t *testing.T) {
+	m := newCallMach()
+	var msg interface{}
+	m.EventCallback = func(evt EventType, arg interface{}) {
+		if evt == ErrorEvent {
+			msg = arg
+		}
+	}
+	m.stepOut(0)
+	With(t).Expect(msg).ToBe("unable to step out: not in a subroutine")
+	With(t).Expect(m.Status).ToBe(Halt)
+}
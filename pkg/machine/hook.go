@@ -0,0 +1,140 @@
+package machine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+)
+
+type HookType int
+
+const (
+	FrameHook HookType = iota // after each frame is run
+	ExecHook                  // before the instruction at an address
+	WriteHook                 // after a value is written to an address
+)
+
+func (t HookType) String() string {
+	switch t {
+	case FrameHook:
+		return "frame"
+	case ExecHook:
+		return "exec"
+	case WriteHook:
+		return "write"
+	}
+	return "???"
+}
+
+// Hook is a function that is called from the machine goroutine while the
+// machine is running. The function is given the value written for a
+// WriteHook and zero otherwise. The machine stops if it returns true.
+type Hook struct {
+	Type HookType
+	Core int    // core for an ExecHook or WriteHook
+	Addr uint16 // address for an ExecHook or WriteHook
+	Func func(value uint8) bool
+}
+
+// AddHook adds a hook to the machine and returns its id. It must be
+// called from the machine goroutine, such as with Do.
+func (m *Mach) AddHook(h Hook) (int, error) {
+	if h.Func == nil {
+		return 0, errors.New("no hook function")
+	}
+	if h.Type != FrameHook {
+		if h.Core < 0 || h.Core >= len(m.Cores) {
+			return 0, fmt.Errorf("no such core: %v", h.Core)
+		}
+		if h.Type == WriteHook && m.Cores[h.Core].Spy == nil {
+			return 0, errors.New("write hooks are not supported")
+		}
+	}
+	if m.hooks == nil {
+		m.hooks = make(map[int]*Hook)
+	}
+	m.nextHook++
+	m.hooks[m.nextHook] = &h
+	m.indexHooks()
+	return m.nextHook, nil
+}
+
+// RemoveHook removes the hook with id. It must be called from the machine
+// goroutine.
+func (m *Mach) RemoveHook(id int) error {
+	if _, ok := m.hooks[id]; !ok {
+		return fmt.Errorf("no such hook: %v", id)
+	}
+	delete(m.hooks, id)
+	m.indexHooks()
+	return nil
+}
+
+// Hooks returns the ids of the hooks in the order they were added.
+func (m *Mach) Hooks() []int {
+	ids := make([]int, 0, len(m.hooks))
+	for id := range m.hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// indexHooks sorts the hooks by when they are called so that finding them
+// is quick.
+func (m *Mach) indexHooks() {
+	m.frameHooks = nil
+	for i := range m.Cores {
+		core := &m.Cores[i]
+		core.execHooks = make(map[uint16][]*Hook)
+		core.writeHooks = make(map[uint16][]*Hook)
+		if core.Spy != nil {
+			core.Spy.UnhookAll()
+		}
+	}
+	for _, id := range m.Hooks() {
+		h := m.hooks[id]
+		switch h.Type {
+		case FrameHook:
+			m.frameHooks = append(m.frameHooks, h)
+		case ExecHook:
+			core := &m.Cores[h.Core]
+			core.execHooks[h.Addr] = append(core.execHooks[h.Addr], h)
+		case WriteHook:
+			core := &m.Cores[h.Core]
+			core.writeHooks[h.Addr] = append(core.writeHooks[h.Addr], h)
+			core.Spy.HookW(h.Addr)
+		}
+	}
+}
+
+// callHooks calls each hook and returns true if any of them asked for the
+// machine to stop. Memory changed by a hook does not hit watchpoints or
+// call other hooks.
+func (m *Mach) callHooks(hooks []*Hook, value uint8) bool {
+	watching := m.watching
+	m.watching = false
+	defer func() { m.watching = watching }()
+	stop := false
+	for _, h := range hooks {
+		if h.Func(value) {
+			stop = true
+		}
+	}
+	return stop
+}
+
+// hookWrite returns the function called by the memory spy of a core when
+// a hooked address is written.
+func (m *Mach) hookWrite(core int) memory.EventCallback {
+	return func(e memory.Event) {
+		if !m.watching {
+			return
+		}
+		if m.callHooks(m.Cores[core].writeHooks[e.Address], e.Value) {
+			m.hookStop = true
+		}
+	}
+}
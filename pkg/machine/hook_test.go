@@ -0,0 +1,105 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+// newHookMach returns a running machine with memory that can be hooked
+// and that increments $4000 in a loop.
+func newHookMach() *Mach {
+	mem := memory.NewSpy(memory.NewRAM(0x10000))
+	memory.NewCursor(mem).PutN(
+		0x21, 0x00, 0x40, // ld hl,$4000
+		0x34,       // loop: inc (hl)
+		0x18, 0xfd, // jr loop
+	)
+	m := newMemMach(mem, nil)
+	m.Status = Run
+	return m
+}
+
+func TestFrameHook(t *testing.T) {
+	m := newHookMach()
+	n := 0
+	m.AddHook(Hook{Type: FrameHook, Func: func(uint8) bool {
+		n++
+		return n == 3
+	}})
+	m.RunHeadless(10, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(n).ToBe(3)
+	With(t).Expect(m.frame).ToBe(3)
+}
+
+func TestExecHook(t *testing.T) {
+	m := newHookMach()
+	n := 0
+	m.AddHook(Hook{Type: ExecHook, Addr: 0x0003, Func: func(uint8) bool {
+		n++
+		return n == 4
+	}})
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0003))
+	With(t).Expect(m.Cores[0].Mem.Load(0x4000)).ToBe(uint8(3))
+}
+
+func TestWriteHook(t *testing.T) {
+	m := newHookMach()
+	var values []uint8
+	m.AddHook(Hook{Type: WriteHook, Addr: 0x4000, Func: func(v uint8) bool {
+		values = append(values, v)
+		return v == 3
+	}})
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(values).ToBe([]uint8{1, 2, 3})
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0004))
+}
+
+func TestWriteHookChange(t *testing.T) {
+	m := newHookMach()
+	mem := m.Cores[0].Mem
+	// Keep the value from going over 2
+	m.AddHook(Hook{Type: WriteHook, Addr: 0x4000, Func: func(v uint8) bool {
+		if v > 2 {
+			mem.Store(0x4000, 2)
+		}
+		return false
+	}})
+	m.RunHeadless(1, nil)
+	With(t).Expect(mem.Load(0x4000)).ToBe(uint8(2))
+}
+
+func TestWriteHookUnsupported(t *testing.T) {
+	m := newCallMach()
+	_, err := m.AddHook(Hook{Type: WriteHook, Addr: 0x4000, Func: func(uint8) bool { return false }})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRemoveHook(t *testing.T) {
+	m := newHookMach()
+	n := 0
+	id, err := m.AddHook(Hook{Type: FrameHook, Func: func(uint8) bool {
+		n++
+		return false
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RunHeadless(2, nil)
+	if err := m.RemoveHook(id); err != nil {
+		t.Fatal(err)
+	}
+	m.RunHeadless(2, nil)
+	With(t).Expect(n).ToBe(2)
+	With(t).Expect(len(m.Hooks())).ToBe(0)
+	if err := m.RemoveHook(id); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -1,11 +1,10 @@
 package machine
 
 import (
-	"github.com/blackchip-org/pac8/pkg/input"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-func handleKeyboard(event sdl.Event, in *input.Input) {
+func (m *Mach) handleKeyboard(event sdl.Event) {
 	e, ok := event.(*sdl.KeyboardEvent)
 	if !ok {
 		return
@@ -21,6 +20,7 @@ func handleKeyboard(event sdl.Event, in *input.Input) {
 		return
 	}
 
+	in := &m.In
 	switch e.Keysym.Sym {
 	case sdl.K_1:
 		in.PlayerStart[0].Active = state
@@ -38,5 +38,21 @@ func handleKeyboard(event sdl.Event, in *input.Input) {
 		in.Joysticks[0].Left = state
 	case sdl.K_RIGHT:
 		in.Joysticks[0].Right = state
+	case sdl.K_SPACE:
+		in.Joysticks[0].Fire = state
+	case sdl.K_BACKSPACE:
+		m.rewinding = state
+	case sdl.K_F12:
+		if state && e.Repeat == 0 {
+			m.screenshot("")
+		}
+	}
+
+	if slot, ok := slotKeys[e.Keysym.Sym]; ok && state && e.Repeat == 0 {
+		if e.Keysym.Mod&sdl.KMOD_SHIFT != 0 {
+			m.loadSlot(slot)
+		} else {
+			m.saveSlot(slot)
+		}
 	}
 }
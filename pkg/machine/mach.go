@@ -1,7 +1,9 @@
 package machine
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -41,6 +43,7 @@ type Spec struct {
 	Name         string
 	CPU          []proc.CPU
 	Mem          []memory.Memory
+	RAM          []memory.Memory // hashed and dumped after a headless run
 	Display      video.Display
 	Audio        audio.Audio
 	TickCallback func(*Mach)
@@ -50,8 +53,8 @@ type Spec struct {
 
 type System interface {
 	Spec() *Spec
-	Save(*state.Encoder)
-	Restore(*state.Decoder)
+	Save(*state.Writer)
+	Restore(*state.Reader)
 }
 
 type CmdType int
@@ -62,6 +65,24 @@ const (
 	StartCmd
 	StopCmd
 	TraceCmd
+	ScreenshotCmd
+	RecordAudioCmd
+	RecordVideoCmd
+	RecordStopCmd
+	MovieRecordCmd
+	MoviePlayCmd
+	MovieStopCmd
+	RewindCmd
+	StepBackCmd
+	GoBackCmd
+	ProfileCmd
+	ProfileStopCmd
+	StepOverCmd
+	StepOutCmd
+	RunToCmd
+	RunCyclesCmd
+	RunFramesCmd
+	DoCmd
 	QuitCmd
 )
 
@@ -76,54 +97,120 @@ const (
 	StatusEvent EventType = iota
 	TraceEvent
 	ErrorEvent
+	InfoEvent
 )
 
 type Mach struct {
-	System        System
-	Display       video.Display
-	Audio         audio.Audio
-	In            input.Input
-	Status        Status
-	EventCallback func(EventType, interface{})
-	TickCallback  func(*Mach)
-	CharDecoder   func(uint8) (rune, bool)
-	TickRate      time.Duration
-	cyclesPerTick int
-	Cores         []Core
-	cmd           chan Cmd
-	tracing       int
-	quit          bool
+	System         System
+	Display        video.Display
+	Audio          audio.Audio
+	In             input.Input
+	Status         Status
+	EventCallback  func(EventType, interface{})
+	TickCallback   func(*Mach)
+	CharDecoder    func(uint8) (rune, bool)
+	TickRate       time.Duration
+	StoreDir       string         // directory for files created by the machine
+	ROM            *memory.Pack   // ROM images used by the system, if known
+	RewindInterval int            // frames between snapshots in the rewind buffer
+	CodeInfo       *proc.CodeInfo // symbols used by the disassemblers
+	cyclesPerTick  int
+	Cores          []Core
+	cmd            chan Cmd
+	tracing        int
+	quit           bool
+	headless       bool
+	recAudio       *audio.WAVWriter
+	recVideo       video.FrameWriter
+	movie          *input.Movie
+	moviePath      string
+	moviePlay      bool
+	movieN         int // frame number in the movie being played
+	frame          int // number of frames run
+	stopFrames     int // frames to run before stopping, if not zero
+	rewinds        *rewindBuffer
+	rewinding      bool // rewind key is held down
+	hits           []hit
+	watching       bool          // report watchpoint hits
+	watchHit       *memory.Event // first watchpoint hit by the instruction
+	hooks          map[int]*Hook
+	nextHook       int     // id of the last hook added
+	frameHooks     []*Hook // called after each frame
+	hookStop       bool    // a write hook asked for the machine to stop
 }
 
 type Core struct {
 	CPU         proc.CPU
 	Mem         memory.Memory
-	Breakpoints map[uint16]struct{}
+	Breakpoints map[uint16]*Breakpoint
 	Dasm        *proc.Disassembler
+	Spy         *memory.Spy // watchpoints, nil if not supported by the system
+	budget      int         // cycles remaining in the current tick
+	steps       int         // instructions executed
+	profile     *profile    // instruction counts, nil if not profiling
+	until       func() bool // stop when true, for stepping over or out
+	execHooks   map[uint16][]*Hook
+	writeHooks  map[uint16][]*Hook
+}
+
+// Breakpoint stops a core before it executes the instruction at the
+// address of the breakpoint.
+type Breakpoint struct {
+	Cond   func() bool // stop only when true, if not nil
+	Expr   string      // source of the condition
+	Ignore int         // number of hits to pass before stopping
+	Count  int         // stop only on this hit, if not zero
+	Hits   int         // times reached while the condition was true
+}
+
+func (b *Breakpoint) hit() bool {
+	if b.Cond != nil && !b.Cond() {
+		return false
+	}
+	b.Hits++
+	if b.Ignore > 0 {
+		b.Ignore--
+		return false
+	}
+	if b.Count != 0 && b.Hits != b.Count {
+		return false
+	}
+	return true
 }
 
 func New(sys System) *Mach {
 	spec := sys.Spec()
 	nCores := len(spec.CPU)
 	m := &Mach{
-		System:        sys,
-		EventCallback: func(EventType, interface{}) {},
-		TickCallback:  spec.TickCallback,
-		TickRate:      spec.TickRate,
-		Display:       spec.Display,
-		CharDecoder:   spec.CharDecoder,
-		Audio:         spec.Audio,
-		cmd:           make(chan Cmd, 10),
-		Cores:         make([]Core, nCores, nCores),
-		tracing:       -1,
+		System:         sys,
+		EventCallback:  func(EventType, interface{}) {},
+		TickCallback:   spec.TickCallback,
+		TickRate:       spec.TickRate,
+		Display:        spec.Display,
+		CharDecoder:    spec.CharDecoder,
+		Audio:          spec.Audio,
+		cmd:            make(chan Cmd, 10),
+		Cores:          make([]Core, nCores, nCores),
+		tracing:        -1,
+		RewindInterval: DefaultRewindInterval,
+		rewinds:        newRewindBuffer(DefaultRewindSize),
+		CodeInfo:       proc.NewCodeInfo(),
 	}
 	for i := 0; i < len(spec.CPU); i++ {
 		core := Core{
 			CPU:         spec.CPU[i],
 			Mem:         spec.Mem[i],
-			Breakpoints: make(map[uint16]struct{}),
+			Breakpoints: make(map[uint16]*Breakpoint),
 			Dasm:        spec.CPU[i].Info().NewDisassembler(spec.Mem[i]),
 		}
+		if core.Dasm != nil {
+			core.Dasm.CodeInfo = m.CodeInfo
+		}
+		if spy, ok := spec.Mem[i].(*memory.Spy); ok {
+			core.Spy = spy
+			spy.Callback(m.watch)
+			spy.HookCallback(m.hookWrite(i))
+		}
 		m.Cores[i] = core
 	}
 	return m
@@ -137,8 +224,9 @@ func (m *Mach) setStatus(s Status) {
 func (m *Mach) Run() {
 	m.quit = false
 	ticker := time.NewTicker(m.TickRate)
-	// FIXME: This needs to be done better with multi-core
-	m.cyclesPerTick = int(float64(m.TickRate) / float64(time.Millisecond) * float64(m.Cores[0].CPU.Info().CycleRate))
+	m.initCyclesPerTick()
+	defer m.stopRecording()
+	defer m.stopMovie()
 	for {
 		select {
 		case c := <-m.cmd:
@@ -152,18 +240,91 @@ func (m *Mach) Run() {
 	}
 }
 
+// RunHeadless runs the machine for the given number of frames as fast as
+// possible. The tick rate is ignored and SDL is not polled for events. If
+// script is not nil, the input for each frame is taken from the script
+// instead of the keyboard. Commands sent before each frame are handled
+// before that frame is run.
+func (m *Mach) RunHeadless(frames int, script *input.Script) {
+	m.quit = false
+	m.headless = true
+	m.initCyclesPerTick()
+	defer m.stopRecording()
+	defer m.stopMovie()
+	for frame := 0; frame < frames; frame++ {
+		m.drain()
+		if m.quit {
+			return
+		}
+		if script != nil {
+			m.In = script.At(frame)
+		}
+		m.tick()
+	}
+}
+
+func (m *Mach) initCyclesPerTick() {
+	// FIXME: This needs to be done better with multi-core
+	m.cyclesPerTick = int(float64(m.TickRate) / float64(time.Millisecond) * float64(m.Cores[0].CPU.Info().CycleRate))
+}
+
+func (m *Mach) drain() {
+	for {
+		select {
+		case c := <-m.cmd:
+			m.command(c)
+		default:
+			return
+		}
+	}
+}
+
 func (m *Mach) tick() {
-	if m.Status == Run {
+	running := m.Status == Run && !m.rewinding
+	if running {
+		m.saveRewind()
+		m.profileFrame()
 		m.execute()
+		m.frame++
+	} else if m.Status == Run {
+		m.rewindHeld()
 	}
 	if m.Display != nil {
 		m.Display.Render()
+		if running {
+			m.recordFrame()
+		}
 	}
-	if m.Audio != nil && m.Status == Run {
+	if m.Audio != nil && running {
 		if err := m.Audio.Queue(); err != nil {
 			log.Panicf("unable to queue audio: %v", err)
 		}
 	}
+	if !m.headless {
+		m.poll()
+	}
+	if running {
+		m.movieFrame()
+	}
+	if m.Status == Run {
+		m.rewinds.record(journalEntry{core: -1, in: m.In})
+	}
+	if m.TickCallback != nil {
+		m.TickCallback(m)
+	}
+	if running && m.Status == Run && m.callHooks(m.frameHooks, 0) {
+		m.cancelStops()
+		m.setStatus(Break)
+	}
+	if running && m.stopFrames > 0 && m.Status == Run {
+		m.stopFrames--
+		if m.stopFrames == 0 {
+			m.setStatus(Break)
+		}
+	}
+}
+
+func (m *Mach) poll() {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 		if _, ok := event.(*sdl.QuitEvent); ok {
 			m.quit = true
@@ -172,26 +333,85 @@ func (m *Mach) tick() {
 				m.quit = true
 			}
 		}
-		handleKeyboard(event, &m.In)
-	}
-	if m.TickCallback != nil {
-		m.TickCallback(m)
+		m.handleKeyboard(event)
 	}
 }
 
 func (m *Mach) execute() {
-	for i, core := range m.Cores {
-		for t := 0; t < m.cyclesPerTick; t++ {
+	m.watching = true
+	defer func() { m.watching = false }()
+	for i := range m.Cores {
+		core := &m.Cores[i]
+		// Cycles that overrun the budget in this tick are taken from the
+		// budget of the next tick.
+		core.budget += m.cyclesPerTick
+		for core.budget > 0 {
 			if m.tracing == i && core.CPU.Ready() {
 				core.Dasm.SetPC(core.CPU.PC())
 				m.EventCallback(TraceEvent, core.Dasm.Next())
 			}
+			start := core.CPU.Cycles()
+			pc := core.CPU.PC()
 			core.CPU.Next()
-			if _, exists := core.Breakpoints[core.CPU.PC()]; exists && core.CPU.Ready() {
+			core.steps++
+			core.budget -= core.CPU.Cycles() - start
+			if core.profile != nil {
+				core.profile.executed(pc, core.CPU.PC())
+			}
+			if m.hookStop {
+				m.hookStop = false
+				m.rewinds.record(journalEntry{core: i, steps: core.steps})
+				m.cancelStops()
+				m.setStatus(Break)
+				return
+			}
+			if m.watchHit != nil {
+				m.rewinds.record(journalEntry{core: i, steps: core.steps})
+				m.recordHit(i)
+				m.EventCallback(InfoEvent, fmt.Sprintf("watchpoint %v at pc %04x", *m.watchHit, pc))
+				m.watchHit = nil
+				m.cancelStops()
+				m.setStatus(Break)
+				return
+			}
+			if hooks, exists := core.execHooks[core.CPU.PC()]; exists && core.CPU.Ready() && m.callHooks(hooks, 0) {
+				m.rewinds.record(journalEntry{core: i, steps: core.steps})
+				m.cancelStops()
+				m.setStatus(Break)
+				return
+			}
+			if bp, exists := core.Breakpoints[core.CPU.PC()]; exists && core.CPU.Ready() && m.breakpointHit(bp) {
+				m.rewinds.record(journalEntry{core: i, steps: core.steps})
+				m.recordHit(i)
+				m.cancelStops()
+				m.setStatus(Break)
+				return
+			}
+			if core.until != nil && core.until() {
+				m.rewinds.record(journalEntry{core: i, steps: core.steps})
+				m.cancelStops()
 				m.setStatus(Break)
 				return
 			}
 		}
+		m.rewinds.record(journalEntry{core: i, steps: core.steps})
+	}
+}
+
+// breakpointHit checks bp with watchpoints off so that a condition that
+// reads memory is not reported as a watchpoint hit.
+func (m *Mach) breakpointHit(bp *Breakpoint) bool {
+	watching := m.watching
+	m.watching = false
+	defer func() { m.watching = watching }()
+	return bp.hit()
+}
+
+// watch is called by the memory spy of a core when a watched address is
+// accessed.
+func (m *Mach) watch(e memory.Event) {
+	if m.watching && m.watchHit == nil {
+		m.watchHit = &e
 	}
 }
 
@@ -199,32 +419,97 @@ func (m *Mach) Send(t CmdType, args ...interface{}) {
 	m.cmd <- Cmd{Type: t, Args: args}
 }
 
+// Do calls f from the goroutine that runs the machine and waits for it to
+// return. This is the safe way to look at or change the machine from
+// another goroutine while it is running.
+func (m *Mach) Do(f func()) {
+	done := make(chan struct{})
+	m.Send(DoCmd, f, done)
+	<-done
+}
+
 func (m *Mach) save(path string) {
 	out, err := os.Create(path)
 	if err != nil {
 		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to create snapshot: %v", err))
 		return
 	}
-	enc := state.NewEncoder(out)
-	m.System.Save(enc)
-	if enc.Err != nil {
-		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save snapshot: %v", enc.Err))
+	defer out.Close()
+	if err := m.encode(out); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save snapshot: %v", err))
 		return
 	}
+	m.EventCallback(InfoEvent, fmt.Sprintf("snapshot saved to %v", path))
 }
 
 func (m *Mach) restore(path string) {
-	out, err := os.Open(path)
+	in, err := os.Open(path)
 	if err != nil {
 		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to open snapshot: %v", err))
 		return
 	}
-	dec := state.NewDecoder(out)
-	m.System.Restore(dec)
-	if dec.Err != nil {
-		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to load snapshot: %v", dec.Err))
+	defer in.Close()
+	if err := m.decode(in); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to load snapshot: %v", err))
 		return
 	}
+	m.resetRewind()
+	m.EventCallback(InfoEvent, fmt.Sprintf("snapshot loaded from %v", path))
+}
+
+func (m *Mach) encode(w io.Writer) error {
+	sw := state.NewWriter(m.System.Spec().Name, m.romChecksums())
+	m.System.Save(sw)
+	if thumb := m.thumbnail(); thumb != nil {
+		sw.Section("thumbnail").Encode(thumb)
+	}
+	return sw.Encode(w)
+}
+
+// decode restores the state of the system from a snapshot. The snapshot is
+// rejected if it is for a different game or for different ROMs. If a section
+// is missing or cannot be decoded, the machine is put back to the state it
+// was in before.
+func (m *Mach) decode(r io.Reader) error {
+	sr, err := state.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if err := m.checkGame("snapshot", sr.Header.Game, sr.Header.ROM); err != nil {
+		return err
+	}
+	sw := state.NewWriter(m.System.Spec().Name, nil)
+	m.System.Save(sw)
+	var backup bytes.Buffer
+	if err := sw.Encode(&backup); err != nil {
+		return err
+	}
+	m.System.Restore(sr)
+	if err := sr.Err(); err != nil {
+		if br, berr := state.NewReader(&backup); berr == nil {
+			m.System.Restore(br)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkGame returns an error if a snapshot or movie (the kind) was made
+// for a different game or with different ROMs.
+func (m *Mach) checkGame(kind string, game string, rom map[string]string) error {
+	if name := m.System.Spec().Name; game != name {
+		return fmt.Errorf("%v is for %v, not %v", kind, game, name)
+	}
+	if m.ROM == nil {
+		return nil
+	}
+	sums := m.romChecksums()
+	for path, sum := range rom {
+		if sums[path] != sum {
+			return fmt.Errorf("%v was made with a different ROM: %v", kind, path)
+		}
+	}
+	return nil
 }
 
 func (m *Mach) command(c Cmd) {
@@ -236,8 +521,10 @@ func (m *Mach) command(c Cmd) {
 		path := c.Args[0].(string)
 		m.save(path)
 	case StartCmd:
+		m.cancelStops()
 		m.setStatus(Run)
 	case StopCmd:
+		m.cancelStops()
 		m.setStatus(Halt)
 	case TraceCmd:
 		core := c.Args[0].(int)
@@ -246,6 +533,47 @@ func (m *Mach) command(c Cmd) {
 		} else {
 			m.tracing = core
 		}
+	case ScreenshotCmd:
+		path := ""
+		if len(c.Args) > 0 {
+			path = c.Args[0].(string)
+		}
+		m.screenshot(path)
+	case RecordAudioCmd:
+		m.recordAudio(c.Args[0].(string))
+	case RecordVideoCmd:
+		m.recordVideo(c.Args[0].(string))
+	case RecordStopCmd:
+		m.stopRecording()
+	case MovieRecordCmd:
+		m.recordMovie(c.Args[0].(string))
+	case MoviePlayCmd:
+		m.playMovie(c.Args[0].(string))
+	case MovieStopCmd:
+		m.stopMovie()
+	case RewindCmd:
+		m.rewindCmd(c.Args[0].(int))
+	case StepBackCmd:
+		m.reverseCmd(c.Args[0].(int), m.stepBack)
+	case GoBackCmd:
+		m.reverseCmd(c.Args[0].(int), m.goBackToHit)
+	case ProfileCmd:
+		m.startProfile(c.Args[0].(bool))
+	case ProfileStopCmd:
+		m.stopProfile()
+	case StepOverCmd:
+		m.stepOver(c.Args[0].(int))
+	case StepOutCmd:
+		m.stepOut(c.Args[0].(int))
+	case RunToCmd:
+		m.runTo(c.Args[0].(int), c.Args[1].(uint16))
+	case RunCyclesCmd:
+		m.runCycles(c.Args[0].(int), c.Args[1].(int))
+	case RunFramesCmd:
+		m.runFrames(c.Args[0].(int))
+	case DoCmd:
+		c.Args[0].(func())()
+		close(c.Args[1].(chan struct{}))
 	case QuitCmd:
 		m.quit = true
 	default:
@@ -0,0 +1,68 @@
+package machine
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+func TestBreakpointIgnore(t *testing.T) {
+	m := newTestMach()
+	m.Cores[0].Breakpoints[0x0009] = &Breakpoint{Ignore: 2}
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].Mem.Load(0x4000)).ToBe(uint8(2))
+}
+
+func TestBreakpointCount(t *testing.T) {
+	m := newTestMach()
+	m.Cores[0].Breakpoints[0x0009] = &Breakpoint{Count: 5}
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].Mem.Load(0x4000)).ToBe(uint8(4))
+}
+
+func TestBreakpointCond(t *testing.T) {
+	m := newTestMach()
+	mem := m.Cores[0].Mem
+	m.Cores[0].Breakpoints[0x0009] = &Breakpoint{
+		Cond: func() bool { return mem.Load(0x4000) == 0x10 },
+	}
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(mem.Load(0x4000)).ToBe(uint8(0x10))
+	With(t).Expect(m.Cores[0].Breakpoints[0x0009].Hits).ToBe(1)
+}
+
+func TestBreakpointCondNotWatched(t *testing.T) {
+	m := newHookMach()
+	mem := m.Cores[0].Mem
+	m.Cores[0].Spy.WatchR(0x5000)
+	m.Cores[0].Breakpoints[0x0003] = &Breakpoint{
+		Cond: func() bool { return mem.Load(0x5000) == 1 },
+	}
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Run)
+}
+
+func TestDecodeMissingSection(t *testing.T) {
+	m := newTestMach()
+	m.RunHeadless(1, nil)
+	before := testState(m)
+
+	// A snapshot of a fresh machine that only has the cpu section
+	fresh := newTestMach()
+	sw := state.NewWriter("test", nil)
+	fresh.Cores[0].CPU.Save(sw.Section("cpu"))
+	var buf bytes.Buffer
+	if err := sw.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.decode(&buf); err == nil {
+		t.Fatal("expected error")
+	}
+	With(t).Expect(testState(m)).ToBe(before)
+}
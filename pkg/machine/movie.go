@@ -0,0 +1,102 @@
+package machine
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+)
+
+// recordMovie starts recording all inputs to a movie at path. The movie
+// starts with a snapshot of the machine and is written when recording is
+// stopped.
+func (m *Mach) recordMovie(path string) {
+	m.stopMovie()
+	var snapshot bytes.Buffer
+	if err := m.encode(&snapshot); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save snapshot: %v", err))
+		return
+	}
+	m.resetBudget()
+	m.movie = &input.Movie{
+		Game:     m.System.Spec().Name,
+		ROM:      m.romChecksums(),
+		Snapshot: snapshot.Bytes(),
+	}
+	m.moviePath = path
+	m.moviePlay = false
+	m.EventCallback(InfoEvent, fmt.Sprintf("recording movie to %v", path))
+}
+
+// playMovie restores the snapshot in the movie at path and then replays
+// its inputs. Input from the keyboard or input script is ignored until
+// the movie is finished.
+func (m *Mach) playMovie(path string) {
+	m.stopMovie()
+	movie, err := input.LoadMovie(path)
+	if err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to load movie: %v", err))
+		return
+	}
+	if err := m.checkGame("movie", movie.Game, movie.ROM); err != nil {
+		m.EventCallback(ErrorEvent, err.Error())
+		return
+	}
+	if err := m.decode(bytes.NewReader(movie.Snapshot)); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to load snapshot: %v", err))
+		return
+	}
+	m.resetBudget()
+	m.resetRewind()
+	m.movie = movie
+	m.moviePath = path
+	m.moviePlay = true
+	m.EventCallback(InfoEvent, fmt.Sprintf("playing movie %v", path))
+}
+
+// movieFrame records or replays the inputs for the current frame.
+func (m *Mach) movieFrame() {
+	if m.movie == nil {
+		return
+	}
+	if !m.moviePlay {
+		m.movie.Record(m.In)
+		return
+	}
+	if m.movieN >= m.movie.Frames {
+		m.stopMovie()
+		return
+	}
+	m.In = m.movie.Input.At(m.movieN)
+	m.movieN++
+}
+
+func (m *Mach) stopMovie() {
+	if m.movie == nil {
+		return
+	}
+	if m.moviePlay {
+		m.EventCallback(InfoEvent, "movie finished")
+	} else if err := input.SaveMovie(m.moviePath, m.movie); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save movie: %v", err))
+	} else {
+		m.EventCallback(InfoEvent, fmt.Sprintf("movie saved to %v", m.moviePath))
+	}
+	m.movie = nil
+	m.movieN = 0
+}
+
+func (m *Mach) romChecksums() map[string]string {
+	if m.ROM == nil {
+		return map[string]string{}
+	}
+	return m.ROM.Checksums()
+}
+
+// resetBudget discards any cycles carried over from the previous tick so
+// that execution after a snapshot does not depend on when it was taken.
+func (m *Mach) resetBudget() {
+	for i := range m.Cores {
+		m.Cores[i].budget = 0
+	}
+}
@@ -0,0 +1,283 @@
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Mnemonics of instructions that call a subroutine
+var callMnemonics = map[string]bool{
+	"call": true,
+	"rst":  true,
+	"jsr":  true,
+}
+
+// maxSequential is the length of the longest instruction. If the program
+// counter moves further than this, or backwards, the instruction was a
+// jump, call, or return.
+const maxSequential = 4
+
+// profile counts how many times each instruction is executed on a core.
+type profile struct {
+	perFrame bool
+	frames   int
+	counts   []uint64          // executions by address
+	jumps    map[uint32]uint64 // executions by source<<16 | target
+	last     *profile          // last complete frame when perFrame
+}
+
+func newProfile() *profile {
+	return &profile{
+		counts: make([]uint64, 0x10000),
+		jumps:  make(map[uint32]uint64),
+	}
+}
+
+func (p *profile) executed(pc uint16, next uint16) {
+	p.counts[pc]++
+	if next < pc || int(next) > int(pc)+maxSequential {
+		p.jumps[uint32(pc)<<16|uint32(next)]++
+	}
+}
+
+// frame is called at the start of each frame that is run. When counting
+// per frame, the counts of the frame that just ended are kept for the
+// report and counting starts again.
+func (p *profile) frame() {
+	if p.perFrame && p.frames > 0 {
+		var counts []uint64
+		if p.last != nil {
+			counts = p.last.counts
+			for i := range counts {
+				counts[i] = 0
+			}
+		} else {
+			counts = make([]uint64, 0x10000)
+		}
+		p.last = &profile{counts: p.counts, jumps: p.jumps, frames: p.frames}
+		p.counts = counts
+		p.jumps = make(map[uint32]uint64)
+		p.frames = 0
+	}
+	p.frames++
+}
+
+// ProfileEntry is the number of times an address was executed or called.
+type ProfileEntry struct {
+	Address uint16 `json:"address"`
+	Label   string `json:"label,omitempty"`
+	Count   uint64 `json:"count"`
+}
+
+// ProfileRange is a range of addresses, inclusive.
+type ProfileRange struct {
+	Start uint16 `json:"start"`
+	End   uint16 `json:"end"`
+}
+
+// ProfileReport summarizes the instructions executed by a core.
+type ProfileReport struct {
+	Core         int            `json:"core"`
+	PerFrame     bool           `json:"perFrame"` // counts are for the last frame only
+	Frames       int            `json:"frames"`
+	Instructions uint64         `json:"instructions"`
+	Hot          []ProfileEntry `json:"hot"`   // executed addresses, most executed first
+	Calls        []ProfileEntry `json:"calls"` // subroutines, most called first
+	Range        ProfileRange   `json:"range"` // addresses checked for coverage
+	Covered      int            `json:"covered"`
+	Unexecuted   []ProfileRange `json:"unexecuted"`
+}
+
+// startProfile starts counting the instructions executed on all cores. Any
+// previous counts are discarded. If perFrame is true, counts are reset at
+// the start of each frame and the report covers the last complete frame.
+func (m *Mach) startProfile(perFrame bool) {
+	for i := range m.Cores {
+		p := newProfile()
+		p.perFrame = perFrame
+		m.Cores[i].profile = p
+	}
+}
+
+func (m *Mach) stopProfile() {
+	for i := range m.Cores {
+		m.Cores[i].profile = nil
+	}
+}
+
+func (m *Mach) profileFrame() {
+	for i := range m.Cores {
+		if p := m.Cores[i].profile; p != nil {
+			p.frame()
+		}
+	}
+}
+
+// Profiling returns true if instructions are being counted.
+func (m *Mach) Profiling() bool {
+	return len(m.Cores) > 0 && m.Cores[0].profile != nil
+}
+
+// Profile returns a report of the instructions executed on core. Coverage
+// is checked for the addresses from start to end. If end is less than
+// start, the range from the lowest to the highest executed address is
+// used. An error is returned if profiling is not on. It must be called
+// from the machine goroutine, such as with Do.
+func (m *Mach) Profile(core int, start int, end int) (*ProfileReport, error) {
+	c := &m.Cores[core]
+	p := c.profile
+	if p == nil {
+		return nil, fmt.Errorf("profiling is not on")
+	}
+	if p.perFrame {
+		if p.last == nil {
+			return nil, fmt.Errorf("no frames have been run")
+		}
+		p = p.last
+	}
+	r := &ProfileReport{
+		Core:       core,
+		PerFrame:   c.profile.perFrame,
+		Frames:     p.frames,
+		Hot:        make([]ProfileEntry, 0, 0),
+		Calls:      make([]ProfileEntry, 0, 0),
+		Unexecuted: make([]ProfileRange, 0, 0),
+	}
+	label := func(addr uint16) string {
+		if m.CodeInfo == nil {
+			return ""
+		}
+		return m.CodeInfo.Labels[addr]
+	}
+
+	dasm := c.CPU.Info().NewDisassembler(c.Mem)
+	if dasm != nil {
+		dasm.CodeInfo = nil
+	}
+	length := func(addr uint16) int {
+		if dasm == nil {
+			return 1
+		}
+		dasm.SetPC(addr)
+		return len(dasm.NextStatement().Bytes)
+	}
+
+	lo, hi := -1, -1
+	covered := make([]bool, 0x10000)
+	for addr, n := range p.counts {
+		if n == 0 {
+			continue
+		}
+		r.Instructions += n
+		r.Hot = append(r.Hot, ProfileEntry{Address: uint16(addr), Label: label(uint16(addr)), Count: n})
+		for i := addr; i < addr+length(uint16(addr)) && i < len(covered); i++ {
+			covered[i] = true
+		}
+		if lo < 0 {
+			lo = addr
+		}
+		hi = addr
+	}
+	sortEntries(r.Hot)
+
+	calls := make(map[uint16]uint64)
+	for key, n := range p.jumps {
+		from, to := uint16(key>>16), uint16(key)
+		if dasm == nil {
+			continue
+		}
+		dasm.SetPC(from)
+		fields := strings.Fields(dasm.NextStatement().Op)
+		if len(fields) > 0 && callMnemonics[fields[0]] {
+			calls[to] += n
+		}
+	}
+	for addr, n := range calls {
+		r.Calls = append(r.Calls, ProfileEntry{Address: addr, Label: label(addr), Count: n})
+	}
+	sortEntries(r.Calls)
+
+	if end < start {
+		start, end = lo, hi
+	}
+	if end > 0xffff {
+		end = 0xffff
+	}
+	if start < 0 {
+		return r, nil
+	}
+	r.Range = ProfileRange{Start: uint16(start), End: uint16(end)}
+	for addr := start; addr <= end; addr++ {
+		if covered[addr] {
+			r.Covered++
+			continue
+		}
+		n := len(r.Unexecuted)
+		if n > 0 && int(r.Unexecuted[n-1].End) == addr-1 {
+			r.Unexecuted[n-1].End = uint16(addr)
+		} else {
+			r.Unexecuted = append(r.Unexecuted, ProfileRange{Start: uint16(addr), End: uint16(addr)})
+		}
+	}
+	return r, nil
+}
+
+func sortEntries(entries []ProfileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Address < entries[j].Address
+	})
+}
+
+func formatEntry(e ProfileEntry, frames int) string {
+	perFrame := float64(e.Count)
+	if frames > 0 {
+		perFrame /= float64(frames)
+	}
+	return fmt.Sprintf("$%04x  %-16s %10d %12.1f", e.Address, e.Label, e.Count, perFrame)
+}
+
+// WriteText writes the report for people to read. Only the top n entries
+// of each list are included.
+func (r *ProfileReport) WriteText(w io.Writer, n int) error {
+	var b strings.Builder
+	if r.PerFrame {
+		fmt.Fprintf(&b, "core %v: %v instructions in the last frame\n", r.Core+1, r.Instructions)
+	} else {
+		fmt.Fprintf(&b, "core %v: %v instructions in %v frames\n", r.Core+1, r.Instructions, r.Frames)
+	}
+	fmt.Fprintf(&b, "\nhot addresses %30s %12s\n", "count", "per frame")
+	for i := 0; i < n && i < len(r.Hot); i++ {
+		fmt.Fprintln(&b, formatEntry(r.Hot[i], r.Frames))
+	}
+	fmt.Fprintf(&b, "\nhot subroutines %28s %12s\n", "calls", "per frame")
+	for i := 0; i < n && i < len(r.Calls); i++ {
+		fmt.Fprintln(&b, formatEntry(r.Calls[i], r.Frames))
+	}
+	size := int(r.Range.End) - int(r.Range.Start) + 1
+	if r.Instructions > 0 {
+		fmt.Fprintf(&b, "\nnever executed in $%04x - $%04x (%.1f%% covered)\n",
+			r.Range.Start, r.Range.End, float64(r.Covered)*100/float64(size))
+		for i := 0; i < n && i < len(r.Unexecuted); i++ {
+			u := r.Unexecuted[i]
+			fmt.Fprintf(&b, "$%04x - $%04x  %6d bytes\n", u.Start, u.End, int(u.End)-int(u.Start)+1)
+		}
+		if more := len(r.Unexecuted) - n; more > 0 {
+			fmt.Fprintf(&b, "... %v more\n", more)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteProfiles writes reports in JSON.
+func WriteProfiles(w io.Writer, reports []*ProfileReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
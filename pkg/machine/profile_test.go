@@ -0,0 +1,91 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+// newProfileMach returns the test machine with an interrupt handler that
+// calls a subroutine.
+func newProfileMach() *Mach {
+	m := newTestMach()
+	c := memory.NewCursor(m.Cores[0].Mem)
+	c.Pos = 0x38
+	c.PutN(
+		0xcd, 0x50, 0x00, // call $0050
+		0xfb,       // ei
+		0xed, 0x4d, // reti
+	)
+	c.Pos = 0x50
+	c.PutN(
+		0x3a, 0x01, 0x40, // ld a,($4001)
+		0x3c,             // inc a
+		0x32, 0x01, 0x40, // ld ($4001),a
+		0xc9, // ret
+	)
+	return m
+}
+
+func TestProfile(t *testing.T) {
+	m := newProfileMach()
+	m.CodeInfo.Labels[0x0050] = "count"
+	m.Send(ProfileCmd, false)
+	m.RunHeadless(10, nil)
+	r, err := m.Profile(0, 0, 0x57)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(r.Frames).ToBe(10)
+	With(t).Expect(r.Hot[0].Address).ToBe(uint16(0x0009))
+	With(t).Expect(len(r.Calls)).ToBe(1)
+	With(t).Expect(r.Calls[0]).ToBe(ProfileEntry{Address: 0x50, Label: "count", Count: 9})
+	With(t).Expect(r.Unexecuted).ToBe([]ProfileRange{
+		{Start: 0x000c, End: 0x0037},
+		{Start: 0x003e, End: 0x004f},
+	})
+	With(t).Expect(r.Covered).ToBe(0x58 - 0x2c - 0x12)
+}
+
+func TestProfilePerFrame(t *testing.T) {
+	m := newProfileMach()
+	m.Send(ProfileCmd, true)
+	m.RunHeadless(10, nil)
+	r, err := m.Profile(0, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(r.Frames).ToBe(1)
+	With(t).Expect(r.Calls[0].Count).ToBe(uint64(1))
+	With(t).Expect(r.Range).ToBe(ProfileRange{Start: 0x0009, End: 0x0057})
+}
+
+func TestProfileOff(t *testing.T) {
+	m := newProfileMach()
+	m.RunHeadless(1, nil)
+	if _, err := m.Profile(0, 0, -1); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestProfileJSON(t *testing.T) {
+	m := newProfileMach()
+	m.Send(ProfileCmd, false)
+	m.RunHeadless(2, nil)
+	r, err := m.Profile(0, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteProfiles(&buf, []*ProfileReport{r}); err != nil {
+		t.Fatal(err)
+	}
+	var reports []ProfileReport
+	if err := json.Unmarshal(buf.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(reports[0].Instructions).ToBe(r.Instructions)
+}
@@ -0,0 +1,85 @@
+package machine
+
+import (
+	"fmt"
+
+	"github.com/blackchip-org/pac8/pkg/audio"
+	"github.com/blackchip-org/pac8/pkg/video"
+)
+
+// recordAudio starts recording the audio output to a WAV file at path.
+func (m *Mach) recordAudio(path string) {
+	tapper, ok := m.Audio.(audio.Tapper)
+	if !ok {
+		m.EventCallback(ErrorEvent, "audio does not support recording")
+		return
+	}
+	m.stopAudioRecording()
+	w, err := audio.CreateWAV(path, tapper.SampleRate())
+	if err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to record audio: %v", err))
+		return
+	}
+	tapper.SetTap(w)
+	m.recAudio = w
+	m.EventCallback(InfoEvent, fmt.Sprintf("recording audio to %v", path))
+}
+
+// recordVideo starts recording each frame rendered to path. If path ends
+// with ".gif" an animated GIF is created. Otherwise, path is a directory
+// where a PNG file is created for each frame.
+func (m *Mach) recordVideo(path string) {
+	if _, ok := m.Display.(video.Framer); !ok {
+		m.EventCallback(ErrorEvent, "display does not support recording")
+		return
+	}
+	m.stopVideoRecording()
+	w, err := video.CreateFrameWriter(path, m.TickRate)
+	if err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to record video: %v", err))
+		return
+	}
+	m.recVideo = w
+	m.EventCallback(InfoEvent, fmt.Sprintf("recording video to %v", path))
+}
+
+func (m *Mach) recordFrame() {
+	if m.recVideo == nil {
+		return
+	}
+	frame := m.Display.(video.Framer).Frame()
+	if err := m.recVideo.WriteFrame(frame); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to record video: %v", err))
+		m.stopVideoRecording()
+	}
+}
+
+func (m *Mach) stopRecording() {
+	m.stopAudioRecording()
+	m.stopVideoRecording()
+}
+
+func (m *Mach) stopAudioRecording() {
+	if m.recAudio == nil {
+		return
+	}
+	m.Audio.(audio.Tapper).SetTap(nil)
+	if err := m.recAudio.Close(); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to record audio: %v", err))
+	} else {
+		m.EventCallback(InfoEvent, "audio recording stopped")
+	}
+	m.recAudio = nil
+}
+
+func (m *Mach) stopVideoRecording() {
+	if m.recVideo == nil {
+		return
+	}
+	if err := m.recVideo.Close(); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to record video: %v", err))
+	} else {
+		m.EventCallback(InfoEvent, "video recording stopped")
+	}
+	m.recVideo = nil
+}
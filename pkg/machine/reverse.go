@@ -0,0 +1,154 @@
+package machine
+
+import (
+	"fmt"
+
+	"github.com/blackchip-org/pac8/pkg/input"
+)
+
+// maxHits is the number of breakpoint hits remembered for going back.
+const maxHits = 100
+
+// journalEntry is something that happened to the machine after a snapshot
+// in the rewind buffer was taken. Replaying the journal from the snapshot
+// brings the machine back to the state it was in at any instruction after
+// the snapshot.
+type journalEntry struct {
+	core  int         // core that ran, or -1 for the end of a tick
+	steps int         // instructions executed by the core after the run
+	in    input.Input // inputs at the end of the tick
+}
+
+// hit is the position of a core when it stopped at a breakpoint.
+type hit struct {
+	core  int
+	steps int
+}
+
+func (m *Mach) steps() []int {
+	steps := make([]int, len(m.Cores))
+	for i := range m.Cores {
+		steps[i] = m.Cores[i].steps
+	}
+	return steps
+}
+
+// Step executes the next instruction on core.
+func (m *Mach) Step(core int) {
+	c := &m.Cores[core]
+	c.CPU.Next()
+	c.steps++
+	m.rewinds.record(journalEntry{core: core, steps: c.steps})
+}
+
+func (m *Mach) recordHit(core int) {
+	if len(m.hits) == maxHits {
+		m.hits = append(m.hits[:0], m.hits[1:]...)
+	}
+	m.hits = append(m.hits, hit{core: core, steps: m.Cores[core].steps})
+}
+
+// pruneHits forgets the breakpoint hits that are ahead of the cores.
+func (m *Mach) pruneHits() {
+	hits := m.hits[:0]
+	for _, h := range m.hits {
+		if h.steps <= m.Cores[h.core].steps {
+			hits = append(hits, h)
+		}
+	}
+	m.hits = hits
+}
+
+// stepBack restores the machine to its state before the last instruction
+// executed on core.
+func (m *Mach) stepBack(core int) error {
+	steps := m.Cores[core].steps
+	if steps == 0 {
+		return fmt.Errorf("no instructions have been executed")
+	}
+	return m.goBack(core, steps-1)
+}
+
+// goBackToHit restores the machine to the previous time that core stopped
+// at a breakpoint.
+func (m *Mach) goBackToHit(core int) error {
+	steps := m.Cores[core].steps
+	for i := len(m.hits) - 1; i >= 0; i-- {
+		h := m.hits[i]
+		if h.core == core && h.steps < steps {
+			return m.goBack(core, h.steps)
+		}
+	}
+	return fmt.Errorf("no previous breakpoint")
+}
+
+// goBack restores the machine to the state it was in when core had
+// executed the given number of instructions. The closest snapshot before
+// that point is restored and then the journal is replayed up to that
+// point. Snapshots taken after that point are discarded.
+//
+// Hooks are not called while the journal is replayed so going back is
+// refused when there are any. A hook that changes memory would otherwise
+// be missed and the machine would end up in a state it was never in.
+func (m *Mach) goBack(core int, steps int) error {
+	if m.movie != nil {
+		return fmt.Errorf("not available during a movie")
+	}
+	if len(m.hooks) > 0 {
+		return fmt.Errorf("not available while hooks are installed")
+	}
+	i := m.rewinds.find(core, steps)
+	if i < 0 {
+		return fmt.Errorf("too far back for the rewind buffer")
+	}
+	snapshot, err := m.rewinds.truncate(i)
+	if err != nil {
+		return err
+	}
+	e := &m.rewinds.entries[i]
+	journal := e.journal
+	e.journal = nil
+	if err := m.restoreEntry(e, snapshot); err != nil {
+		return err
+	}
+
+	status, in := m.Status, m.In
+	m.Status = Run
+	for _, j := range journal {
+		// The end of the tick that the core stopped in is also replayed
+		if j.core >= 0 && m.Cores[core].steps == steps {
+			break
+		}
+		if j.core < 0 {
+			// Same order as the end of a tick
+			m.frame++
+			m.In = j.in
+			m.rewinds.record(j)
+			if m.TickCallback != nil {
+				m.TickCallback(m)
+			}
+			continue
+		}
+		end := j.steps
+		if j.core == core && end > steps {
+			end = steps
+		}
+		c := &m.Cores[j.core]
+		for c.steps < end {
+			c.CPU.Next()
+			c.steps++
+		}
+		m.rewinds.record(journalEntry{core: j.core, steps: end})
+	}
+	m.Status, m.In = status, in
+	m.pruneHits()
+	return nil
+}
+
+func (m *Mach) reverseCmd(core int, back func(int) error) {
+	if err := back(core); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to go back: %v", err))
+		return
+	}
+	m.setStatus(Break)
+}
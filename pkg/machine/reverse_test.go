@@ -0,0 +1,166 @@
+package machine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+	"github.com/blackchip-org/pac8/pkg/z80"
+)
+
+type testSys struct {
+	spec *Spec
+}
+
+func (s testSys) Spec() *Spec {
+	return s.spec
+}
+
+func (s testSys) Save(w *state.Writer) {
+	s.spec.CPU[0].Save(w.Section("cpu"))
+	s.spec.Mem[0].Save(w.Section("mem"))
+}
+
+func (s testSys) Restore(r *state.Reader) {
+	s.spec.CPU[0].Restore(r.Section("cpu"))
+	s.spec.Mem[0].Restore(r.Section("mem"))
+}
+
+// newTestMach returns a machine that increments $4000 in a loop and
+// increments $4001 on each interrupt. An interrupt is requested at the
+// end of each tick.
+func newTestMach() *Mach {
+	mem := memory.NewRAM(0x10000)
+	memory.NewCursor(mem).PutN(
+		0x31, 0x00, 0x80, // ld sp,$8000
+		0xed, 0x56, // im 1
+		0xfb,             // ei
+		0x21, 0x00, 0x40, // ld hl,$4000
+		0x34,       // loop: inc (hl)
+		0x18, 0xfd, // jr loop
+	)
+	c := memory.NewCursor(mem)
+	c.Pos = 0x38
+	c.PutN(
+		0xf5,             // push af
+		0x3a, 0x01, 0x40, // ld a,($4001)
+		0x3c,             // inc a
+		0x32, 0x01, 0x40, // ld ($4001),a
+		0xf1,       // pop af
+		0xfb,       // ei
+		0xed, 0x4d, // reti
+	)
+	m := newMemMach(mem, func(m *Mach) {
+		if m.Status == Run {
+			m.Cores[0].CPU.(*z80.CPU).INT(0xff)
+		}
+	})
+	m.Status = Run
+	return m
+}
+
+// newMemMach returns a halted machine with a Z80 that runs the program in
+// mem. If tick is not nil, it is called at the end of each tick.
+func newMemMach(mem memory.Memory, tick func(*Mach)) *Mach {
+	sys := testSys{spec: &Spec{
+		Name:         "test",
+		CPU:          []proc.CPU{z80.New(mem)},
+		Mem:          []memory.Memory{mem},
+		TickCallback: tick,
+		TickRate:     16670 * time.Microsecond,
+	}}
+	return New(sys)
+}
+
+func testState(m *Mach) string {
+	mem := m.Cores[0].Mem
+	return fmt.Sprintf("%v\n%02x %02x %02x %02x", m.Cores[0].CPU,
+		mem.Load(0x4000), mem.Load(0x4001), mem.Load(0x7ffe), mem.Load(0x7fff))
+}
+
+func TestStepBack(t *testing.T) {
+	m := newTestMach()
+	m.RunHeadless(25, nil)
+	want := testState(m)
+	m.Step(0)
+	With(t).Expect(testState(m)).NotToBe(want)
+	if err := m.stepBack(0); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(testState(m)).ToBe(want)
+}
+
+func TestStepBackTwice(t *testing.T) {
+	m := newTestMach()
+	m.RunHeadless(25, nil)
+	want := testState(m)
+	m.Step(0)
+	m.Step(0)
+	m.stepBack(0)
+	if err := m.stepBack(0); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(testState(m)).ToBe(want)
+}
+
+func TestGoBackToHit(t *testing.T) {
+	m := newTestMach()
+	m.Cores[0].Breakpoints[0x38] = &Breakpoint{}
+	m.RunHeadless(2, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	want := testState(m)
+
+	m.Status = Run
+	m.RunHeadless(2, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(testState(m)).NotToBe(want)
+
+	if err := m.goBackToHit(0); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(testState(m)).ToBe(want)
+	if err := m.goBackToHit(0); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestStepBackFrame(t *testing.T) {
+	m := newTestMach()
+	tick := m.TickCallback
+	m.TickCallback = func(m *Mach) {
+		tick(m)
+		m.Cores[0].Mem.Store(0x5000, uint8(m.frame))
+	}
+	m.RunHeadless(25, nil)
+	want := testState(m)
+	m.Step(0)
+	if err := m.stepBack(0); err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(testState(m)).ToBe(want)
+	With(t).Expect(m.Cores[0].Mem.Load(0x5000)).ToBe(uint8(25))
+	With(t).Expect(m.frame).ToBe(25)
+}
+
+func TestStepBackWithHook(t *testing.T) {
+	m := newTestMach()
+	m.AddHook(Hook{
+		Type: FrameHook,
+		Func: func(uint8) bool {
+			m.Cores[0].Mem.Store(0x5000, m.Cores[0].Mem.Load(0x5000)+1)
+			return false
+		},
+	})
+	m.RunHeadless(25, nil)
+	want := testState(m)
+	m.Step(0)
+	if err := m.stepBack(0); err == nil {
+		t.Fatal("expected error")
+	}
+	With(t).Expect(testState(m)).NotToBe(want)
+	With(t).Expect(m.Cores[0].Mem.Load(0x5000)).ToBe(uint8(25))
+}
@@ -0,0 +1,260 @@
+package machine
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+const (
+	// DefaultRewindInterval is the number of frames between snapshots in
+	// the rewind buffer.
+	DefaultRewindInterval = 10
+	// DefaultRewindSize is the number of snapshots kept in the rewind
+	// buffer.
+	DefaultRewindSize = 60
+)
+
+type rewindEntry struct {
+	frame   int
+	steps   []int          // instructions executed by each core
+	journal []journalEntry // what happened until the next snapshot
+	n       int            // length of the snapshot
+	delta   []byte         // compressed difference to the next snapshot
+}
+
+// rewindBuffer is a ring of snapshots taken while the machine runs. Only
+// the most recent snapshot is kept as is. Each of the older snapshots is
+// stored as the compressed difference to the snapshot that follows it.
+// Most of memory is unchanged between snapshots so the differences are
+// small and the oldest snapshot can be dropped without touching the
+// others.
+type rewindBuffer struct {
+	size    int
+	entries []rewindEntry
+	last    []byte
+}
+
+func newRewindBuffer(size int) *rewindBuffer {
+	return &rewindBuffer{
+		size:    size,
+		entries: make([]rewindEntry, 0, size),
+	}
+}
+
+func (b *rewindBuffer) len() int {
+	return len(b.entries)
+}
+
+// push adds a snapshot to the buffer. If the buffer is full, the oldest
+// snapshot is dropped.
+func (b *rewindBuffer) push(e rewindEntry, snapshot []byte) error {
+	if n := len(b.entries); n > 0 {
+		delta, err := diff(b.last, snapshot)
+		if err != nil {
+			return err
+		}
+		b.entries[n-1].delta = delta
+	}
+	if len(b.entries) == b.size {
+		b.entries = append(b.entries[:0], b.entries[1:]...)
+	}
+	e.n = len(snapshot)
+	b.entries = append(b.entries, e)
+	b.last = snapshot
+	return nil
+}
+
+// rewind discards all snapshots taken after frame and returns the most
+// recent snapshot that remains. The oldest snapshot is never discarded.
+func (b *rewindBuffer) rewind(frame int) (*rewindEntry, []byte, error) {
+	if len(b.entries) == 0 {
+		return nil, nil, fmt.Errorf("rewind buffer is empty")
+	}
+	i := len(b.entries) - 1
+	for i > 0 && b.entries[i].frame > frame {
+		i--
+	}
+	snapshot, err := b.truncate(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &b.entries[i]
+	e.journal = nil
+	return e, snapshot, nil
+}
+
+// truncate discards all snapshots after the one at index i and returns
+// that snapshot.
+func (b *rewindBuffer) truncate(i int) ([]byte, error) {
+	for n := len(b.entries); n > i+1; n-- {
+		prev := &b.entries[n-2]
+		snapshot, err := patch(b.last, prev.delta, prev.n)
+		if err != nil {
+			return nil, err
+		}
+		prev.delta = nil
+		b.entries = b.entries[:n-1]
+		b.last = snapshot
+	}
+	return b.last, nil
+}
+
+// find returns the index of the most recent snapshot taken when core had
+// not yet executed more than steps instructions. If there is no such
+// snapshot, -1 is returned.
+func (b *rewindBuffer) find(core int, steps int) int {
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		if b.entries[i].steps[core] <= steps {
+			return i
+		}
+	}
+	return -1
+}
+
+// record adds an entry to the journal of the most recent snapshot. A run
+// of instructions on a core is merged with the previous entry if it is
+// for the same core.
+func (b *rewindBuffer) record(j journalEntry) {
+	n := len(b.entries)
+	if n == 0 {
+		return
+	}
+	e := &b.entries[n-1]
+	if m := len(e.journal); m > 0 && j.core >= 0 && e.journal[m-1].core == j.core {
+		e.journal[m-1].steps = j.steps
+		return
+	}
+	e.journal = append(e.journal, j)
+}
+
+func (b *rewindBuffer) reset() {
+	b.entries = b.entries[:0]
+	b.last = nil
+}
+
+// diff returns the compressed exclusive-or of from and to. The snapshot
+// from can be recovered by applying the difference to to with patch.
+func diff(from []byte, to []byte) ([]byte, error) {
+	x := make([]byte, len(from))
+	for i := range x {
+		x[i] = from[i]
+		if i < len(to) {
+			x[i] ^= to[i]
+		}
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(x); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func patch(to []byte, delta []byte, n int) ([]byte, error) {
+	x, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(delta)))
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != n {
+		return nil, fmt.Errorf("invalid rewind delta")
+	}
+	for i := range x {
+		if i < len(to) {
+			x[i] ^= to[i]
+		}
+	}
+	return x, nil
+}
+
+// saveRewind adds a snapshot of the machine to the rewind buffer at the
+// start of every RewindInterval frames.
+func (m *Mach) saveRewind() {
+	if m.frame%m.RewindInterval != 0 {
+		return
+	}
+	if n := m.rewinds.len(); n > 0 && m.rewinds.entries[n-1].frame == m.frame {
+		return
+	}
+	sw := state.NewWriter(m.System.Spec().Name, nil)
+	m.System.Save(sw)
+	var buf bytes.Buffer
+	if err := sw.Encode(&buf); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save rewind snapshot: %v", err))
+		return
+	}
+	e := rewindEntry{frame: m.frame, steps: m.steps()}
+	if err := m.rewinds.push(e, buf.Bytes()); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save rewind snapshot: %v", err))
+	}
+}
+
+// rewind restores the machine to the most recent snapshot in the rewind
+// buffer that was taken at least frames ago. If there is no snapshot that
+// old, the oldest one is used. It returns the number of frames actually
+// rewound.
+func (m *Mach) rewind(frames int) (int, error) {
+	if m.movie != nil {
+		return 0, fmt.Errorf("unable to rewind during a movie")
+	}
+	e, snapshot, err := m.rewinds.rewind(m.frame - frames)
+	if err != nil {
+		return 0, err
+	}
+	rewound := m.frame - e.frame
+	if err := m.restoreEntry(e, snapshot); err != nil {
+		return 0, err
+	}
+	return rewound, nil
+}
+
+func (m *Mach) restoreEntry(e *rewindEntry, snapshot []byte) error {
+	if err := m.decode(bytes.NewReader(snapshot)); err != nil {
+		return err
+	}
+	m.resetBudget()
+	m.frame = e.frame
+	for i := range m.Cores {
+		m.Cores[i].steps = e.steps[i]
+	}
+	m.pruneHits()
+	return nil
+}
+
+// resetRewind discards the rewind buffer. This is done when the state of
+// the machine is replaced with one that does not follow from what is
+// in the buffer.
+func (m *Mach) resetRewind() {
+	m.rewinds.reset()
+	m.hits = m.hits[:0]
+}
+
+func (m *Mach) rewindCmd(frames int) {
+	rewound, err := m.rewind(frames)
+	if err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to rewind: %v", err))
+		return
+	}
+	m.EventCallback(InfoEvent, fmt.Sprintf("rewound %v frames", rewound))
+}
+
+// rewindHeld steps back one snapshot for each tick the rewind key is
+// held down.
+func (m *Mach) rewindHeld() {
+	if m.rewinds.len() <= 1 {
+		return
+	}
+	if _, err := m.rewind(m.RewindInterval); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to rewind: %v", err))
+		m.rewinding = false
+	}
+}
@@ -0,0 +1,64 @@
+package machine
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func testSnapshot(frame int, n int) []byte {
+	s := bytes.Repeat([]byte{0xaa}, n)
+	s[frame%n] = uint8(frame)
+	return s
+}
+
+func TestRewind(t *testing.T) {
+	b := newRewindBuffer(4)
+	for frame := 10; frame <= 30; frame += 10 {
+		if err := b.push(rewindEntry{frame: frame}, testSnapshot(frame, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, s, err := b.rewind(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(e.frame).ToBe(20)
+	With(t).Expect(s).ToBe(testSnapshot(20, 100))
+	With(t).Expect(b.len()).ToBe(2)
+}
+
+func TestRewindDifferentLengths(t *testing.T) {
+	b := newRewindBuffer(4)
+	b.push(rewindEntry{frame: 10}, testSnapshot(10, 100))
+	b.push(rewindEntry{frame: 20}, testSnapshot(20, 120))
+	b.push(rewindEntry{frame: 30}, testSnapshot(30, 90))
+	_, s, err := b.rewind(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(s).ToBe(testSnapshot(10, 100))
+}
+
+func TestRewindDropsOldest(t *testing.T) {
+	b := newRewindBuffer(3)
+	for frame := 10; frame <= 50; frame += 10 {
+		b.push(rewindEntry{frame: frame}, testSnapshot(frame, 100))
+	}
+	e, s, err := b.rewind(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(e.frame).ToBe(30)
+	With(t).Expect(s).ToBe(testSnapshot(30, 100))
+	With(t).Expect(b.len()).ToBe(1)
+}
+
+func TestRewindEmpty(t *testing.T) {
+	b := newRewindBuffer(3)
+	_, _, err := b.rewind(0)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,42 @@
+package machine
+
+// runUntil runs the machine until cond is true after an instruction
+// executes on core. Stopping for any other reason cancels it.
+func (m *Mach) runUntil(core int, cond func() bool) {
+	m.Cores[core].until = cond
+	m.setStatus(Run)
+}
+
+// runTo runs the machine until the program counter of core reaches addr.
+// If core is already at addr, it runs until it gets back there.
+func (m *Mach) runTo(core int, addr uint16) {
+	cpu := m.Cores[core].CPU
+	m.runUntil(core, func() bool { return cpu.PC() == addr })
+}
+
+// runCycles runs the machine until core has executed at least n cycles.
+func (m *Mach) runCycles(core int, n int) {
+	cpu := m.Cores[core].CPU
+	end := cpu.Cycles() + n
+	m.runUntil(core, func() bool { return cpu.Cycles() >= end })
+}
+
+// runFrames runs the machine for n frames. Each frame is a full tick with
+// interrupts and input handled as usual.
+func (m *Mach) runFrames(n int) {
+	if n <= 0 {
+		m.setStatus(Break)
+		return
+	}
+	m.stopFrames = n
+	m.setStatus(Run)
+}
+
+// cancelStops forgets about any place that the machine was asked to run
+// to.
+func (m *Mach) cancelStops() {
+	for i := range m.Cores {
+		m.Cores[i].until = nil
+	}
+	m.stopFrames = 0
+}
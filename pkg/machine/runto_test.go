@@ -0,0 +1,44 @@
+package machine
+
+import (
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestRunTo(t *testing.T) {
+	m := newCallMach()
+	m.runTo(0, 0x0020)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0020))
+}
+
+func TestRunCycles(t *testing.T) {
+	m := newCallMach()
+	// ld sp,nn takes 10 cycles and call nn takes 17
+	m.runCycles(0, 20)
+	m.RunHeadless(1, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.Cores[0].CPU.Cycles()).ToBe(27)
+	With(t).Expect(m.Cores[0].CPU.PC()).ToBe(uint16(0x0010))
+}
+
+func TestRunFrames(t *testing.T) {
+	m := newTestMach()
+	m.Status = Halt
+	m.runFrames(3)
+	m.RunHeadless(10, nil)
+	With(t).Expect(m.Status).ToBe(Break)
+	With(t).Expect(m.frame).ToBe(3)
+}
+
+func TestRunFramesStart(t *testing.T) {
+	m := newTestMach()
+	m.Status = Halt
+	m.runFrames(3)
+	m.command(Cmd{Type: StartCmd})
+	m.RunHeadless(10, nil)
+	With(t).Expect(m.Status).ToBe(Run)
+	With(t).Expect(m.frame).ToBe(10)
+}
@@ -0,0 +1,40 @@
+package machine
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/video"
+)
+
+// ScreenshotFileName returns a file name for a screenshot taken now.
+func ScreenshotFileName() string {
+	return fmt.Sprintf("screenshot-%v.png", time.Now().Format("20060102-150405.000"))
+}
+
+// screenshot writes the last frame rendered to a PNG file at path. If
+// path is empty, the file is written to the store directory.
+func (m *Mach) screenshot(path string) {
+	framer, ok := m.Display.(video.Framer)
+	if !ok {
+		m.EventCallback(ErrorEvent, "display does not support screenshots")
+		return
+	}
+	if path == "" {
+		path = filepath.Join(m.StoreDir, ScreenshotFileName())
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to create screenshot: %v", err))
+		return
+	}
+	defer out.Close()
+	if err := png.Encode(out, framer.Frame()); err != nil {
+		m.EventCallback(ErrorEvent, fmt.Sprintf("unable to save screenshot: %v", err))
+		return
+	}
+	m.EventCallback(InfoEvent, fmt.Sprintf("screenshot saved to %v", path))
+}
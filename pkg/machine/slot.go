@@ -0,0 +1,146 @@
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blackchip-org/pac8/pkg/util/state"
+	"github.com/blackchip-org/pac8/pkg/video"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Slots are snapshots in the store directory that are selected by name.
+// The function keys F1 to F8 save to slots 1 to 8 and the same keys with
+// shift load from those slots.
+var slotKeys = map[sdl.Keycode]string{
+	sdl.K_F1: "1",
+	sdl.K_F2: "2",
+	sdl.K_F3: "3",
+	sdl.K_F4: "4",
+	sdl.K_F5: "5",
+	sdl.K_F6: "6",
+	sdl.K_F7: "7",
+	sdl.K_F8: "8",
+}
+
+const (
+	slotPrefix = "slot-"
+	slotSuffix = ".state"
+)
+
+// SlotInfo is the metadata stored with a slot.
+type SlotInfo struct {
+	Name      string
+	Created   time.Time
+	Thumbnail []byte // PNG image, if available
+	Err       error  // set if the slot cannot be read
+}
+
+// SlotFileName returns the name of the file used for the slot with name.
+func SlotFileName(name string) string {
+	return slotPrefix + name + slotSuffix
+}
+
+// CheckSlotName returns an error if name cannot be used for a slot. The
+// name becomes part of a file name in the store directory so it cannot
+// have a path separator that would place the file somewhere else.
+func CheckSlotName(name string) error {
+	if name == "" || strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid slot name: %v", name)
+	}
+	return nil
+}
+
+// Slots returns the metadata of all slots found in dir sorted by name. A
+// slot that cannot be read is still returned with the reason in Err.
+func Slots(dir string) ([]SlotInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, SlotFileName("*")))
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]SlotInfo, 0, len(paths))
+	for _, path := range paths {
+		info, err := readSlotInfo(path)
+		if err != nil {
+			info = SlotInfo{Name: slotName(path), Err: err}
+		}
+		slots = append(slots, info)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].Name < slots[j].Name
+	})
+	return slots, nil
+}
+
+func readSlotInfo(path string) (SlotInfo, error) {
+	info := SlotInfo{}
+	in, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer in.Close()
+	r, err := state.NewReader(in)
+	if err != nil {
+		return info, err
+	}
+	info.Name = slotName(path)
+	info.Created = r.Header.Created
+	r.Section("thumbnail").Decode(&info.Thumbnail)
+	return info, nil
+}
+
+func slotName(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimPrefix(name, slotPrefix)
+	return strings.TrimSuffix(name, slotSuffix)
+}
+
+func (m *Mach) saveSlot(name string) {
+	m.save(filepath.Join(m.StoreDir, SlotFileName(name)))
+}
+
+func (m *Mach) loadSlot(name string) {
+	m.restore(filepath.Join(m.StoreDir, SlotFileName(name)))
+}
+
+// thumbnail returns a PNG image of the last frame rendered at half size.
+// Nil is returned if the display cannot provide frames.
+func (m *Mach) thumbnail() []byte {
+	framer, ok := m.Display.(video.Framer)
+	if !ok {
+		return nil
+	}
+	frame := framer.Frame()
+	b := frame.Bounds()
+	thumb := image.NewRGBA(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+	for y := 0; y < thumb.Rect.Dy(); y++ {
+		for x := 0; x < thumb.Rect.Dx(); x++ {
+			// Average each block of 2x2 pixels
+			var sum [4]int
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					i := frame.PixOffset(b.Min.X+x*2+dx, b.Min.Y+y*2+dy)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(frame.Pix[i+c])
+					}
+				}
+			}
+			i := thumb.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				thumb.Pix[i+c] = uint8(sum[c] / 4)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -65,3 +65,15 @@ func (p *Pack) Load(dir string) (Set, error) {
 	}
 	return roms, nil
 }
+
+// Checksums returns the expected checksum for each file in the pack by
+// path.
+func (p *Pack) Checksums() map[string]string {
+	sums := make(map[string]string)
+	for _, entries := range p.entries {
+		for _, entry := range entries {
+			sums[entry.path] = entry.checksum
+		}
+	}
+	return sums
+}
@@ -49,3 +49,15 @@ func TestPackConcat(t *testing.T) {
 	With(t).Expect(set["group1"].Load(1)).ToBe(42)
 	With(t).Expect(set["group1"].Load(3)).ToBe(44)
 }
+
+func TestPackChecksums(t *testing.T) {
+	p := NewPack().
+		Add("group1", "data1", "1234").
+		Add("group1", "data2", "5678").
+		Add("group2", "data3", "abcd")
+	With(t).Expect(p.Checksums()).ToBe(map[string]string{
+		"data1": "1234",
+		"data2": "5678",
+		"data3": "abcd",
+	})
+}
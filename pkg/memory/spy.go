@@ -2,6 +2,7 @@ package memory
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/blackchip-org/pac8/pkg/util/state"
 )
@@ -42,6 +43,8 @@ type Spy struct {
 	mem      Memory
 	reads    map[uint16]struct{}
 	writes   map[uint16]struct{}
+	hook     EventCallback
+	hooks    map[uint16]struct{} // writes that invoke the hook callback
 }
 
 func (s *Spy) Load(address uint16) uint8 {
@@ -57,6 +60,9 @@ func (s *Spy) Store(address uint16, value uint8) {
 	if _, exists := s.writes[address]; exists {
 		s.callback(Event{WriteEvent, address, value})
 	}
+	if _, exists := s.hooks[address]; exists {
+		s.hook(Event{WriteEvent, address, value})
+	}
 }
 
 func (s *Spy) Length() int {
@@ -101,6 +107,44 @@ func (s *Spy) UnwatchRW(address uint16) {
 	delete(s.writes, address)
 }
 
+// UnwatchAll removes all watches.
+func (s *Spy) UnwatchAll() {
+	s.reads = make(map[uint16]struct{})
+	s.writes = make(map[uint16]struct{})
+}
+
+// HookCallback sets the function that is called when a hooked address is
+// written. Hooks are kept apart from watches so that both can be used on
+// the same address.
+func (s *Spy) HookCallback(e EventCallback) {
+	s.hook = e
+}
+
+// HookW invokes the hook callback when address is written.
+func (s *Spy) HookW(address uint16) {
+	s.hooks[address] = struct{}{}
+}
+
+// UnhookAll removes all hooks.
+func (s *Spy) UnhookAll() {
+	s.hooks = make(map[uint16]struct{})
+}
+
+// Watched returns the sorted addresses that are watched for reads and
+// for writes.
+func (s *Spy) Watched() (reads []uint16, writes []uint16) {
+	return sortedAddrs(s.reads), sortedAddrs(s.writes)
+}
+
+func sortedAddrs(m map[uint16]struct{}) []uint16 {
+	addrs := make([]uint16, 0, len(m))
+	for addr := range m {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
 func (s Spy) Save(enc *state.Encoder) {
 	s.mem.Save(enc)
 }
@@ -116,5 +160,7 @@ func NewSpy(mem Memory) *Spy {
 		callback: func(e Event) {},
 		reads:    make(map[uint16]struct{}),
 		writes:   make(map[uint16]struct{}),
+		hook:     func(e Event) {},
+		hooks:    make(map[uint16]struct{}),
 	}
 }
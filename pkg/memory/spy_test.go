@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestSpy(t *testing.T) {
+	spy := NewSpy(NewRAM(0x10))
+	events := []Event{}
+	spy.Callback(func(e Event) { events = append(events, e) })
+	spy.WatchR(0x02)
+	spy.WatchW(0x03)
+	spy.Store(0x02, 0x22)
+	spy.Store(0x03, 0x33)
+	spy.Load(0x02)
+	spy.Load(0x03)
+	With(t).Expect(events).ToBe([]Event{
+		{WriteEvent, 0x03, 0x33},
+		{ReadEvent, 0x02, 0x22},
+	})
+}
+
+func TestSpyWatched(t *testing.T) {
+	spy := NewSpy(NewRAM(0x10))
+	spy.WatchRW(0x04)
+	spy.WatchR(0x02)
+	spy.WatchW(0x03)
+	reads, writes := spy.Watched()
+	With(t).Expect(reads).ToBe([]uint16{0x02, 0x04})
+	With(t).Expect(writes).ToBe([]uint16{0x03, 0x04})
+
+	spy.UnwatchAll()
+	reads, writes = spy.Watched()
+	With(t).Expect(len(reads) + len(writes)).ToBe(0)
+}
@@ -0,0 +1,97 @@
+package namco
+
+import (
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+// Screen size of the starfield before it is rotated
+const (
+	starsW = 288
+	starsH = 224
+)
+
+const (
+	lfsrSeed   = 0x7fff
+	lfsrPeriod = 0xffff
+	lfsrMask   = 0xfa14 // bits checked for a star
+	lfsrHit    = 0x7800 // value of the checked bits when there is a star
+)
+
+// Pixels scrolled per frame for each value of the speed bits
+var starSpeeds = []int{-1, -2, -3, 0, 3, 2, 1, 0}
+
+// N05XX is the starfield generator. A 16-bit linear feedback shift
+// register advances once for each pixel and a star is drawn when its bits
+// match a pattern. The same stars appear in the same places each frame
+// and the starfield scrolls by changing where the register starts.
+//
+// Stars belong to one of four sets and two sets are shown at a time. The
+// game switches between sets to make the stars twinkle.
+//
+// The low bit of each control register is used:
+//
+//	0-2: scroll speed
+//	3:   first set to show, 0 or 1
+//	4:   second set to show, 2 or 3
+//	5:   stars are shown when set
+type N05XX struct {
+	Control [6]uint8
+	scroll  int
+}
+
+func NewN05XX() *N05XX {
+	return &N05XX{}
+}
+
+// Scroll moves the starfield by the speed in the control registers. It is
+// called once each frame.
+func (n *N05XX) Scroll() {
+	speed := n.Control[0]&1 | n.Control[1]&1<<1 | n.Control[2]&1<<2
+	n.scroll = (n.scroll + starSpeeds[speed] + lfsrPeriod) % lfsrPeriod
+}
+
+// Draw calls plot for each star that is shown with a position on the
+// unrotated screen and a color from 0 to 63.
+func (n *N05XX) Draw(plot func(x int, y int, color uint8)) {
+	if n.Control[5]&1 == 0 {
+		return
+	}
+	setA := n.Control[3] & 1
+	setB := n.Control[4]&1 | 2
+
+	lfsr := uint16(lfsrSeed)
+	for i := 0; i < (lfsrPeriod-n.scroll)%lfsrPeriod; i++ {
+		lfsr = nextLFSR(lfsr)
+	}
+	for y := 0; y < starsH; y++ {
+		for x := 0; x < starsW; x++ {
+			if lfsr&lfsrMask == lfsrHit {
+				set := uint8(lfsr>>9&2 | lfsr>>8&1)
+				if set == setA || set == setB {
+					plot(x, y, starColor(lfsr))
+				}
+			}
+			lfsr = nextLFSR(lfsr)
+		}
+	}
+}
+
+func nextLFSR(lfsr uint16) uint16 {
+	bit := (lfsr ^ lfsr>>2 ^ lfsr>>3 ^ lfsr>>5) & 1
+	return lfsr>>1 | bit<<15
+}
+
+func starColor(lfsr uint16) uint8 {
+	c := uint8(lfsr>>5&0x07 | lfsr<<3&0x18 | lfsr<<2&0x20)
+	return ^c & 0x3f
+}
+
+func (n *N05XX) Save(enc *state.Encoder) {
+	enc.Encode(n.Control)
+	enc.Encode(n.scroll)
+}
+
+func (n *N05XX) Restore(dec *state.Decoder) {
+	dec.Decode(&n.Control)
+	dec.Decode(&n.scroll)
+}
@@ -0,0 +1,61 @@
+package namco
+
+import (
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+type testStar struct {
+	x, y int
+}
+
+func testStars(n *N05XX) []testStar {
+	stars := []testStar{}
+	n.Draw(func(x int, y int, c uint8) {
+		stars = append(stars, testStar{x, y})
+	})
+	return stars
+}
+
+func TestLFSRPeriod(t *testing.T) {
+	lfsr := nextLFSR(lfsrSeed)
+	n := 1
+	for ; lfsr != lfsrSeed; n++ {
+		lfsr = nextLFSR(lfsr)
+	}
+	With(t).Expect(n).ToBe(lfsrPeriod)
+}
+
+func TestStarsOff(t *testing.T) {
+	n := NewN05XX()
+	With(t).Expect(len(testStars(n))).ToBe(0)
+}
+
+func TestStarsSets(t *testing.T) {
+	n := NewN05XX()
+	n.Control[5] = 1
+	sets01 := testStars(n)
+	n.Control[3], n.Control[4] = 1, 1
+	sets13 := testStars(n)
+	if len(sets01) == 0 || len(sets13) == 0 {
+		t.Fatal("expected stars")
+	}
+	With(t).Expect(sets01).NotToBe(sets13)
+}
+
+func TestStarsScroll(t *testing.T) {
+	n := NewN05XX()
+	n.Control[5] = 1
+	before := testStars(n)
+	n.Control[2] = 1 // speed of 3
+	n.Scroll()
+	after := testStars(n)
+	found := false
+	for _, star := range after {
+		if star == (testStar{before[0].x + 3, before[0].y}) {
+			found = true
+		}
+	}
+	With(t).Expect(found).ToBe(true)
+}
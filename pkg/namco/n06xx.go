@@ -0,0 +1,119 @@
+package namco
+
+import (
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+// The 06xx is clocked at 1/64th of the speed of the main CPU.
+const n06xxCycles = 64
+
+// Chip is a custom chip connected to the 06xx.
+type Chip interface {
+	Select(read bool) // chip is selected for a transfer
+	Read() uint8
+	Write(uint8)
+}
+
+// N06XX is the bus controller between the main CPU and up to four custom
+// chips. It is mapped as two pages of memory: the first is the data
+// register and the second is the control register.
+//
+// Writing to the control register selects chips with the low four bits,
+// picks a read or a write with bit 4, and sets how often to signal the
+// CPU with the upper three bits. Each time the CPU is signalled with an
+// NMI, it moves one byte through the data register. If the upper three
+// bits are zero, the signals stop.
+type N06XX struct {
+	Chips   [4]Chip
+	NMI     func() // signals an NMI to the main CPU
+	control uint8
+	period  int // cycles between each NMI, zero if stopped
+	wait    int // cycles until the next NMI
+}
+
+// NewN06XX creates a bus controller that calls nmi to signal the main
+// CPU.
+func NewN06XX(nmi func()) *N06XX {
+	return &N06XX{NMI: nmi}
+}
+
+// Clock advances the controller by the number of cycles executed by the
+// main CPU.
+func (n *N06XX) Clock(cycles int) {
+	if n.period == 0 {
+		return
+	}
+	n.wait -= cycles
+	if n.wait <= 0 {
+		n.wait += n.period
+		if n.wait <= 0 {
+			n.wait = n.period
+		}
+		n.NMI()
+	}
+}
+
+func (n *N06XX) Load(addr uint16) uint8 {
+	if addr >= 0x100 {
+		return n.control
+	}
+	// Nothing can be read while writing
+	if n.control&0x10 == 0 {
+		return 0xff
+	}
+	v := uint8(0xff)
+	for i, chip := range n.Chips {
+		if chip != nil && n.control&(1<<uint(i)) != 0 {
+			v &= chip.Read()
+		}
+	}
+	return v
+}
+
+func (n *N06XX) Store(addr uint16, v uint8) {
+	if addr >= 0x100 {
+		n.setControl(v)
+		return
+	}
+	// Nothing can be written while reading
+	if n.control&0x10 != 0 {
+		return
+	}
+	for i, chip := range n.Chips {
+		if chip != nil && n.control&(1<<uint(i)) != 0 {
+			chip.Write(v)
+		}
+	}
+}
+
+func (n *N06XX) setControl(v uint8) {
+	n.control = v
+	divider := v >> 5
+	if divider == 0 {
+		n.period = 0
+		return
+	}
+	n.period = n06xxCycles << divider
+	n.wait = n.period
+	for i, chip := range n.Chips {
+		if chip != nil && v&(1<<uint(i)) != 0 {
+			chip.Select(v&0x10 != 0)
+		}
+	}
+}
+
+func (n *N06XX) Length() int {
+	return 0x200
+}
+
+func (n *N06XX) Save(enc *state.Encoder) {
+	enc.Encode(n.control)
+	enc.Encode(n.period)
+	enc.Encode(n.wait)
+}
+
+func (n *N06XX) Restore(dec *state.Decoder) {
+	dec.Decode(&n.control)
+	dec.Decode(&n.period)
+	dec.Decode(&n.wait)
+}
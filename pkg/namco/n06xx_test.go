@@ -0,0 +1,70 @@
+package namco
+
+import (
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+type testChip struct {
+	selected []bool
+	written  []uint8
+	value    uint8
+}
+
+func (c *testChip) Select(read bool) { c.selected = append(c.selected, read) }
+func (c *testChip) Read() uint8      { return c.value }
+func (c *testChip) Write(v uint8)    { c.written = append(c.written, v) }
+
+func TestN06XXNMI(t *testing.T) {
+	nmis := 0
+	n := NewN06XX(func() { nmis++ })
+	n.Store(0x100, 0x21) // period of 128 cycles
+	n.Clock(100)
+	With(t).Expect(nmis).ToBe(0)
+	n.Clock(30)
+	With(t).Expect(nmis).ToBe(1)
+	n.Clock(128)
+	With(t).Expect(nmis).ToBe(2)
+	n.Store(0x100, 0x01)
+	n.Clock(1000)
+	With(t).Expect(nmis).ToBe(2)
+}
+
+func TestN06XXControl(t *testing.T) {
+	n := NewN06XX(func() {})
+	n.Store(0x100, 0x71)
+	WithFormat(t, "%02x").Expect(n.Load(0x100)).ToBe(uint8(0x71))
+}
+
+func TestN06XXRead(t *testing.T) {
+	n := NewN06XX(func() {})
+	c0 := &testChip{value: 0x12}
+	c1 := &testChip{value: 0x34}
+	n.Chips[0], n.Chips[1] = c0, c1
+	n.Store(0x100, 0x71)
+	WithFormat(t, "%02x").Expect(n.Load(0)).ToBe(uint8(0x12))
+	With(t).Expect(c0.selected).ToBe([]bool{true})
+	With(t).Expect(len(c1.selected)).ToBe(0)
+}
+
+func TestN06XXWrite(t *testing.T) {
+	n := NewN06XX(func() {})
+	c0 := &testChip{}
+	c1 := &testChip{}
+	n.Chips[0], n.Chips[1] = c0, c1
+	n.Store(0x100, 0x62)
+	n.Store(0, 0xab)
+	With(t).Expect(len(c0.written)).ToBe(0)
+	With(t).Expect(c1.written).ToBe([]uint8{0xab})
+	With(t).Expect(c1.selected).ToBe([]bool{false})
+}
+
+func TestN06XXNoWriteWhileReading(t *testing.T) {
+	n := NewN06XX(func() {})
+	c0 := &testChip{}
+	n.Chips[0] = c0
+	n.Store(0x100, 0x71)
+	n.Store(0, 0xab)
+	With(t).Expect(len(c0.written)).ToBe(0)
+}
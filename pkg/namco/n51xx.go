@@ -0,0 +1,211 @@
+package namco
+
+import (
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+// Modes of the 51xx
+const (
+	n51xxSwitch  = iota // report the inputs as they are
+	n51xxCredit         // count credits and take start buttons
+	n51xxPlaying        // count credits but ignore start buttons
+)
+
+// Bits of the first two input ports when active
+const (
+	in51Button1 = 1 << iota
+	in51Button2
+	in51Start1
+	in51Start2
+	in51Coin1
+	in51Coin2
+	in51Service
+	in51Test
+)
+
+// Joystick directions are reordered when remapping is enabled.
+var n51xxJoyMap = [16]uint8{
+	0xf, 0xe, 0xd, 0x5, 0xc, 0x9, 0x7, 0x6,
+	0xb, 0x3, 0xa, 0x4, 0x1, 0x2, 0x0, 0x8,
+}
+
+// N51XX is the custom chip that reads the coin slots, start buttons, and
+// joysticks. It keeps track of credits so the game does not have to. This
+// emulates the commands of the chip and not its program.
+//
+// The chip has four input ports of four bits each that are active low.
+// Ports 0 and 1 are the buttons, start buttons, coins, and switches. Ports
+// 2 and 3 are the joysticks for player 1 and 2 with up, right, down, and
+// left from the lowest bit to the highest.
+//
+// After it is selected for reading, the chip returns three bytes in turn:
+// the number of credits in BCD and then the joystick and fire button of
+// each player. In switch mode, it returns the input ports as they are.
+type N51XX struct {
+	ReadPort     func(n int) uint8 // value of input port n
+	mode         int
+	coinage      int // number of coinage values still to be written
+	coinsPerCred [2]uint8
+	credsPerCoin [2]uint8
+	coins        [2]uint8
+	credits      int
+	lastCoins    uint8 // inputs of ports 0 and 1 on the last read
+	lastButtons  uint8 // fire buttons on the last read
+	remapJoy     bool
+	count        int // bytes read since selected
+}
+
+// NewN51XX creates a 51xx that reads its input ports with readPort.
+func NewN51XX(readPort func(n int) uint8) *N51XX {
+	return &N51XX{ReadPort: readPort}
+}
+
+func (n *N51XX) port(i int) uint8 {
+	return n.ReadPort(i) & 0x0f
+}
+
+func (n *N51XX) Select(read bool) {
+	n.count = 0
+}
+
+// Write handles a command from the CPU. Only the low three bits are used.
+func (n *N51XX) Write(v uint8) {
+	v &= 0x07
+	if n.coinage > 0 {
+		switch n.coinage {
+		case 4:
+			n.coinsPerCred[0] = v
+		case 3:
+			n.credsPerCoin[0] = v
+		case 2:
+			n.coinsPerCred[1] = v
+		case 1:
+			n.credsPerCoin[1] = v
+		}
+		n.coinage--
+		return
+	}
+	switch v {
+	case 1: // set coinage with the next four writes
+		n.coinage = 4
+		n.credits = 0
+	case 2: // count credits
+		n.mode = n51xxCredit
+		n.count = 0
+	case 3:
+		n.remapJoy = false
+	case 4:
+		n.remapJoy = true
+	case 5: // report switches
+		n.mode = n51xxSwitch
+		n.count = 0
+	}
+}
+
+func (n *N51XX) Read() uint8 {
+	i := n.count % 3
+	n.count++
+	if n.mode == n51xxSwitch {
+		switch i {
+		case 0:
+			return n.port(0) | n.port(1)<<4
+		case 1:
+			return n.port(2) | n.port(3)<<4
+		}
+		return 0
+	}
+	switch i {
+	case 0:
+		return n.readCredits()
+	case 1:
+		return n.readJoystick(0)
+	}
+	return n.readJoystick(1)
+}
+
+func (n *N51XX) readCredits() uint8 {
+	in := ^(n.port(0) | n.port(1)<<4)
+	pressed := (in ^ n.lastCoins) & in
+	n.lastCoins = in
+
+	if n.coinsPerCred[0] == 0 {
+		n.credits = 100 // free play
+	} else if n.credits < 99 {
+		for slot, bit := range []uint8{in51Coin1, in51Coin2} {
+			if pressed&bit == 0 {
+				continue
+			}
+			n.coins[slot]++
+			if n.coins[slot] >= n.coinsPerCred[slot] {
+				n.credits += int(n.credsPerCoin[slot])
+				n.coins[slot] -= n.coinsPerCred[slot]
+			}
+		}
+		if pressed&in51Service != 0 {
+			n.credits++
+		}
+	}
+
+	if n.mode == n51xxCredit {
+		if pressed&in51Start1 != 0 && n.credits >= 1 {
+			n.credits--
+			n.mode = n51xxPlaying
+		} else if pressed&in51Start2 != 0 && n.credits >= 2 {
+			n.credits -= 2
+			n.mode = n51xxPlaying
+		}
+	}
+
+	if in&in51Test != 0 {
+		return 0xbb
+	}
+	return uint8(n.credits/10*16 + n.credits%10)
+}
+
+// readJoystick returns the direction of the joystick in the low four bits.
+// Bit 4 is clear when the fire button was just pressed and bit 5 is clear
+// while it is held down.
+func (n *N51XX) readJoystick(player uint) uint8 {
+	joy := n.port(2 + int(player))
+	button := uint8(in51Button1) << player
+	in := ^n.port(0) & button
+	pressed := (in ^ n.lastButtons&button) & in
+	n.lastButtons = n.lastButtons&^button | in
+
+	if n.remapJoy {
+		joy = n51xxJoyMap[joy]
+	}
+	if pressed == 0 {
+		joy |= 1 << 4
+	}
+	if in == 0 {
+		joy |= 1 << 5
+	}
+	return joy
+}
+
+func (n *N51XX) Save(enc *state.Encoder) {
+	enc.Encode(n.mode)
+	enc.Encode(n.coinage)
+	enc.Encode(n.coinsPerCred)
+	enc.Encode(n.credsPerCoin)
+	enc.Encode(n.coins)
+	enc.Encode(n.credits)
+	enc.Encode(n.lastCoins)
+	enc.Encode(n.lastButtons)
+	enc.Encode(n.remapJoy)
+	enc.Encode(n.count)
+}
+
+func (n *N51XX) Restore(dec *state.Decoder) {
+	dec.Decode(&n.mode)
+	dec.Decode(&n.coinage)
+	dec.Decode(&n.coinsPerCred)
+	dec.Decode(&n.credsPerCoin)
+	dec.Decode(&n.coins)
+	dec.Decode(&n.credits)
+	dec.Decode(&n.lastCoins)
+	dec.Decode(&n.lastButtons)
+	dec.Decode(&n.remapJoy)
+	dec.Decode(&n.count)
+}
@@ -0,0 +1,95 @@
+package namco
+
+import (
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+type testPorts [4]uint8
+
+func newTestN51XX() (*N51XX, *testPorts) {
+	ports := &testPorts{0xf, 0xf, 0xf, 0xf}
+	n := NewN51XX(func(i int) uint8 { return ports[i] })
+	return n, ports
+}
+
+// setCoinage sets one coin for one credit in both slots
+func setCoinage(n *N51XX) {
+	for _, v := range []uint8{1, 1, 1, 1, 1} {
+		n.Write(v)
+	}
+	n.Write(2)
+}
+
+func TestN51XXSwitch(t *testing.T) {
+	n, ports := newTestN51XX()
+	ports[0], ports[1], ports[2], ports[3] = 0x1, 0x2, 0x3, 0x4
+	n.Write(5)
+	n.Select(true)
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x21))
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x43))
+}
+
+func TestN51XXCoin(t *testing.T) {
+	n, ports := newTestN51XX()
+	setCoinage(n)
+	for i := 0; i < 12; i++ {
+		ports[1] = 0xf &^ (in51Coin1 >> 4)
+		n.Select(true)
+		n.Read()
+		ports[1] = 0xf
+		n.Select(true)
+		n.Read()
+	}
+	n.Select(true)
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x12))
+}
+
+func TestN51XXStart(t *testing.T) {
+	n, ports := newTestN51XX()
+	setCoinage(n)
+	ports[1] = 0xf &^ (in51Coin1 >> 4)
+	n.Select(true)
+	n.Read()
+	ports[1] = 0xf
+	ports[0] = 0xf &^ in51Start1
+	n.Select(true)
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x00))
+	With(t).Expect(n.mode).ToBe(n51xxPlaying)
+}
+
+func TestN51XXStartNoCredits(t *testing.T) {
+	n, ports := newTestN51XX()
+	setCoinage(n)
+	ports[0] = 0xf &^ in51Start1
+	n.Select(true)
+	n.Read()
+	With(t).Expect(n.mode).ToBe(n51xxCredit)
+}
+
+func TestN51XXFreePlay(t *testing.T) {
+	n, _ := newTestN51XX()
+	for _, v := range []uint8{1, 0, 0, 0, 0, 2} {
+		n.Write(v)
+	}
+	n.Select(true)
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0xa0))
+}
+
+func TestN51XXFire(t *testing.T) {
+	n, ports := newTestN51XX()
+	setCoinage(n)
+	ports[0] = 0xf &^ in51Button1
+	ports[2] = 0xe // up
+	n.Select(true)
+	n.Read()
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x0e))
+	n.Read()
+	n.Read()
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x1e))
+	ports[0] = 0xf
+	n.Read()
+	n.Read()
+	WithFormat(t, "%02x").Expect(n.Read()).ToBe(uint8(0x3e))
+}
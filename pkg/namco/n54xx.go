@@ -0,0 +1,116 @@
+package namco
+
+import (
+	"github.com/blackchip-org/pac8/pkg/audio"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+// Number of bytes that follow a command to configure a sound
+var n54xxConfigLen = map[uint8]int{
+	0x30: 4,
+	0x40: 4,
+	0x60: 5,
+}
+
+// Sound started by a command
+var n54xxSounds = map[uint8]int{
+	0x10: 0,
+	0x20: 1,
+	0x50: 2,
+}
+
+// For each sound: the frequency of the noise, which is lower for more of
+// a rumble, and how much of the volume is kept after each frame.
+var (
+	n54xxFreqs = [3]int{48, 12, 24}
+	n54xxFades = [3]float64{0.80, 0.93, 0.88}
+)
+
+// Noise played by the 54xx
+var n54xxNoise = func() []float64 {
+	noise := make([]float64, 512, 512)
+	lfsr := uint16(lfsrSeed)
+	for i := range noise {
+		noise[i] = float64(lfsr&1)*2 - 1
+		lfsr = nextLFSR(lfsr)
+	}
+	return noise
+}()
+
+// N54XX is the custom chip that makes the noise and explosion sounds. It
+// is a microcontroller that sends noise through analog filters. This
+// emulates its commands and not its program or the filters: each sound is
+// noise with a volume that fades each frame.
+//
+// Commands 0x1x, 0x2x, and 0x5x start sounds 0, 1, and 2. Commands 0x3x,
+// 0x4x, and 0x6x are followed by bytes that configure the filters, which
+// are ignored. Command 0x7x sets the volume of sound 2 to the low four
+// bits.
+type N54XX struct {
+	Levels  [3]float64 // volume of each sound
+	config  int        // configuration bytes still to be written
+	volume2 float64
+}
+
+func NewN54XX() *N54XX {
+	return &N54XX{volume2: 1}
+}
+
+func (n *N54XX) Select(read bool) {}
+
+// Read returns nothing since the 54xx only takes commands.
+func (n *N54XX) Read() uint8 {
+	return 0xff
+}
+
+func (n *N54XX) Write(v uint8) {
+	if n.config > 0 {
+		n.config--
+		return
+	}
+	cmd := v & 0xf0
+	if sound, ok := n54xxSounds[cmd]; ok {
+		n.Levels[sound] = 1
+		if sound == 2 {
+			n.Levels[sound] = n.volume2
+		}
+		return
+	}
+	if cmd == 0x70 {
+		n.volume2 = float64(v&0x0f) / 15
+		return
+	}
+	n.config = n54xxConfigLen[cmd]
+}
+
+// Update sets voice to play the loudest sound and then fades each sound.
+// It is called once each frame.
+func (n *N54XX) Update(voice *audio.Voice) {
+	loudest := 0
+	for i, level := range n.Levels {
+		if level > n.Levels[loudest] {
+			loudest = i
+		}
+	}
+	voice.Waveform = n54xxNoise
+	voice.Freq = n54xxFreqs[loudest]
+	voice.Vol = n.Levels[loudest]
+	for i := range n.Levels {
+		n.Levels[i] *= n54xxFades[i]
+		if n.Levels[i] < 0.01 {
+			n.Levels[i] = 0
+		}
+	}
+}
+
+func (n *N54XX) Save(enc *state.Encoder) {
+	enc.Encode(n.Levels)
+	enc.Encode(n.config)
+	enc.Encode(n.volume2)
+}
+
+func (n *N54XX) Restore(dec *state.Decoder) {
+	dec.Decode(&n.Levels)
+	dec.Decode(&n.config)
+	dec.Decode(&n.volume2)
+}
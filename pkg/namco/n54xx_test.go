@@ -0,0 +1,57 @@
+package namco
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/audio"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestN54XXStart(t *testing.T) {
+	n := NewN54XX()
+	n.Write(0x20)
+	With(t).Expect(n.Levels).ToBe([3]float64{0, 1, 0})
+}
+
+func TestN54XXConfig(t *testing.T) {
+	n := NewN54XX()
+	// The bytes after the command are not commands
+	for _, v := range []uint8{0x30, 0x10, 0x20, 0x50, 0x10} {
+		n.Write(v)
+	}
+	With(t).Expect(n.Levels).ToBe([3]float64{0, 0, 0})
+	n.Write(0x10)
+	With(t).Expect(n.Levels).ToBe([3]float64{1, 0, 0})
+}
+
+func TestN54XXVolume(t *testing.T) {
+	n := NewN54XX()
+	n.Write(0x75)
+	n.Write(0x50)
+	WithFormat(t, "%.2f").Expect(n.Levels[2]).ToBe(float64(5) / 15)
+}
+
+func TestN54XXUpdate(t *testing.T) {
+	n := NewN54XX()
+	v := audio.NewVoice(22050)
+	n.Write(0x10)
+	n.Write(0x20)
+	n.Update(v)
+	With(t).Expect(v.Freq).ToBe(n54xxFreqs[0])
+	With(t).Expect(v.Vol).ToBe(1.0)
+
+	// The explosion fades slower
+	n.Update(v)
+	With(t).Expect(v.Freq).ToBe(n54xxFreqs[1])
+	With(t).Expect(v.Vol).ToBe(n54xxFades[1])
+}
+
+func TestN54XXSilence(t *testing.T) {
+	n := NewN54XX()
+	v := audio.NewVoice(22050)
+	n.Write(0x10)
+	for i := 0; i < 100; i++ {
+		n.Update(v)
+	}
+	With(t).Expect(v.Vol).ToBe(0.0)
+}
@@ -2,6 +2,8 @@ package namco
 
 import (
 	"fmt"
+	"image"
+	"image/draw"
 
 	"github.com/blackchip-org/pac8/pkg/memory"
 	"github.com/blackchip-org/pac8/pkg/util/bits"
@@ -19,6 +21,17 @@ type SpriteCoord struct {
 	Y uint8
 }
 
+// Sprite is a cell of the sprite sheet placed on the screen with its top
+// left corner at X, Y.
+type Sprite struct {
+	N     int
+	X     int
+	Y     int
+	FlipX bool
+	FlipY bool
+	Pal   uint8
+}
+
 var ViewerPalette = video.Palette{
 	[]uint8{0, 0, 0, 0},
 	[]uint8{128, 128, 128, 255},
@@ -36,14 +49,15 @@ type SheetLayout struct {
 	PixelReader  func(memory.Memory, uint16, int) uint8
 }
 
-func NewSheet(r *sdl.Renderer, mem memory.Memory, l SheetLayout, pal video.Palette) (video.Sheet, error) {
-	t, err := r.CreateTexture(sdl.PIXELFORMAT_RGBA8888,
-		sdl.TEXTUREACCESS_TARGET, int32(l.W), int32(l.H))
-	if err != nil {
-		return video.Sheet{}, fmt.Errorf("unable to create sheet: %v", err)
-	}
-	r.SetRenderTarget(t)
+// PixelSheet is a sheet of cells decoded into the palette index of each
+// pixel.
+type PixelSheet struct {
+	Layout SheetLayout
+	Pix    []uint8
+}
 
+func NewPixelSheet(mem memory.Memory, l SheetLayout) PixelSheet {
+	pix := make([]uint8, l.W*l.H, l.W*l.H)
 	rowTiles := l.W / l.CellW
 	for i := 0; i < l.W*l.H; i++ {
 		targetX := i % l.W
@@ -55,10 +69,36 @@ func NewSheet(r *sdl.Renderer, mem memory.Memory, l SheetLayout, pal video.Palet
 		cellN := cellX + cellY*rowTiles
 		baseAddr := uint16(cellN * l.BytesPerCell)
 		pixelN := l.PixelLayout[offsetY][offsetX]
-		value := l.PixelReader(mem, baseAddr, pixelN)
+		pix[i] = l.PixelReader(mem, baseAddr, pixelN)
+	}
+	return PixelSheet{Layout: l, Pix: pix}
+}
+
+// At returns the palette index of the pixel at x, y within cell n. Cells
+// that are not in the sheet only have pixels with an index of zero.
+func (p PixelSheet) At(n int, x int, y int) uint8 {
+	l := p.Layout
+	rowCells := l.W / l.CellW
+	sheetX := (n%rowCells)*l.CellW + x
+	sheetY := (n/rowCells)*l.CellH + y
+	if sheetY >= l.H {
+		return 0
+	}
+	return p.Pix[sheetY*l.W+sheetX]
+}
+
+func NewSheet(r *sdl.Renderer, mem memory.Memory, l SheetLayout, pal video.Palette) (video.Sheet, error) {
+	t, err := r.CreateTexture(sdl.PIXELFORMAT_RGBA8888,
+		sdl.TEXTUREACCESS_TARGET, int32(l.W), int32(l.H))
+	if err != nil {
+		return video.Sheet{}, fmt.Errorf("unable to create sheet: %v", err)
+	}
+	r.SetRenderTarget(t)
 
+	sheet := NewPixelSheet(mem, l)
+	for i, value := range sheet.Pix {
 		r.SetDrawColorArray(pal[value]...)
-		r.DrawPoint(int32(targetX), int32(targetY))
+		r.DrawPoint(int32(i%l.W), int32(i/l.W))
 	}
 	t.SetBlendMode(sdl.BLENDMODE_BLEND)
 	r.SetRenderTarget(nil)
@@ -73,36 +113,59 @@ func NewSheet(r *sdl.Renderer, mem memory.Memory, l SheetLayout, pal video.Palet
 }
 
 type Video struct {
-	Callback     func()
-	SpriteCoords [8]SpriteCoord
-	r            *sdl.Renderer
-	mem          memory.Memory
-	config       Config
-	tiles        [64]video.Sheet
-	sprites      [64]video.Sheet
-	colors       []video.Color
-	palettes     []video.Palette
-	frame        video.RenderFrame
-	frameFill    sdl.Rect
-	scanLines    *sdl.Texture
+	Callback       func()
+	SpriteCoords   [8]SpriteCoord
+	Stars          *N05XX // starfield drawn behind the tiles, if any
+	r              *sdl.Renderer
+	mem            memory.Memory
+	config         Config
+	tiles          PixelSheet
+	sprites        PixelSheet
+	colors         []video.Color
+	palettes       []video.Palette
+	spritePalettes []video.Palette
+	img            *image.RGBA
+	texture        *sdl.Texture
+	frame          video.RenderFrame
+	frameFill      sdl.Rect
+	scanLines      *sdl.Texture
+	overlay        func(*image.RGBA)
 }
 
 type Config struct {
 	TileLayout     SheetLayout
 	SpriteLayout   SheetLayout
 	VideoAddr      uint16
+	Colors         int // number of colors in the color ROM
 	PaletteEntries int
 	PaletteColors  int
-	Hack           bool
+	PaletteMask    uint8 // bits of color RAM that select a tile palette
+	TileColors     int   // first color used by the tile palettes
+	Transparent    uint8 // palette ROM value of pixels that are not drawn
+
+	// SpritePalettes is set when the sprites have their own palette ROM.
+	SpritePalettes bool
+
+	// ReadSprites returns the sprites to draw, in order, from memory. If
+	// nil, the sprites are read like those of Pac-Man.
+	ReadSprites func(mem memory.Memory) []Sprite
 }
 
 func NewVideo(r *sdl.Renderer, mem memory.Memory, rom memory.Set, config Config) (*Video, error) {
 	v := &Video{
-		r:        r,
-		mem:      mem,
-		config:   config,
-		palettes: make([]video.Palette, config.PaletteEntries, config.PaletteEntries),
+		r:      r,
+		mem:    mem,
+		config: config,
+		img:    image.NewRGBA(image.Rect(0, 0, int(w), int(h))),
+	}
+	v.colors = ColorTable(rom["color"], v.config)
+	v.palettes = PaletteTable(rom["palette"], v.config, v.colors[config.TileColors:])
+	v.spritePalettes = v.palettes
+	if config.SpritePalettes {
+		v.spritePalettes = PaletteTable(rom["sprite-palette"], v.config, v.colors)
 	}
+	v.tiles = NewPixelSheet(rom["tile"], config.TileLayout)
+	v.sprites = NewPixelSheet(rom["sprite"], config.SpriteLayout)
 	if r == nil {
 		return v, nil
 	}
@@ -122,45 +185,57 @@ func NewVideo(r *sdl.Renderer, mem memory.Memory, rom memory.Set, config Config)
 	if err != nil {
 		return nil, err
 	}
-	v.colors = ColorTable(rom["color"], v.config)
-	v.palettes = PaletteTable(rom["palette"], v.config, v.colors)
-
-	for pal := 0; pal < v.config.PaletteEntries; pal++ {
-		tiles, err := NewSheet(r, rom["tile"], config.TileLayout, v.palettes[pal])
-		if err != nil {
-			return nil, err
-		}
-		v.tiles[pal] = tiles
-
-		sprites, err := NewSheet(r, rom["sprite"], config.SpriteLayout, v.palettes[pal])
-		if err != nil {
-			return nil, err
-		}
-		v.sprites[pal] = sprites
+	// image.RGBA stores bytes in R, G, B, A order which is ABGR8888 when
+	// read as a little endian word.
+	v.texture, err = r.CreateTexture(sdl.PIXELFORMAT_ABGR8888,
+		sdl.TEXTUREACCESS_STREAMING, w, h)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create frame texture: %v", err)
 	}
-
 	return v, nil
 }
 
 func (v *Video) Render() {
 	//v.Callback()
+	v.Draw()
 	if v.r == nil {
 		return
 	}
+	v.texture.Update(nil, v.img.Pix, v.img.Stride)
 	v.r.SetDrawColorArray(0, 0, 0, 0xff)
 	v.r.FillRect(&v.frameFill)
-	v.renderTiles()
-	v.renderSprites()
+	v.r.Copy(v.texture, nil, &v.frameFill)
 	v.r.Copy(v.scanLines, nil, nil)
 	v.r.Present()
 }
 
-func (v *Video) renderTiles() {
+// Draw composes the stars, tiles, and sprites from video memory into the
+// frame image without using SDL. The overlay, if any, is drawn last.
+func (v *Video) Draw() {
+	draw.Draw(v.img, v.img.Bounds(), image.Black, image.ZP, draw.Src)
+	if v.Stars != nil {
+		v.drawStars()
+	}
+	v.drawTiles()
+	v.drawSprites()
+	if v.overlay != nil {
+		v.overlay(v.img)
+	}
+}
+
+func (v *Video) SetOverlay(draw func(*image.RGBA)) {
+	v.overlay = draw
+}
+
+// Frame returns the image of the last frame drawn at the native resolution
+// of 224x288.
+func (v *Video) Frame() *image.RGBA {
+	return v.img
+}
+
+func (v *Video) drawTiles() {
 	layout := v.config.TileLayout
-	cellW := layout.CellW
-	rowCells := layout.W / cellW
 
-	// Render tiles
 	for ty := uint16(0); ty < 36; ty++ {
 		for tx := uint16(0); tx < 28; tx++ {
 			var addr uint16
@@ -173,87 +248,98 @@ func (v *Video) renderTiles() {
 			}
 
 			tileN := int(v.mem.Load(addr))
-			sheetX := (tileN % rowCells) * cellW
-			sheetY := (tileN / rowCells) * cellW
-			src := sdl.Rect{
-				X: int32(sheetX),
-				Y: int32(sheetY),
-				W: int32(layout.CellW),
-				H: int32(layout.CellH),
-			}
-			screenX := int32(tx) * 8 * v.frame.Scale
-			screenY := int32(ty) * 8 * v.frame.Scale
-			dest := sdl.Rect{
-				X: screenX + v.frame.X,
-				Y: screenY + v.frame.Y,
-				W: int32(layout.CellW) * v.frame.Scale,
-				H: int32(layout.CellH) * v.frame.Scale,
-			}
-
 			caddr := addr + 0x0400
-			// Only 64 palettes, strip out the higher bits
-			// pal := v.mem.Load(caddr) & 0x3f
-			pal := v.mem.Load(caddr) & 0x1f
-			v.r.Copy(v.tiles[pal].Texture, &src, &dest)
+			pal := v.palettes[v.mem.Load(caddr)&v.config.PaletteMask]
+			screenX := int(tx) * layout.CellW
+			screenY := int(ty) * layout.CellH
+			for y := 0; y < layout.CellH; y++ {
+				for x := 0; x < layout.CellW; x++ {
+					value := v.tiles.At(tileN, x, y)
+					v.plot(screenX+x, screenY+y, pal[value])
+				}
+			}
 		}
 	}
 }
 
-func (v *Video) renderSprites() {
-	// FIXME: Galaga testing
-	if v.config.Hack {
-		return
+func (v *Video) drawSprites() {
+	var sprites []Sprite
+	if v.config.ReadSprites != nil {
+		sprites = v.config.ReadSprites(v.mem)
+	} else {
+		sprites = v.readSprites()
 	}
 	layout := v.config.SpriteLayout
-	spriteW := int32(layout.CellW)
-	spriteH := int32(layout.CellH)
-	rowCells := int32(layout.W) / spriteW
+	spriteW := layout.CellW
+	spriteH := layout.CellH
 
-	for s := 7; s >= 0; s-- {
-		coordX := int32(v.SpriteCoords[s].X)
-		coordY := int32(v.SpriteCoords[s].Y)
-		info := v.mem.Load(uint16(0x4ff0 + (s * 2)))
-		spriteN := int32(info >> 2)
-		flip := sdl.FLIP_NONE
-		if info&0x02 > 0 {
-			flip |= sdl.FLIP_HORIZONTAL
-		}
-		if info&0x01 > 0 {
-			flip |= sdl.FLIP_VERTICAL
+	for _, s := range sprites {
+		pal := v.spritePalettes[s.Pal]
+		for y := 0; y < spriteH; y++ {
+			for x := 0; x < spriteW; x++ {
+				sx, sy := x, y
+				if s.FlipX {
+					sx = spriteW - 1 - x
+				}
+				if s.FlipY {
+					sy = spriteH - 1 - y
+				}
+				value := v.sprites.At(s.N, sx, sy)
+				v.plot(s.X+x, s.Y+y, pal[value])
+			}
 		}
+	}
+}
+
+// readSprites returns the eight sprites of Pac-Man with the first sprite
+// drawn last.
+func (v *Video) readSprites() []Sprite {
+	layout := v.config.SpriteLayout
+	sprites := make([]Sprite, 0, 8)
+	for s := 7; s >= 0; s-- {
+		coordX := int(v.SpriteCoords[s].X)
+		coordY := int(v.SpriteCoords[s].Y)
+		info := v.mem.Load(v.addr(0xff0 + s*2))
 
 		// do not render of off screen
 		if coordX <= 30 || coordX >= 240 {
 			continue
 		}
-		screenX := (w - coordX + spriteW) * v.frame.Scale
-		screenY := (h - coordY - spriteH) * v.frame.Scale
-		sheetX := (spriteN % rowCells) * spriteW
-		sheetY := (spriteN / rowCells) * spriteH
-		src := sdl.Rect{
-			X: int32(sheetX),
-			Y: int32(sheetY),
-			W: spriteW,
-			H: spriteH,
-		}
-		dest := sdl.Rect{
-			X: screenX + v.frame.X,
-			Y: screenY + v.frame.Y,
-			W: spriteW * v.frame.Scale,
-			H: spriteH * v.frame.Scale,
-		}
-		pal := v.mem.Load(uint16(0x4ff1 + (s * 2)))
-		v.r.CopyEx(v.sprites[pal].Texture, &src, &dest, 0, nil, flip)
+		sprites = append(sprites, Sprite{
+			N:     int(info >> 2),
+			X:     int(w) - coordX + layout.CellW,
+			Y:     int(h) - coordY - layout.CellH,
+			FlipX: info&0x02 > 0,
+			FlipY: info&0x01 > 0,
+			Pal:   v.mem.Load(v.addr(0xff1+s*2)) & v.config.PaletteMask,
+		})
 	}
+	return sprites
 }
 
-func ColorTable(mem memory.Memory, config Config) []video.Color {
-	// FIXME: Galaga testing
-	if config.Hack {
-		return []video.Color{}
+// drawStars plots the stars of the unrotated screen onto the rotated
+// frame.
+func (v *Video) drawStars() {
+	v.Stars.Draw(func(x int, y int, c uint8) {
+		v.plot(int(w)-1-y, x, starColors[c])
+	})
+}
+
+// plot sets the pixel at x, y to color unless the color is transparent or
+// the pixel is outside of the frame.
+func (v *Video) plot(x int, y int, c video.Color) {
+	if c[3] == 0 || x < 0 || y < 0 || x >= int(w) || y >= int(h) {
+		return
 	}
-	colors := make([]video.Color, 16, 16)
-	for addr := 0; addr < 16; addr++ {
+	i := v.img.PixOffset(x, y)
+	copy(v.img.Pix[i:i+4], c)
+}
+
+// ColorTable decodes the colors in the color ROM. Each color has three
+// bits of red and green and two bits of blue.
+func ColorTable(mem memory.Memory, config Config) []video.Color {
+	colors := make([]video.Color, config.Colors, config.Colors)
+	for addr := 0; addr < config.Colors; addr++ {
 		r, g, b := uint8(0), uint8(0), uint8(0)
 		c := mem.Load(uint16(addr))
 		for bit := 0; bit < 8; bit++ {
@@ -263,28 +349,24 @@ func ColorTable(mem memory.Memory, config Config) []video.Color {
 				b += colorWeights[bit][2]
 			}
 		}
-		alpha := uint8(0xff)
-		// Color 0 is actually transparent
-		if addr == 0 {
-			alpha = 0x00
-		}
-		colors[addr] = []uint8{r, g, b, alpha}
+		colors[addr] = []uint8{r, g, b, 0xff}
 	}
 	return colors
 }
 
+// PaletteTable builds the palettes found in the palette ROM. Each value
+// in the ROM is the index of a color except for the transparent value.
 func PaletteTable(mem memory.Memory, config Config, colors []video.Color) []video.Palette {
 	palettes := make([]video.Palette, config.PaletteEntries, config.PaletteEntries)
 	for pal := 0; pal < config.PaletteEntries; pal++ {
-		// FIXME: Galaga testing
-		if config.Hack {
-			palettes[pal] = ViewerPalette
-			continue
-		}
 		addr := pal * config.PaletteColors
 		entry := make(video.Palette, config.PaletteColors, config.PaletteColors)
 		for i := 0; i < config.PaletteColors; i++ {
 			ref := mem.Load(uint16(addr+i)) & 0x0f
+			if ref == config.Transparent {
+				entry[i] = transparent
+				continue
+			}
 			entry[i] = colors[ref]
 		}
 		palettes[pal] = entry
@@ -296,6 +378,18 @@ func (v *Video) addr(offset int) uint16 {
 	return v.config.VideoAddr + uint16(offset)
 }
 
+var transparent = video.Color{0, 0, 0, 0}
+
+// The stars have 64 colors with two bits each of red, green, and blue
+var starColors = func() []video.Color {
+	levels := []uint8{0x00, 0x47, 0x97, 0xde}
+	colors := make([]video.Color, 64, 64)
+	for i := range colors {
+		colors[i] = video.Color{levels[i&3], levels[i>>2&3], levels[i>>4&3], 0xff}
+	}
+	return colors
+}()
+
 var colorWeights = [][]uint8{
 	[]uint8{0x21, 0x00, 0x00},
 	[]uint8{0x47, 0x00, 0x00},
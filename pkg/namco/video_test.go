@@ -0,0 +1,169 @@
+package namco
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+// One byte per pixel to make the cells easy to build by hand
+func testPixelReader(mem memory.Memory, base uint16, pixel int) uint8 {
+	return mem.Load(base + uint16(pixel))
+}
+
+func testPixelLayout(size int) [][]int {
+	layout := make([][]int, size, size)
+	for y := 0; y < size; y++ {
+		layout[y] = make([]int, size, size)
+		for x := 0; x < size; x++ {
+			layout[y][x] = y*size + x
+		}
+	}
+	return layout
+}
+
+var testConfig = Config{
+	TileLayout: SheetLayout{
+		CellW:        8,
+		CellH:        8,
+		W:            16,
+		H:            8,
+		PixelLayout:  testPixelLayout(8),
+		PixelReader:  testPixelReader,
+		BytesPerCell: 64,
+	},
+	SpriteLayout: SheetLayout{
+		CellW:        16,
+		CellH:        16,
+		W:            16,
+		H:            16,
+		PixelLayout:  testPixelLayout(16),
+		PixelReader:  testPixelReader,
+		BytesPerCell: 256,
+	},
+	VideoAddr:      0x4000,
+	Colors:         16,
+	PaletteEntries: 2,
+	PaletteColors:  4,
+	PaletteMask:    0x1f,
+}
+
+var (
+	black = color.RGBA{0, 0, 0, 0xff}
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	green = color.RGBA{0, 0xff, 0, 0xff}
+)
+
+func newTestVideo(t *testing.T) (*Video, memory.Memory) {
+	tiles := make([]uint8, 128, 128)
+	tiles[64] = 1 // tile 1, pixel 0, 0
+	sprites := make([]uint8, 256, 256)
+	sprites[0] = 2 // sprite 0, pixel 0, 0
+	colors := make([]uint8, 16, 16)
+	colors[1] = 0x07 // all red bits
+	colors[2] = 0x38 // all green bits
+	rom := memory.Set{
+		"tile":    memory.NewROM(tiles),
+		"sprite":  memory.NewROM(sprites),
+		"color":   memory.NewROM(colors),
+		"palette": memory.NewROM([]uint8{0, 0, 0, 0, 0, 1, 2, 0}),
+	}
+	mem := memory.NewRAM(0x10000)
+	v, err := NewVideo(nil, mem, rom, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, mem
+}
+
+func TestDrawTile(t *testing.T) {
+	v, mem := newTestVideo(t)
+	// Top left corner of the screen
+	mem.Store(0x43dd, 1)
+	mem.Store(0x47dd, 1)
+	v.Draw()
+	img := v.Frame()
+	With(t).Expect(img.RGBAAt(0, 0)).ToBe(red)
+	With(t).Expect(img.RGBAAt(1, 0)).ToBe(black)
+	With(t).Expect(img.RGBAAt(8, 0)).ToBe(black)
+}
+
+func TestDrawSprite(t *testing.T) {
+	v, mem := newTestVideo(t)
+	v.SpriteCoords[0] = SpriteCoord{X: 100, Y: 100}
+	mem.Store(0x4ff1, 1)
+	v.Draw()
+	x, y := 224-100+16, 288-100-16
+	With(t).Expect(v.Frame().RGBAAt(x, y)).ToBe(green)
+
+	mem.Store(0x4ff0, 0x03) // flip x and y
+	v.Draw()
+	img := v.Frame()
+	With(t).Expect(img.RGBAAt(x, y)).ToBe(black)
+	With(t).Expect(img.RGBAAt(x+15, y+15)).ToBe(green)
+}
+
+func TestDrawSpriteOffScreen(t *testing.T) {
+	v, mem := newTestVideo(t)
+	v.SpriteCoords[0] = SpriteCoord{X: 20, Y: 100}
+	mem.Store(0x4ff1, 1)
+	v.Draw()
+	img := v.Frame()
+	for y := 0; y < int(h); y++ {
+		for x := 0; x < int(w); x++ {
+			if img.RGBAAt(x, y) != black {
+				t.Fatalf("expected black at %v, %v", x, y)
+			}
+		}
+	}
+}
+
+func TestDrawReadSprites(t *testing.T) {
+	v, _ := newTestVideo(t)
+	v.config.ReadSprites = func(memory.Memory) []Sprite {
+		return []Sprite{{N: 0, X: 10, Y: 20, FlipX: true, Pal: 1}}
+	}
+	v.Draw()
+	img := v.Frame()
+	With(t).Expect(img.RGBAAt(10, 20)).ToBe(black)
+	With(t).Expect(img.RGBAAt(25, 20)).ToBe(green)
+}
+
+func TestDrawStars(t *testing.T) {
+	v, _ := newTestVideo(t)
+	v.Stars = NewN05XX()
+	v.Stars.Control[5] = 1
+	var star testStar
+	v.Stars.Draw(func(x int, y int, c uint8) { star = testStar{x, y} })
+	v.Draw()
+	With(t).Expect(v.Frame().RGBAAt(int(w)-1-star.y, star.x)).NotToBe(black)
+}
+
+func TestDrawSpritePaletteMasked(t *testing.T) {
+	v, mem := newTestVideo(t)
+	v.SpriteCoords[0] = SpriteCoord{X: 100, Y: 100}
+	mem.Store(0x4ff1, 0x41) // palette 1 with bits above the mask
+	v.Draw()
+	x, y := 224-100+16, 288-100-16
+	With(t).Expect(v.Frame().RGBAAt(x, y)).ToBe(green)
+}
+
+func TestDrawOverlay(t *testing.T) {
+	v, mem := newTestVideo(t)
+	mem.Store(0x43dd, 1)
+	mem.Store(0x47dd, 1)
+	v.SetOverlay(func(img *image.RGBA) {
+		img.SetRGBA(1, 0, green)
+	})
+	v.Draw()
+	img := v.Frame()
+	With(t).Expect(img.RGBAAt(0, 0)).ToBe(red)
+	With(t).Expect(img.RGBAAt(1, 0)).ToBe(green)
+
+	v.SetOverlay(nil)
+	v.Draw()
+	With(t).Expect(v.Frame().RGBAAt(1, 0)).ToBe(black)
+}
@@ -0,0 +1,127 @@
+package namco
+
+import (
+	"io"
+
+	"github.com/blackchip-org/pac8/pkg/audio"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/util/bits"
+)
+
+type Voice struct {
+	Acc      [5]uint8
+	Waveform uint8
+	Freq     [5]uint8
+	Vol      uint8
+}
+
+// WSG is the waveform sound generator with three voices. The first three
+// voices of the synth are used for the WSG and any others are left to the
+// caller.
+type WSG struct {
+	Synth     *audio.Synth
+	Voices    [3]Voice
+	waveforms [16][]float64
+}
+
+// NewWSG creates a sound generator that plays the 32 sample waveforms
+// found in rom.
+func NewWSG(synth *audio.Synth, rom memory.Memory) *WSG {
+	a := &WSG{Synth: synth}
+	for i := 0; i < 16 && i*32 < rom.Length(); i++ {
+		addr := uint16(i * 32)
+		a.waveforms[i] = rescale(rom, addr)
+	}
+	return a
+}
+
+func (a *WSG) Queue() error {
+	for i := 0; i < 3; i++ {
+		v := a.Voices[i]
+		wf := bits.Slice(v.Waveform, 0, 2)
+
+		// Voice 0 has 5 bytes but Voice 1 and 2 only have 4 bytes with
+		// the missing lower byte being zero.
+		nFreq := 4
+		if i == 0 {
+			nFreq = 5
+		}
+		a.Synth.V[i].Freq = freq(v.Freq, nFreq)
+		a.Synth.V[i].Vol = float64(v.Vol&0xf) / 15
+		a.Synth.V[i].Waveform = a.waveforms[wf]
+	}
+	return a.Synth.Queue()
+}
+
+func (a *WSG) SetTap(w io.Writer) {
+	a.Synth.SetTap(w)
+}
+
+func (a *WSG) SampleRate() int {
+	return a.Synth.SampleRate()
+}
+
+// MapWSG maps the 32 registers of the sound generator to the ports
+// starting at p.
+func MapWSG(pm memory.PortMapper, p int, a *WSG) {
+	pm.WO(p+0x00, &a.Voices[0].Acc[0])
+	pm.WO(p+0x01, &a.Voices[0].Acc[1])
+	pm.WO(p+0x02, &a.Voices[0].Acc[2])
+	pm.WO(p+0x03, &a.Voices[0].Acc[3])
+	pm.WO(p+0x04, &a.Voices[0].Acc[4])
+	pm.WO(p+0x05, &a.Voices[0].Waveform)
+
+	pm.WO(p+0x06, &a.Voices[1].Acc[0])
+	pm.WO(p+0x07, &a.Voices[1].Acc[1])
+	pm.WO(p+0x08, &a.Voices[1].Acc[2])
+	pm.WO(p+0x09, &a.Voices[1].Acc[3])
+	pm.WO(p+0x0a, &a.Voices[1].Waveform)
+
+	pm.WO(p+0x0b, &a.Voices[2].Acc[0])
+	pm.WO(p+0x0c, &a.Voices[2].Acc[1])
+	pm.WO(p+0x0d, &a.Voices[2].Acc[2])
+	pm.WO(p+0x0e, &a.Voices[2].Acc[3])
+	pm.WO(p+0x0f, &a.Voices[2].Waveform)
+
+	pm.WO(p+0x10, &a.Voices[0].Freq[0])
+	pm.WO(p+0x11, &a.Voices[0].Freq[1])
+	pm.WO(p+0x12, &a.Voices[0].Freq[2])
+	pm.WO(p+0x13, &a.Voices[0].Freq[3])
+	pm.WO(p+0x14, &a.Voices[0].Freq[4])
+	pm.WO(p+0x15, &a.Voices[0].Vol)
+
+	pm.WO(p+0x16, &a.Voices[1].Freq[0])
+	pm.WO(p+0x17, &a.Voices[1].Freq[1])
+	pm.WO(p+0x18, &a.Voices[1].Freq[2])
+	pm.WO(p+0x19, &a.Voices[1].Freq[3])
+	pm.WO(p+0x1a, &a.Voices[1].Vol)
+
+	pm.WO(p+0x1b, &a.Voices[2].Freq[0])
+	pm.WO(p+0x1c, &a.Voices[2].Freq[1])
+	pm.WO(p+0x1d, &a.Voices[2].Freq[2])
+	pm.WO(p+0x1e, &a.Voices[2].Freq[3])
+	pm.WO(p+0x1f, &a.Voices[2].Vol)
+}
+
+func rescale(mem memory.Memory, addr uint16) []float64 {
+	out := make([]float64, 32, 32)
+	for i := uint16(0); i < 32; i++ {
+		v := mem.Load(addr + i)
+		out[i] = (float64(v) - 7.5) / 8
+	}
+	return out
+}
+
+func freq(f [5]uint8, n int) int {
+	val := uint32(0)
+	shift := uint(0)
+	if n == 4 {
+		shift = 4
+	}
+	for i := 0; i < n; i++ {
+		val += uint32(f[i]&0x0f) << shift
+		shift += 4
+	}
+	freq := (375.0 / 4096.0) * float32(val)
+	return int(freq)
+}
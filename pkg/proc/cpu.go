@@ -21,6 +21,7 @@ type CPU interface {
 	Next()
 	String() string
 	Ready() bool
+	Cycles() int
 	Info() Info
 	Save(*state.Encoder)
 	Restore(*state.Decoder)
@@ -32,9 +33,30 @@ type Value struct {
 }
 
 type Info struct {
-	CycleRate       int
+	CycleRate       int // cycles per millisecond
 	CodeReader      CodeReader
 	CodeFormatter   CodeFormatter
 	NewDisassembler func(memory.Memory) *Disassembler
 	Registers       map[string]Value
+	// Assemble returns the bytes for the instruction in src when placed at
+	// addr. Values in operands are evaluated with eval. It is nil if there
+	// is no assembler for the CPU.
+	Assemble func(src string, addr uint16, eval func(string) (int, error)) ([]uint8, error)
+}
+
+// Frame is a call to a subroutine or an interrupt handler that has not
+// returned yet.
+type Frame struct {
+	Kind   string // call, rst, int, or nmi
+	From   uint16 // address of the call, or where the interrupt was taken
+	Target uint16 // address of the subroutine or handler
+	Return uint16 // address that execution continues at after the return
+	SP     uint16 // stack pointer after the return address was pushed
+}
+
+// CallStacker is implemented by a CPU that keeps a shadow call stack.
+type CallStacker interface {
+	// CallStack returns the frames that have not returned, with the
+	// innermost frame last.
+	CallStack() []Frame
 }
@@ -19,7 +19,37 @@ func NewStatement() *Statement {
 	return &Statement{Bytes: make([]uint8, 0, 0)}
 }
 
-type CodeInfo struct{}
+// CodeInfo has the names and comments for addresses that are used when
+// disassembling code.
+type CodeInfo struct {
+	Symbols  map[string]uint16 // addresses by name
+	Labels   map[uint16]string // names by address
+	Comments map[uint16]string // comments by address
+}
+
+func NewCodeInfo() *CodeInfo {
+	return &CodeInfo{
+		Symbols:  make(map[string]uint16),
+		Labels:   make(map[uint16]string),
+		Comments: make(map[uint16]string),
+	}
+}
+
+// Ref returns how a reference to addr in statement s is written. The label
+// for addr is used if there is one. If addr has a comment and s does not,
+// the comment is added to s.
+func (c *CodeInfo) Ref(s *Statement, addr uint16, format string) string {
+	if c == nil {
+		return fmt.Sprintf(format, addr)
+	}
+	if s.Comment == "" {
+		s.Comment = c.Comments[addr]
+	}
+	if label, ok := c.Labels[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf(format, addr)
+}
 
 type CodeReader func(Eval) Statement
 type CodeFormatter func(Statement) string
@@ -40,7 +70,7 @@ type Eval struct {
 
 func NewDisassembler(mem memory.Memory, r CodeReader, f CodeFormatter) *Disassembler {
 	return &Disassembler{
-		CodeInfo: &CodeInfo{},
+		CodeInfo: NewCodeInfo(),
 		mem:      mem,
 		cursor:   memory.NewCursor(mem),
 		read:     r,
@@ -49,10 +79,15 @@ func NewDisassembler(mem memory.Memory, r CodeReader, f CodeFormatter) *Disassem
 }
 
 func (d *Disassembler) NextStatement() Statement {
+	s := NewStatement()
+	if d.CodeInfo != nil {
+		s.Label = d.CodeInfo.Labels[d.cursor.Pos]
+		s.Comment = d.CodeInfo.Comments[d.cursor.Pos]
+	}
 	return d.read(Eval{
 		Cursor:    d.cursor,
 		CodeInfo:  d.CodeInfo,
-		Statement: NewStatement(),
+		Statement: s,
 	})
 }
 
@@ -78,5 +113,12 @@ func Format(s Statement, options FormatOptions) string {
 		bytes = append(bytes, fmt.Sprintf("%02x", b))
 	}
 	sbytes := fmt.Sprintf(options.BytesFormat, strings.Join(bytes, " "))
-	return fmt.Sprintf("$%04x:  %s  %s", s.Address, sbytes, s.Op)
+	line := fmt.Sprintf("$%04x:  %s  %s", s.Address, sbytes, s.Op)
+	if s.Comment != "" {
+		line = fmt.Sprintf("%-40s ; %s", line, s.Comment)
+	}
+	if s.Label != "" {
+		line = s.Label + ":\n" + line
+	}
+	return line
 }
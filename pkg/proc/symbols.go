@@ -0,0 +1,79 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseSymbols reads a symbol file from r.
+//
+// Each line gives a name to an address and may be followed by a comment
+// that starts with a semicolon. A line with only an address attaches the
+// comment to that address without naming it. Addresses are hexadecimal.
+// Blank lines and lines that start with '#' are ignored:
+//
+//	# Pac-Man
+//	reset=0000
+//	rst38=0038     ; interrupt handler
+//	credits=4e6e   ; number of credits
+//	4e80           ; player one score
+func ParseSymbols(r io.Reader) (*CodeInfo, error) {
+	c := NewCodeInfo()
+	scanner := bufio.NewScanner(r)
+	lineN := 0
+	for scanner.Scan() {
+		lineN++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		comment := ""
+		if i := strings.Index(line, ";"); i >= 0 {
+			comment = strings.TrimSpace(line[i+1:])
+			line = strings.TrimSpace(line[:i])
+		}
+		name, value := "", line
+		if i := strings.Index(line, "="); i >= 0 {
+			name = strings.TrimSpace(line[:i])
+			value = strings.TrimSpace(line[i+1:])
+			if name == "" {
+				return nil, fmt.Errorf("line %v: missing name", lineN)
+			}
+		}
+		value = strings.TrimPrefix(strings.TrimPrefix(value, "$"), "0x")
+		addr, err := strconv.ParseUint(value, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid address: %v", lineN, value)
+		}
+		if name != "" {
+			if _, exists := c.Symbols[name]; exists {
+				return nil, fmt.Errorf("line %v: duplicate name: %v", lineN, name)
+			}
+			c.Symbols[name] = uint16(addr)
+			if _, exists := c.Labels[uint16(addr)]; !exists {
+				c.Labels[uint16(addr)] = name
+			}
+		}
+		if comment != "" {
+			c.Comments[uint16(addr)] = comment
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadSymbols reads a symbol file from the file at path.
+func LoadSymbols(path string) (*CodeInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSymbols(f)
+}
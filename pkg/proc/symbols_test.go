@@ -0,0 +1,50 @@
+package proc
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestParseSymbols(t *testing.T) {
+	src := `
+# comment
+reset=0000
+rst38 = $0038 ; interrupt handler
+alias=0x0038
+4e80 ; score
+`
+	c, err := ParseSymbols(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(c.Symbols["reset"]).ToBe(uint16(0x0000))
+	With(t).Expect(c.Symbols["rst38"]).ToBe(uint16(0x0038))
+	With(t).Expect(c.Symbols["alias"]).ToBe(uint16(0x0038))
+	With(t).Expect(c.Labels[0x0038]).ToBe("rst38")
+	With(t).Expect(c.Comments[0x0038]).ToBe("interrupt handler")
+	With(t).Expect(c.Comments[0x4e80]).ToBe("score")
+	With(t).Expect(len(c.Labels)).ToBe(2)
+}
+
+func TestParseSymbolsErrors(t *testing.T) {
+	var tests = []struct {
+		src string
+		err string
+	}{
+		{"foo=xyz", "line 1: invalid address: xyz"},
+		{"=1234", "line 1: missing name"},
+		{"a=1\na=2", "line 2: duplicate name: a"},
+		{"a=10000", "line 1: invalid address: 10000"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			_, err := ParseSymbols(strings.NewReader(test.src))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			With(t).Expect(err.Error()).ToBe(test.err)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	snapshotMagic = "pac8-snapshot"
+	// Version is the version of the snapshot file format
+	Version = 1
+)
+
+// Header describes the contents of a snapshot.
+type Header struct {
+	Magic    string
+	Version  int
+	Game     string
+	ROM      map[string]string // checksums by ROM path
+	Created  time.Time
+	Sections []string
+}
+
+type section struct {
+	Name string
+	Data []byte
+}
+
+// Writer creates a snapshot made of named sections. Each component of a
+// system saves its state to its own section.
+type Writer struct {
+	header   Header
+	buffers  map[string]*bytes.Buffer
+	encoders map[string]*Encoder
+}
+
+func NewWriter(game string, rom map[string]string) *Writer {
+	return &Writer{
+		header: Header{
+			Magic:    snapshotMagic,
+			Version:  Version,
+			Game:     game,
+			ROM:      rom,
+			Created:  time.Now(),
+			Sections: make([]string, 0, 0),
+		},
+		buffers:  make(map[string]*bytes.Buffer),
+		encoders: make(map[string]*Encoder),
+	}
+}
+
+// Section returns the encoder for the section with name. The section is
+// created if it does not exist.
+func (w *Writer) Section(name string) *Encoder {
+	if enc, ok := w.encoders[name]; ok {
+		return enc
+	}
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	w.header.Sections = append(w.header.Sections, name)
+	w.buffers[name] = buf
+	w.encoders[name] = enc
+	return enc
+}
+
+// Err returns the first error encountered while encoding any section.
+func (w *Writer) Err() error {
+	for _, name := range w.header.Sections {
+		if err := w.encoders[name].Err; err != nil {
+			return fmt.Errorf("section %v: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Encode writes the header and all sections to out.
+func (w *Writer) Encode(out io.Writer) error {
+	if err := w.Err(); err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(out)
+	if err := enc.Encode(w.header); err != nil {
+		return err
+	}
+	for _, name := range w.header.Sections {
+		s := section{Name: name, Data: w.buffers[name].Bytes()}
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader provides the sections of a snapshot.
+type Reader struct {
+	Header   Header
+	data     map[string][]byte
+	decoders map[string]*Decoder
+	names    []string
+}
+
+// NewReader reads a snapshot from in. An error is returned if in does not
+// contain a snapshot or the snapshot version is not supported.
+func NewReader(in io.Reader) (*Reader, error) {
+	dec := gob.NewDecoder(in)
+	r := &Reader{
+		data:     make(map[string][]byte),
+		decoders: make(map[string]*Decoder),
+		names:    make([]string, 0, 0),
+	}
+	if err := dec.Decode(&r.Header); err != nil || r.Header.Magic != snapshotMagic {
+		return nil, fmt.Errorf("not a snapshot file")
+	}
+	if r.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version: %v", r.Header.Version)
+	}
+	for range r.Header.Sections {
+		var s section
+		if err := dec.Decode(&s); err != nil {
+			return nil, fmt.Errorf("unable to read section: %v", err)
+		}
+		r.data[s.Name] = s.Data
+	}
+	return r, nil
+}
+
+// Section returns the decoder for the section with name. If there is no
+// such section, the error of the decoder is already set.
+func (r *Reader) Section(name string) *Decoder {
+	if dec, ok := r.decoders[name]; ok {
+		return dec
+	}
+	var dec *Decoder
+	data, ok := r.data[name]
+	if ok {
+		dec = NewDecoder(bytes.NewReader(data))
+	} else {
+		dec = &Decoder{Err: fmt.Errorf("no such section")}
+	}
+	r.decoders[name] = dec
+	r.names = append(r.names, name)
+	return dec
+}
+
+// Err returns the first error encountered while decoding any section.
+func (r *Reader) Err() error {
+	for _, name := range r.names {
+		if err := r.decoders[name].Err; err != nil {
+			return fmt.Errorf("section %v: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestSnapshot(t *testing.T) {
+	w := NewWriter("pacman", map[string]string{"pacman.6e": "1234"})
+	w.Section("cpu").Encode(uint8(42))
+	w.Section("mem").Encode([]uint8{1, 2, 3})
+	w.Section("cpu").Encode(uint16(0x1234))
+	var buf bytes.Buffer
+	if err := w.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	With(t).Expect(r.Header.Game).ToBe("pacman")
+	With(t).Expect(r.Header.ROM["pacman.6e"]).ToBe("1234")
+	With(t).Expect(r.Header.Sections).ToBe([]string{"cpu", "mem"})
+
+	// Sections can be read in any order
+	var mem []uint8
+	var a uint8
+	var pc uint16
+	r.Section("mem").Decode(&mem)
+	r.Section("cpu").Decode(&a)
+	r.Section("cpu").Decode(&pc)
+	With(t).Expect(r.Err()).ToBe(nil)
+	With(t).Expect(mem).ToBe([]uint8{1, 2, 3})
+	With(t).Expect(a).ToBe(uint8(42))
+	With(t).Expect(pc).ToBe(uint16(0x1234))
+}
+
+func TestSnapshotMissingSection(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter("pacman", nil).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v uint8
+	r.Section("regs").Decode(&v)
+	With(t).Expect(r.Err().Error()).ToBe("section regs: no such section")
+}
+
+func TestSnapshotShortSection(t *testing.T) {
+	w := NewWriter("pacman", nil)
+	w.Section("cpu").Encode(uint8(42))
+	var buf bytes.Buffer
+	if err := w.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b uint8
+	r.Section("cpu").Decode(&a)
+	r.Section("cpu").Decode(&b)
+	With(t).Expect(r.Err().Error()).ToBe("section cpu: EOF")
+}
+
+func TestSnapshotNotASnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode([]uint8{1, 2, 3})
+	_, err := NewReader(&buf)
+	With(t).Expect(err.Error()).ToBe("not a snapshot file")
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(Header{Magic: snapshotMagic, Version: 99})
+	_, err := NewReader(&buf)
+	With(t).Expect(err.Error()).ToBe("unsupported snapshot version: 99")
+}
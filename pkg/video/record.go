@@ -0,0 +1,139 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FrameWriter records a series of frames.
+type FrameWriter interface {
+	WriteFrame(*image.RGBA) error
+	Close() error
+}
+
+// CreateFrameWriter returns a GIFWriter if path ends with ".gif".
+// Otherwise, path is a directory for a PNGWriter.
+func CreateFrameWriter(path string, frameRate time.Duration) (FrameWriter, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".gif") {
+		return CreateGIF(path, frameRate)
+	}
+	return CreatePNGSequence(path)
+}
+
+// PNGWriter writes each frame to a numbered PNG file in a directory.
+type PNGWriter struct {
+	dir string
+	n   int
+}
+
+func CreatePNGSequence(dir string) (*PNGWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PNGWriter{dir: dir}, nil
+}
+
+func (w *PNGWriter) WriteFrame(img *image.RGBA) error {
+	path := filepath.Join(w.dir, fmt.Sprintf("frame-%06d.png", w.n))
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := png.Encode(out, img); err != nil {
+		return err
+	}
+	w.n++
+	return nil
+}
+
+func (w *PNGWriter) Close() error {
+	return nil
+}
+
+// GIFWriter writes frames to an animated GIF. Every frame is kept in
+// memory, as a paletted image, until the writer is closed and nothing is
+// written to the file before then. This is best used for short clips.
+type GIFWriter struct {
+	path      string
+	frameRate time.Duration
+	anim      gif.GIF
+	elapsed   time.Duration
+}
+
+func CreateGIF(path string, frameRate time.Duration) (*GIFWriter, error) {
+	// Check now that the file can be created instead of waiting until the
+	// recording is finished
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	return &GIFWriter{path: path, frameRate: frameRate}, nil
+}
+
+func (w *GIFWriter) WriteFrame(img *image.RGBA) error {
+	// GIF delays are in 100ths of a second. Track the total time elapsed
+	// so that rounding does not cause the animation to drift.
+	before := w.elapsed / (10 * time.Millisecond)
+	w.elapsed += w.frameRate
+	delay := int(w.elapsed/(10*time.Millisecond) - before)
+	if delay == 0 {
+		// Too short to be seen. The time is still in the elapsed total so
+		// it is added to the delay of the next frame.
+		return nil
+	}
+	w.anim.Image = append(w.anim.Image, paletted(img))
+	w.anim.Delay = append(w.anim.Delay, delay)
+	return nil
+}
+
+func (w *GIFWriter) Close() error {
+	if len(w.anim.Image) == 0 {
+		os.Remove(w.path)
+		return fmt.Errorf("no frames recorded")
+	}
+	out, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return gif.EncodeAll(out, &w.anim)
+}
+
+// paletted converts img to a paletted image. The colors are exact when
+// there are no more than 256 of them. Otherwise, the Plan 9 palette is used.
+func paletted(img *image.RGBA) *image.Paletted {
+	b := img.Bounds()
+	pal := color.Palette{}
+	index := make(map[color.RGBA]uint8)
+	out := image.NewPaletted(b, nil)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				if len(pal) == 256 {
+					out.Palette = palette.Plan9
+					draw.Draw(out, b, img, b.Min, draw.Src)
+					return out
+				}
+				i = uint8(len(pal))
+				index[c] = i
+				pal = append(pal, c)
+			}
+			out.SetColorIndex(x, y, i)
+		}
+	}
+	out.Palette = pal
+	return out
+}
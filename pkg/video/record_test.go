@@ -0,0 +1,84 @@
+package video
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestGIFDelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := CreateGIF(filepath.Join(dir, "test.gif"), 16670*time.Microsecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 6; i++ {
+		w.WriteFrame(img)
+	}
+	With(t).Expect(w.anim.Delay).ToBe([]int{1, 2, 2, 1, 2, 2})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGIFShortDelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := CreateGIF(filepath.Join(dir, "test.gif"), 4*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 10; i++ {
+		w.WriteFrame(img)
+	}
+	// 40ms in total
+	With(t).Expect(w.anim.Delay).ToBe([]int{1, 1, 1, 1})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGIFNoFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pac8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.gif")
+	w, err := CreateGIF(path, 16670*time.Microsecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Fatal("expected error")
+	}
+	_, err = os.Stat(path)
+	With(t).Expect(os.IsNotExist(err)).ToBe(true)
+}
+
+func TestPaletted(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, red)
+	p := paletted(img)
+	With(t).Expect(len(p.Palette)).ToBe(2)
+	With(t).Expect(p.At(1, 0)).ToBe(red)
+}
@@ -2,6 +2,7 @@ package video
 
 import (
 	"fmt"
+	"image"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
@@ -13,6 +14,18 @@ type Display interface {
 	Render()
 }
 
+// Framer is a Display that keeps a copy of the last frame rendered.
+type Framer interface {
+	Frame() *image.RGBA
+}
+
+// Overlayer is a Display that can have something drawn over each frame,
+// such as a heads-up display. The function is called from the machine
+// goroutine after the frame is drawn. Set it to nil to remove it.
+type Overlayer interface {
+	SetOverlay(draw func(*image.RGBA))
+}
+
 type NullDisplay struct{}
 
 func (d NullDisplay) Render() {}
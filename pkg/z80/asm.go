@@ -0,0 +1,351 @@
+package z80
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/util/bits"
+)
+
+type argKind int
+
+const (
+	argLiteral argKind = iota // register, condition, or fixed number
+	argImm8                   // n
+	argImm16                  // nn
+	argAddr                   // (nn)
+	argPort                   // (n)
+	argRel                    // target of a relative jump
+	argIndex                  // (ix+d) or (iy+d)
+)
+
+type asmArg struct {
+	kind argKind
+	text string // literal text or index register
+	pos  int    // offset of the value in the instruction
+}
+
+type asmPattern struct {
+	args  []asmArg
+	bytes []uint8 // instruction with placeholders for the values
+}
+
+// asmTable has the patterns for each mnemonic. It is built by running the
+// disassembler over every opcode so that the assembler accepts exactly
+// what the disassembler produces. When more than one encoding has the
+// same form, the first one found is used and it is always the shortest.
+var asmTable = make(map[string][]asmPattern)
+
+// Placeholder values that are put in the operand bytes when building the
+// table. They are found again in the disassembly to locate each operand.
+const (
+	fill0 = 0x9a
+	fill1 = 0xbc
+)
+
+func init() {
+	mem := memory.NewRAM(8)
+	dasm := NewDisassembler(mem)
+	dasm.CodeInfo = nil
+	prefixed := func(op int) bool {
+		return op == 0xcb || op == 0xdd || op == 0xed || op == 0xfd
+	}
+	add := func(opcode []uint8, fillAt int) {
+		c := memory.NewCursor(mem)
+		c.PutN(opcode[:fillAt]...)
+		if fillAt < len(opcode) {
+			// The displacement comes before the opcode
+			c.PutN(fill0)
+		} else {
+			c.PutN(fill0, fill1)
+		}
+		c.PutN(opcode[fillAt:]...)
+		dasm.SetPC(0)
+		s := dasm.NextStatement()
+		if strings.HasPrefix(s.Op, "?") {
+			return
+		}
+		addPattern(s.Op, s.Bytes, fillAt)
+	}
+	for op := 0; op < 0x100; op++ {
+		if !prefixed(op) {
+			add([]uint8{uint8(op)}, 1)
+		}
+	}
+	for _, prefix := range []uint8{0xcb, 0xed, 0xdd, 0xfd} {
+		for op := 0; op < 0x100; op++ {
+			if prefix != 0xcb && prefix != 0xed && prefixed(op) {
+				continue
+			}
+			add([]uint8{prefix, uint8(op)}, 2)
+		}
+	}
+	for _, prefix := range []uint8{0xdd, 0xfd} {
+		for op := 0; op < 0x100; op++ {
+			add([]uint8{prefix, 0xcb, uint8(op)}, 2)
+		}
+	}
+}
+
+func addPattern(op string, bytes []uint8, fillAt int) {
+	mnemonic, operands := splitOp(op)
+	p := asmPattern{bytes: append([]uint8{}, bytes...)}
+	rel := fmt.Sprintf("$%04x", bits.Displace(2, fill0))
+	for _, text := range operands {
+		arg := asmArg{kind: argLiteral, text: text, pos: fillAt}
+		switch {
+		case text == fmt.Sprintf("$%02x%02x", fill1, fill0):
+			arg.kind = argImm16
+		case text == fmt.Sprintf("($%02x%02x)", fill1, fill0):
+			arg.kind = argAddr
+		case (mnemonic == "jr" || mnemonic == "djnz") && text == rel:
+			arg.kind = argRel
+		case text == fmt.Sprintf("$%02x", fill0):
+			arg.kind = argImm8
+		case text == fmt.Sprintf("$%02x", fill1):
+			arg.kind = argImm8
+			arg.pos = fillAt + 1
+		case text == fmt.Sprintf("($%02x)", fill0):
+			arg.kind = argPort
+		case text == fmt.Sprintf("(ix+$%02x)", fill0):
+			arg.kind, arg.text = argIndex, "ix"
+		case text == fmt.Sprintf("(iy+$%02x)", fill0):
+			arg.kind, arg.text = argIndex, "iy"
+		}
+		p.args = append(p.args, arg)
+	}
+	for _, existing := range asmTable[mnemonic] {
+		if sameForm(existing, p) {
+			return
+		}
+	}
+	asmTable[mnemonic] = append(asmTable[mnemonic], p)
+}
+
+func sameForm(a asmPattern, b asmPattern) bool {
+	if len(a.args) != len(b.args) {
+		return false
+	}
+	for i := range a.args {
+		if a.args[i].kind != b.args[i].kind || a.args[i].text != b.args[i].text {
+			return false
+		}
+	}
+	return true
+}
+
+func splitOp(op string) (string, []string) {
+	op = strings.TrimSpace(op)
+	i := strings.IndexAny(op, " \t")
+	if i < 0 {
+		return strings.ToLower(op), nil
+	}
+	operands := strings.Split(op[i+1:], ",")
+	for j := range operands {
+		operands[j] = strings.TrimSpace(operands[j])
+	}
+	return strings.ToLower(op[:i]), operands
+}
+
+// ParseValue evaluates a number in an operand. The number is hexadecimal
+// and may have a "$" or "0x" prefix. Decimal numbers have a "+" prefix.
+// Negative numbers start with "-".
+func ParseValue(str string) (int, error) {
+	str = strings.TrimSpace(str)
+	sign := 1
+	if strings.HasPrefix(str, "-") {
+		sign = -1
+		str = strings.TrimSpace(str[1:])
+	}
+	base := 16
+	digits := strings.TrimPrefix(strings.TrimPrefix(str, "$"), "0x")
+	if strings.HasPrefix(str, "+") {
+		base = 10
+		digits = str[1:]
+	}
+	v, err := strconv.ParseUint(digits, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %v", str)
+	}
+	return sign * int(v), nil
+}
+
+func inParens(str string) (string, bool) {
+	if len(str) >= 2 && str[0] == '(' && str[len(str)-1] == ')' {
+		return strings.TrimSpace(str[1 : len(str)-1]), true
+	}
+	return str, false
+}
+
+// indexArg splits an (ix+d) or (iy+d) operand into the register and the
+// expression for the displacement.
+func indexArg(str string) (string, string, bool) {
+	inner, ok := inParens(str)
+	if !ok || len(inner) < 2 {
+		return "", "", false
+	}
+	reg := strings.ToLower(inner[:2])
+	if reg != "ix" && reg != "iy" {
+		return "", "", false
+	}
+	rest := strings.TrimSpace(inner[2:])
+	switch {
+	case rest == "":
+		return reg, "0", true
+	case rest[0] == '+':
+		return reg, strings.TrimSpace(rest[1:]), true
+	case rest[0] == '-':
+		return reg, rest, true
+	}
+	return "", "", false
+}
+
+// match checks that the operand str has the form of arg. It returns the
+// number of operands that matched exactly so that a register is preferred
+// over a value that happens to have the same name.
+func (a asmArg) match(str string, eval func(string) (int, error)) (int, bool) {
+	switch a.kind {
+	case argLiteral:
+		if strings.EqualFold(str, a.text) {
+			return 1, true
+		}
+		// Fixed numbers like the argument of rst, im, and bit can be
+		// written in any way
+		number := strings.HasPrefix(a.text, "$") ||
+			len(a.text) == 1 && a.text[0] >= '0' && a.text[0] <= '9'
+		want, err := ParseValue(a.text)
+		if err != nil {
+			return 0, false
+		}
+		if number {
+			if _, paren := inParens(str); paren {
+				return 0, false
+			}
+			if got, err := eval(str); err == nil && got == want {
+				return 1, true
+			}
+		}
+		return 0, false
+	case argIndex:
+		reg, _, ok := indexArg(str)
+		return 1, ok && reg == a.text
+	case argAddr, argPort:
+		if _, _, index := indexArg(str); index {
+			return 0, false
+		}
+		_, paren := inParens(str)
+		return 0, paren
+	}
+	_, paren := inParens(str)
+	return 0, !paren
+}
+
+// Assemble returns the bytes for the instruction in src when it is placed
+// at addr. The instruction is written in the same way as the disassembler
+// writes it, although case and spacing may vary. Values in operands are
+// evaluated with eval. If eval is nil, ParseValue is used.
+//
+// An operand that could be either a register or a value, such as "b", is
+// taken to be the register. Prefix the value with "$" to use it as a
+// number instead.
+func Assemble(src string, addr uint16, eval func(string) (int, error)) ([]uint8, error) {
+	if eval == nil {
+		eval = ParseValue
+	}
+	mnemonic, operands := splitOp(src)
+	if mnemonic == "" {
+		return nil, fmt.Errorf("no instruction")
+	}
+	patterns, ok := asmTable[mnemonic]
+	if !ok {
+		return nil, fmt.Errorf("unknown instruction: %v", mnemonic)
+	}
+	var best *asmPattern
+	bestScore := -1
+	for i := range patterns {
+		p := &patterns[i]
+		if len(p.args) != len(operands) {
+			continue
+		}
+		score := 0
+		ok := true
+		for j, arg := range p.args {
+			n, match := arg.match(operands[j], eval)
+			if !match {
+				ok = false
+				break
+			}
+			score += n
+		}
+		if ok && score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("invalid operands: %v", strings.TrimSpace(src))
+	}
+
+	out := append([]uint8{}, best.bytes...)
+	for i, arg := range best.args {
+		str := operands[i]
+		var err error
+		switch arg.kind {
+		case argImm8:
+			err = put8(out, arg.pos, str, eval, -0x80, 0xff)
+		case argPort:
+			inner, _ := inParens(str)
+			err = put8(out, arg.pos, inner, eval, 0, 0xff)
+		case argImm16:
+			err = put16(out, arg.pos, str, eval)
+		case argAddr:
+			inner, _ := inParens(str)
+			err = put16(out, arg.pos, inner, eval)
+		case argIndex:
+			_, disp, _ := indexArg(str)
+			err = put8(out, arg.pos, disp, eval, -0x80, 0xff)
+		case argRel:
+			var target int
+			target, err = eval(str)
+			if err != nil {
+				break
+			}
+			delta := target - int(addr+uint16(len(out)))
+			if delta < -0x80 || delta > 0x7f {
+				err = fmt.Errorf("jump out of range: %v", str)
+				break
+			}
+			out[arg.pos] = uint8(delta)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func put8(out []uint8, pos int, str string, eval func(string) (int, error), min int, max int) error {
+	v, err := eval(str)
+	if err != nil {
+		return err
+	}
+	if v < min || v > max {
+		return fmt.Errorf("value out of range: %v", str)
+	}
+	out[pos] = uint8(v)
+	return nil
+}
+
+func put16(out []uint8, pos int, str string, eval func(string) (int, error)) error {
+	v, err := eval(str)
+	if err != nil {
+		return err
+	}
+	if v < -0x8000 || v > 0xffff {
+		return fmt.Errorf("value out of range: %v", str)
+	}
+	out[pos] = uint8(v)
+	out[pos+1] = uint8(v >> 8)
+	return nil
+}
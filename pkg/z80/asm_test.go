@@ -0,0 +1,100 @@
+package z80
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+// Instructions that have a shorter encoding with the same form
+var asmAliases = map[string]bool{
+	"ed 63 34 12": true, // ld (nn),hl
+	"ed 6b 34 12": true, // ld hl,(nn)
+}
+
+func TestAssembleRoundTrip(t *testing.T) {
+	for _, test := range harstonTests {
+		t.Run(test.Name, func(t *testing.T) {
+			if test.Op[0] == '?' {
+				t.Skip()
+			}
+			bytes, err := Assemble(test.Op, 0x10, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mem := memory.NewRAM(0x20)
+			c := memory.NewCursor(mem)
+			dasm := NewDisassembler(mem)
+			if !asmAliases[test.Name] {
+				c.Pos = 0x10
+				c.PutN(test.Bytes...)
+				dasm.SetPC(0x10)
+				want := dasm.NextStatement().Bytes
+				With(t).Expect(fmt.Sprintf("% x", bytes)).ToBe(fmt.Sprintf("% x", want))
+			}
+			c.Pos = 0x10
+			c.PutN(bytes...)
+			dasm.SetPC(0x10)
+			With(t).Expect(dasm.NextStatement().Op).ToBe(test.Op)
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	var tests = []struct {
+		src   string
+		bytes []uint8
+	}{
+		{"LD A, B", []uint8{0x78}},
+		{"ld a,$b", []uint8{0x3e, 0x0b}},
+		{"ld a,(bc)", []uint8{0x0a}},
+		{"ld a,(1234)", []uint8{0x3a, 0x34, 0x12}},
+		{"ld a,(ix-2)", []uint8{0xdd, 0x7e, 0xfe}},
+		{"ld (iy),+10", []uint8{0xfd, 0x36, 0x00, 0x0a}},
+		{"rlc (ix+3)", []uint8{0xdd, 0xcb, 0x03, 0x06}},
+		{"rst 38", []uint8{0xff}},
+		{"im 2", []uint8{0xed, 0x5e}},
+		{"bit 7,(hl)", []uint8{0xcb, 0x7e}},
+		{"jr 1000", []uint8{0x18, 0xfe}},
+		{"djnz 0ff0", []uint8{0x10, 0xee}},
+		{"jp nz,1234", []uint8{0xc2, 0x34, 0x12}},
+		{"out (0),a", []uint8{0xd3, 0x00}},
+		{"in a,(c)", []uint8{0xed, 0x78}},
+		{"ld hl,(4000)", []uint8{0x2a, 0x00, 0x40}},
+		{"ld ixh,$12", []uint8{0xdd, 0x26, 0x12}},
+		{"sls b", []uint8{0xcb, 0x30}},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			bytes, err := Assemble(test.src, 0x1000, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			With(t).Expect(fmt.Sprintf("% x", bytes)).ToBe(fmt.Sprintf("% x", test.bytes))
+		})
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	var tests = []struct {
+		src string
+		err string
+	}{
+		{"foo a", "unknown instruction: foo"},
+		{"ld a,b,c", "invalid operands: ld a,b,c"},
+		{"ld a,100", "value out of range: 100"},
+		{"jr 2000", "jump out of range: 2000"},
+		{"ld a,xyz", "invalid value: xyz"},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			_, err := Assemble(test.src, 0x1000, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			With(t).Expect(err.Error()).ToBe(test.err)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package z80
+
+import (
+	"github.com/blackchip-org/pac8/pkg/proc"
+)
+
+// maxCalls is the number of frames kept in the shadow call stack. Code
+// that leaves a subroutine without returning, such as a reset from an
+// interrupt handler, would otherwise grow it without end.
+const maxCalls = 256
+
+// pushCall records a call after the return address has been pushed on
+// the stack.
+func (cpu *CPU) pushCall(kind string, from uint16, ret uint16) {
+	// Frames that have a stack pointer at or below this one were left
+	// without a return and their stack space is being reused.
+	n := len(cpu.calls)
+	for n > 0 && cpu.calls[n-1].SP <= cpu.SP {
+		n--
+	}
+	cpu.calls = cpu.calls[:n]
+	if n == maxCalls {
+		cpu.calls = append(cpu.calls[:0], cpu.calls[1:]...)
+	}
+	cpu.calls = append(cpu.calls, proc.Frame{
+		Kind:   kind,
+		From:   from,
+		Target: cpu.pc,
+		Return: ret,
+		SP:     cpu.SP,
+	})
+}
+
+// popCall records a return that pops the return address at sp.
+func (cpu *CPU) popCall(sp uint16) {
+	n := len(cpu.calls)
+	for n > 0 && cpu.calls[n-1].SP <= sp {
+		n--
+	}
+	cpu.calls = cpu.calls[:n]
+}
+
+// CallStack returns the calls and interrupts that have not returned, with
+// the innermost last. The stack is rebuilt from the instructions as they
+// execute and starts out empty after a restore.
+func (cpu *CPU) CallStack() []proc.Frame {
+	return append([]proc.Frame{}, cpu.calls...)
+}
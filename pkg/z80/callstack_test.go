@@ -0,0 +1,83 @@
+package z80
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/proc"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func newCallStackCPU() *CPU {
+	mem := memory.NewRAM(0x10000)
+	memory.NewCursor(mem).PutN(
+		0x31, 0x00, 0x80, // ld sp,$8000
+		0xcd, 0x10, 0x00, // call $0010
+		0x00,       // nop
+		0x18, 0xfe, // jr $0007
+	)
+	c := memory.NewCursor(mem)
+	c.Pos = 0x10
+	c.PutN(
+		0xd7, // rst $10
+		0xc9, // ret
+	)
+	c.Pos = 0x38
+	c.PutN(
+		0xfb,       // ei
+		0xed, 0x4d, // reti
+	)
+	cpu := New(mem)
+	cpu.IM = 1
+	return cpu
+}
+
+func TestCallStack(t *testing.T) {
+	cpu := newCallStackCPU()
+	cpu.Next() // ld sp
+	cpu.Next() // call
+	cpu.Next() // rst
+	want := []proc.Frame{
+		{Kind: "call", From: 0x0003, Target: 0x0010, Return: 0x0006, SP: 0x7ffe},
+		{Kind: "rst", From: 0x0010, Target: 0x0010, Return: 0x0011, SP: 0x7ffc},
+	}
+	With(t).Expect(cpu.CallStack()).ToBe(want)
+}
+
+func TestCallStackReturn(t *testing.T) {
+	cpu := newCallStackCPU()
+	cpu.Next() // ld sp
+	cpu.Next() // call
+	cpu.mem.Store(0x10, 0x00)
+	cpu.Next() // nop
+	cpu.Next() // ret
+	With(t).Expect(cpu.PC()).ToBe(uint16(0x0006))
+	With(t).Expect(len(cpu.CallStack())).ToBe(0)
+}
+
+func TestCallStackInterrupt(t *testing.T) {
+	cpu := newCallStackCPU()
+	cpu.IFF1 = true
+	cpu.Next() // ld sp
+	cpu.INT(0xff)
+	cpu.Next() // call, then the interrupt is taken
+	want := []proc.Frame{
+		{Kind: "call", From: 0x0003, Target: 0x0010, Return: 0x0006, SP: 0x7ffe},
+		{Kind: "int", From: 0x0010, Target: 0x0038, Return: 0x0010, SP: 0x7ffc},
+	}
+	With(t).Expect(cpu.CallStack()).ToBe(want)
+	cpu.Next() // ei
+	cpu.Next() // reti
+	With(t).Expect(len(cpu.CallStack())).ToBe(1)
+}
+
+func TestCallStackAbandoned(t *testing.T) {
+	cpu := newCallStackCPU()
+	cpu.Next() // ld sp
+	cpu.Next() // call
+	// Throw away the return address and call again at the same depth
+	cpu.SP = 0x8000
+	cpu.SetPC(0x0003)
+	cpu.Next()
+	With(t).Expect(len(cpu.CallStack())).ToBe(1)
+}
@@ -119,3 +119,27 @@ func TestInvalidFDCB(t *testing.T) {
 		})
 	}
 }
+
+func TestDasmSymbols(t *testing.T) {
+	mem := memory.NewRAM(0x20)
+	c := memory.NewCursor(mem)
+	c.PutN(
+		0xc3, 0x10, 0x00, // jp $0010
+		0x3a, 0x6e, 0x4e, // ld a,($4e6e)
+		0x18, 0x08, // jr $0010
+	)
+	dasm := NewDisassembler(mem)
+	dasm.CodeInfo.Labels[0x0000] = "start"
+	dasm.CodeInfo.Labels[0x0010] = "main"
+	dasm.CodeInfo.Comments[0x4e6e] = "credits"
+	dasm.CodeInfo.Labels[0x4e6e] = "ncredits"
+
+	s := dasm.NextStatement()
+	With(t).Expect(s.Label).ToBe("start")
+	With(t).Expect(s.Op).ToBe("jp   main")
+	s = dasm.NextStatement()
+	With(t).Expect(s.Op).ToBe("ld   a,(ncredits)")
+	With(t).Expect(s.Comment).ToBe("credits")
+	s = dasm.NextStatement()
+	With(t).Expect(s.Op).ToBe("jr   main")
+}
@@ -13,6 +13,7 @@ import (
 var alu bits.ALU
 
 type opsTable map[uint8]func(cpu *CPU)
+type cyclesTable map[uint8]int
 
 func add(cpu *CPU, get0 proc.Get, get1 proc.Get, withCarry bool) {
 	alu.SetCarry(false)
@@ -150,6 +151,7 @@ func blockcr(cpu *CPU, increment int) {
 		}
 		cpu.refreshR()
 		cpu.refreshR()
+		cpu.cycles += 21
 		blockc(cpu, increment)
 	}
 }
@@ -186,6 +188,7 @@ func blockinr(cpu *CPU, increment int) {
 	for cpu.B != 0 {
 		cpu.refreshR()
 		cpu.refreshR()
+		cpu.cycles += 21
 		blockin(cpu, increment)
 	}
 }
@@ -218,6 +221,7 @@ func blocklr(cpu *CPU, increment int) {
 	for cpu.B != 0 || cpu.C != 0 {
 		cpu.refreshR()
 		cpu.refreshR()
+		cpu.cycles += 21
 		blockl(cpu, increment)
 	}
 }
@@ -255,6 +259,7 @@ func blockoutr(cpu *CPU, increment int) {
 	for cpu.B != 0 {
 		cpu.refreshR()
 		cpu.refreshR()
+		cpu.cycles += 21
 		blockout(cpu, increment)
 	}
 }
@@ -266,18 +271,23 @@ func blockoutr(cpu *CPU, increment int) {
 func call(cpu *CPU, flag int, condition bool, get proc.Get16) {
 	addr := get()
 	if bits.Get(cpu.F, flag) == condition {
+		ret := cpu.PC()
 		cpu.SP -= 2
-		memory.StoreLE(cpu.mem, cpu.SP, cpu.PC())
+		memory.StoreLE(cpu.mem, cpu.SP, ret)
 		cpu.SetPC(addr)
+		cpu.pushCall("call", ret-3, ret)
+		cpu.cycles += 7
 	}
 }
 
 // call, always
 func calla(cpu *CPU, get proc.Get16) {
 	addr := get()
+	ret := cpu.PC()
 	cpu.SP -= 2
-	memory.StoreLE(cpu.mem, cpu.SP, cpu.PC())
+	memory.StoreLE(cpu.mem, cpu.SP, ret)
 	cpu.SetPC(addr)
+	cpu.pushCall("call", ret-3, ret)
 }
 
 func cb(cpu *CPU) {
@@ -285,6 +295,7 @@ func cb(cpu *CPU) {
 	// Lower 7 bits of the refresh register are incremented on an instruction
 	// fetch
 	cpu.refreshR()
+	cpu.cycles += cyclesCB[opcode]
 	opsCB[opcode](cpu)
 }
 
@@ -384,12 +395,13 @@ func daa(cpu *CPU) {
 	cpu.A = a
 }
 
-func ddfd(cpu *CPU, table opsTable, extendedTable opsTable) {
+func ddfd(cpu *CPU, table opsTable, extendedTable opsTable, cycles cyclesTable, extendedCycles cyclesTable) {
 	// Peek at the next opcode, it it doesn't have a function in the table,
 	// return now and let it execute as a normal instruction
 	opcode := cpu.mem.Load(cpu.PC())
 	fn := table[opcode]
 	if fn == nil {
+		cpu.cycles += 4
 		return
 	}
 
@@ -401,16 +413,18 @@ func ddfd(cpu *CPU, table opsTable, extendedTable opsTable) {
 	// If the opcode is 0xcb, then this is an extended ddcb or fdcb
 	// operation
 	if opcode == 0xcb {
-		ddfdcb(cpu, extendedTable)
+		ddfdcb(cpu, extendedTable, extendedCycles)
 		return
 	}
 
+	cpu.cycles += cycles[opcode]
 	fn(cpu)
 }
 
-func ddfdcb(cpu *CPU, table opsTable) {
+func ddfdcb(cpu *CPU, table opsTable, cycles cyclesTable) {
 	cpu.fetchd()
 	opcode := cpu.fetch()
+	cpu.cycles += cycles[opcode]
 	table[opcode](cpu)
 }
 
@@ -451,6 +465,7 @@ func djnz(cpu *CPU, get proc.Get) {
 	cpu.B--
 	if cpu.B != 0 {
 		cpu.SetPC(bits.Displace(cpu.PC(), delta))
+		cpu.cycles += 5
 	}
 }
 
@@ -459,6 +474,7 @@ func ed(cpu *CPU) {
 	// Lower 7 bits of the refresh register are incremented on an instruction
 	// fetch
 	cpu.refreshR()
+	cpu.cycles += cyclesED[opcode]
 	opsED[opcode](cpu)
 }
 
@@ -550,6 +566,7 @@ func jr(cpu *CPU, flag int, condition bool, get proc.Get) {
 	delta := get()
 	if bits.Get(cpu.F, flag) == condition {
 		cpu.SetPC(bits.Displace(cpu.PC(), delta))
+		cpu.cycles += 5
 	}
 }
 
@@ -649,23 +666,27 @@ func res(cpu *CPU, n int, put proc.Put, get proc.Get) {
 func ret(cpu *CPU, flag int, value bool) {
 	if bits.Get(cpu.F, flag) == value {
 		reta(cpu)
+		cpu.cycles += 6
 	}
 }
 
 // return, always
 func reta(cpu *CPU) {
 	cpu.SetPC(memory.LoadLE(cpu.mem, cpu.SP))
+	cpu.popCall(cpu.SP)
 	cpu.SP += 2
 }
 
 func reti(cpu *CPU) {
 	cpu.SetPC(memory.LoadLE(cpu.mem, cpu.SP))
+	cpu.popCall(cpu.SP)
 	cpu.SP += 2
 }
 
 func retn(cpu *CPU) {
 	cpu.IFF1 = cpu.IFF2
 	cpu.SetPC(memory.LoadLE(cpu.mem, cpu.SP))
+	cpu.popCall(cpu.SP)
 	cpu.SP += 2
 }
 
@@ -804,9 +825,11 @@ func rrd(cpu *CPU) {
 }
 
 func rst(cpu *CPU, y int) {
+	ret := cpu.PC()
 	cpu.SP -= 2
-	memory.StoreLE(cpu.mem, cpu.SP, cpu.PC())
+	memory.StoreLE(cpu.mem, cpu.SP, ret)
 	cpu.SetPC(uint16(y) * 8)
+	cpu.pushCall("rst", ret-1, ret)
 }
 
 // Set carry flag
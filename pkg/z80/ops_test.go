@@ -53,6 +53,7 @@ func TestOps(t *testing.T) {
 			WithFormat(t, "\n%v").Expect(cpu.String()).ToBe(expected.String())
 			testHalt(t, cpu, fuseExpected[test.Name])
 			testPorts(t, cpu, fuseExpected[test.Name])
+			testCycles(t, cpu, fuseExpected[test.Name])
 		})
 	}
 
@@ -69,6 +70,10 @@ func testHalt(t *testing.T, cpu *CPU, expected fuseTest) {
 	WithFormat(t, "halt(%v)").Expect(cpu.Halt).ToBe(expected.Halt != 0)
 }
 
+func testCycles(t *testing.T, cpu *CPU, expected fuseTest) {
+	WithFormat(t, "cycles(%v)").Expect(cpu.Cycles()).ToBe(expected.TStates)
+}
+
 func setupPorts(cpu *CPU, expected fuseTest) {
 	cpu.Ports = newMockIO(expected.PortReads)
 }
@@ -47,21 +47,21 @@ func op1(e proc.Eval, parts ...string) {
 			delta := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, delta)
 			addr := bits.Displace(e.Statement.Address+2, delta)
-			v = fmt.Sprintf("$%04x", addr)
+			v = e.CodeInfo.Ref(e.Statement, addr, "$%04x")
 		case part == "&0000":
 			lo := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, lo)
 			hi := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, hi)
 			addr := bits.Join(hi, lo)
-			v = fmt.Sprintf("$%04x", addr)
+			v = e.CodeInfo.Ref(e.Statement, addr, "$%04x")
 		case part == "(&0000)":
 			lo := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, lo)
 			hi := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, hi)
 			addr := bits.Join(hi, lo)
-			v = fmt.Sprintf("($%04x)", addr)
+			v = "(" + e.CodeInfo.Ref(e.Statement, addr, "$%04x") + ")"
 		case part == "&00":
 			arg := e.Cursor.Fetch()
 			e.Statement.Bytes = append(e.Statement.Bytes, arg)
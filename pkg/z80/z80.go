@@ -64,6 +64,12 @@ type CPU struct {
 
 	intRequested bool
 	intData      uint8
+
+	// number of T-states executed since the CPU was created
+	cycles int
+
+	// calls and interrupts that have not returned
+	calls []proc.Frame
 }
 
 func New(m memory.Memory) *CPU {
@@ -75,14 +81,13 @@ func New(m memory.Memory) *CPU {
 		requestNmi: make(chan bool, 1),
 	}
 	c.info = proc.Info{
-		// CPU is 3.072 MHz which is one T-State every 325 nanoseconds.
-		// Roughly round to 1 instruction every 1 microsecond.
-		// 1000 instructions per millsecond
-		CycleRate:       1000,
+		// CPU is 3.072 MHz which is 3072 T-states per millisecond.
+		CycleRate:       3072,
 		CodeReader:      ReaderZ80,
 		CodeFormatter:   FormatterZ80(),
 		NewDisassembler: NewDisassembler,
 		Registers:       c.registers(),
+		Assemble:        Assemble,
 	}
 	return c
 }
@@ -92,6 +97,7 @@ func (cpu *CPU) Next() {
 		opcode := cpu.fetch()
 		execute := ops[opcode]
 		cpu.refreshR()
+		cpu.cycles += cycles[opcode]
 		execute(cpu)
 
 		// When an EI instruction is executed, any pending interrupt request
@@ -101,6 +107,9 @@ func (cpu *CPU) Next() {
 		if opcode == 0xfb {
 			return
 		}
+	} else {
+		// A halted CPU executes NOPs until an interrupt is received
+		cpu.cycles += 4
 	}
 
 	select {
@@ -127,12 +136,22 @@ func (cpu *CPU) SetPC(pc uint16) {
 	cpu.pc = pc
 }
 
+// Cycles returns the number of T-states executed since the CPU was created.
+func (cpu *CPU) Cycles() int {
+	return cpu.cycles
+}
+
 func (cpu *CPU) INT(v uint8) {
 	cpu.requestInt <- v
 }
 
+// NMI requests a non-maskable interrupt. A request made while another is
+// still pending is lost.
 func (cpu *CPU) NMI() {
-	cpu.requestNmi <- true
+	select {
+	case cpu.requestNmi <- true:
+	default:
+	}
 }
 
 func (cpu *CPU) Ready() bool {
@@ -150,20 +169,30 @@ func (cpu *CPU) intAck(v uint8) {
 	cpu.Halt = false
 	cpu.IFF1 = false
 	cpu.IFF2 = false
+	ret := cpu.PC()
 	cpu.SP -= 2
-	memory.StoreLE(cpu.mem, cpu.SP, cpu.PC())
+	memory.StoreLE(cpu.mem, cpu.SP, ret)
 	if cpu.IM == 2 {
 		vector := bits.Join(cpu.I, v)
 		cpu.pc = memory.LoadLE(cpu.mem, vector)
+		cpu.cycles += 19
 	} else {
 		cpu.pc = 0x0038
+		cpu.cycles += 13
 	}
+	cpu.pushCall("int", ret, ret)
 }
 
 func (cpu *CPU) nmiAck() {
+	cpu.Halt = false
+	cpu.IFF2 = cpu.IFF1
+	cpu.IFF1 = false
+	cpu.cycles += 11
+	ret := cpu.PC()
 	cpu.SP -= 2
-	memory.StoreLE(cpu.mem, cpu.SP, cpu.PC())
+	memory.StoreLE(cpu.mem, cpu.SP, ret)
 	cpu.pc = 0x0066
+	cpu.pushCall("nmi", ret, ret)
 }
 
 func (cpu *CPU) String() string {
@@ -268,6 +297,7 @@ func (c *CPU) Save(enc *state.Encoder) {
 	enc.Encode(c.B)
 	enc.Encode(c.C)
 	enc.Encode(c.D)
+	enc.Encode(c.E)
 	enc.Encode(c.H)
 	enc.Encode(c.L)
 
@@ -276,6 +306,7 @@ func (c *CPU) Save(enc *state.Encoder) {
 	enc.Encode(c.B1)
 	enc.Encode(c.C1)
 	enc.Encode(c.D1)
+	enc.Encode(c.E1)
 	enc.Encode(c.H1)
 	enc.Encode(c.L1)
 
@@ -293,6 +324,24 @@ func (c *CPU) Save(enc *state.Encoder) {
 	enc.Encode(c.IM)
 	enc.Encode(c.Halt)
 
+	// Move any pending interrupt out of the channel so it can be saved.
+	// It will be acknowledged at the same time as it would have been.
+	select {
+	case v := <-c.requestInt:
+		c.intRequested = true
+		c.intData = v
+	default:
+	}
+	nmi := false
+	select {
+	case <-c.requestNmi:
+		nmi = true
+		c.requestNmi <- true
+	default:
+	}
+	enc.Encode(c.intRequested)
+	enc.Encode(c.intData)
+	enc.Encode(nmi)
 }
 
 func (c *CPU) Restore(dec *state.Decoder) {
@@ -303,6 +352,7 @@ func (c *CPU) Restore(dec *state.Decoder) {
 	dec.Decode(&c.B)
 	dec.Decode(&c.C)
 	dec.Decode(&c.D)
+	dec.Decode(&c.E)
 	dec.Decode(&c.H)
 	dec.Decode(&c.L)
 
@@ -311,6 +361,7 @@ func (c *CPU) Restore(dec *state.Decoder) {
 	dec.Decode(&c.B1)
 	dec.Decode(&c.C1)
 	dec.Decode(&c.D1)
+	dec.Decode(&c.E1)
 	dec.Decode(&c.H1)
 	dec.Decode(&c.L1)
 
@@ -327,4 +378,21 @@ func (c *CPU) Restore(dec *state.Decoder) {
 	dec.Decode(&c.IFF2)
 	dec.Decode(&c.IM)
 	dec.Decode(&c.Halt)
+
+	var nmi bool
+	dec.Decode(&c.intRequested)
+	dec.Decode(&c.intData)
+	dec.Decode(&nmi)
+	select {
+	case <-c.requestInt:
+	default:
+	}
+	select {
+	case <-c.requestNmi:
+	default:
+	}
+	if nmi {
+		c.requestNmi <- true
+	}
+	c.calls = nil
 }
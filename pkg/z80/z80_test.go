@@ -1,10 +1,13 @@
 package z80
 
 import (
+	"bytes"
 	"testing"
 
+	"github.com/blackchip-org/pac8/pkg/memory"
 	"github.com/blackchip-org/pac8/pkg/util/bits"
 	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
 )
 
 func TestSetFlags(t *testing.T) {
@@ -60,6 +63,36 @@ func TestClearFlags(t *testing.T) {
 	}
 }
 
+func TestInterruptCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		im     uint8
+		cycles int
+	}{
+		{"im 1", 1, 4 + 13},
+		{"im 2", 2, 4 + 19},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cpu := New(memory.NewRAM(0x10000))
+			cpu.IM = test.im
+			cpu.IFF1 = true
+			cpu.SP = 0x8000
+			cpu.INT(0)
+			cpu.Next()
+			With(t).Expect(cpu.Cycles()).ToBe(test.cycles)
+		})
+	}
+}
+
+func TestHaltCycles(t *testing.T) {
+	cpu := New(memory.NewRAM(0x10000))
+	cpu.Halt = true
+	cpu.Next()
+	cpu.Next()
+	With(t).Expect(cpu.Cycles()).ToBe(8)
+}
+
 // Not a real test. Useful for visually looking at the CPU status layout.
 func TestString(t *testing.T) {
 	cpu := New(nil)
@@ -94,3 +127,45 @@ func TestString(t *testing.T) {
 	//fmt.Println(cpu.String())
 	//t.Fail()
 }
+
+func TestSaveRestore(t *testing.T) {
+	cpu1 := New(memory.NewRAM(0x10000))
+	cpu1.E, cpu1.E1 = 0x12, 0x34
+	cpu1.SetPC(0x1234)
+	cpu1.INT(0xcd)
+	cpu1.NMI()
+	var buf bytes.Buffer
+	enc := state.NewEncoder(&buf)
+	cpu1.Save(enc)
+	if enc.Err != nil {
+		t.Fatal(enc.Err)
+	}
+
+	cpu2 := New(memory.NewRAM(0x10000))
+	dec := state.NewDecoder(&buf)
+	cpu2.Restore(dec)
+	if dec.Err != nil {
+		t.Fatal(dec.Err)
+	}
+	WithFormat(t, "\n%v").Expect(cpu2.String()).ToBe(cpu1.String())
+	With(t).Expect(cpu2.E).ToBe(uint8(0x12))
+	With(t).Expect(cpu2.E1).ToBe(uint8(0x34))
+	With(t).Expect(cpu2.intRequested).ToBe(true)
+	With(t).Expect(cpu2.intData).ToBe(uint8(0xcd))
+	With(t).Expect(len(cpu2.requestNmi)).ToBe(1)
+}
+
+func TestNMI(t *testing.T) {
+	cpu := New(memory.NewRAM(0x10000))
+	cpu.SetPC(0x1234)
+	cpu.SP = 0x8000
+	cpu.IFF1, cpu.IFF2 = true, true
+	cpu.Halt = true
+	cpu.NMI()
+	cpu.Next()
+	WithFormat(t, "%04x").Expect(cpu.PC()).ToBe(uint16(0x0066))
+	With(t).Expect(cpu.Halt).ToBe(false)
+	With(t).Expect(cpu.IFF1).ToBe(false)
+	With(t).Expect(cpu.IFF2).ToBe(true)
+	WithFormat(t, "%04x").Expect(memory.LoadLE(cpu.mem, cpu.SP)).ToBe(uint16(0x1234))
+}
@@ -0,0 +1,31 @@
+package galaga
+
+import (
+	"github.com/blackchip-org/pac8/pkg/audio"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/namco"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Audio mixes the three voices of the WSG with the noise of the 54xx,
+// which uses the fourth voice of the synth.
+type Audio struct {
+	*namco.WSG
+	n54xx *namco.N54XX
+}
+
+func NewAudio(spec sdl.AudioSpec, roms memory.Set, n54xx *namco.N54XX) (*Audio, error) {
+	synth, err := audio.NewSynth(spec, 4)
+	if err != nil {
+		return nil, err
+	}
+	return &Audio{
+		WSG:   namco.NewWSG(synth, roms["waveform"]),
+		n54xx: n54xx,
+	}, nil
+}
+
+func (a *Audio) Queue() error {
+	a.n54xx.Update(a.Synth.V[3])
+	return a.WSG.Queue()
+}
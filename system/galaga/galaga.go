@@ -6,6 +6,7 @@ import (
 
 	"github.com/blackchip-org/pac8/pkg/machine"
 	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/namco"
 	"github.com/blackchip-org/pac8/pkg/pac8"
 	"github.com/blackchip-org/pac8/pkg/proc"
 	"github.com/blackchip-org/pac8/pkg/util/bits"
@@ -14,8 +15,16 @@ import (
 )
 
 type Galaga struct {
-	spec *machine.Spec
-	regs Registers
+	spec  *machine.Spec
+	regs  Registers
+	n06xx *namco.N06XX
+	n51xx *namco.N51XX
+	n54xx *namco.N54XX
+	video *namco.Video
+	in0   uint8 // buttons, coins and switches, active low
+	in1   uint8 // joysticks, active low
+	cpu3  *z80.CPU
+	wait3 int // cycles until the next NMI of the third CPU
 }
 
 type Config struct {
@@ -25,78 +34,92 @@ type Config struct {
 type Registers struct {
 	InterruptEnable0 uint8 // low bit
 	InterruptEnable1 uint8 // low bit
-	InterruptEnable2 uint8 // low bit
+	NMIDisable2      uint8 // low bit
 	DipSwitches      [8]uint8
 }
 
 var codeSegments = []string{"code1", "code2", "code3"}
 
+// The third CPU gets an NMI twice a frame, at scanlines 64 and 192.
+const nmiCycles3 = 3072000 * 16670 / 1000000 / 2
+
 func New(env pac8.Env, config Config, roms memory.Set) (machine.System, error) {
-	sys := &Galaga{}
+	sys := &Galaga{in0: 0xff, in1: 0xff, wait3: nmiCycles3}
 
 	ram := memory.NewRAM(0x2000)
 	io := memory.NewIO(0x100)
-	xram := memory.NewRAM(0x1000)
-	xram2 := memory.NewRAM(0x1000)
-
-	mem := make([]memory.Memory, 4, 4)
-	cpu := make([]*z80.CPU, 4, 4)
+	stars := memory.NewIO(0x100)
+
+	// The 06xx signals the main CPU. The 51xx is the first chip on it and
+	// the 54xx is the last.
+	n06xx := namco.NewN06XX(nil)
+	sys.n06xx = n06xx
+	sys.n51xx = namco.NewN51XX(sys.readPort)
+	sys.n54xx = namco.NewN54XX()
+	n06xx.Chips[0] = sys.n51xx
+	n06xx.Chips[3] = sys.n54xx
+
+	mem := make([]memory.Memory, 3, 3)
+	cpu := make([]*z80.CPU, 3, 3)
 	for i := 0; i < 3; i++ {
 		m := memory.NewBlockMapper()
 		m.Map(0x0000, roms[codeSegments[i]])
 		m.Map(0x6800, io)
-		m.Map(0x7000, xram)
+		m.Map(0x7000, n06xx)
 		m.Map(0x8000, ram)
-		m.Map(0xa000, xram2)
-		mem[i] = memory.NewPageMapped(m.Blocks)
-		spy := memory.NewSpy(mem[i])
-		mem[i] = spy
+		m.Map(0xa000, stars)
+		mem[i] = memory.NewSpy(memory.NewPageMapped(m.Blocks))
 		cpu[i] = z80.New(mem[i])
-
-		nCore := i
-		coreCPU := cpu[i]
-		spy.Callback(func(e memory.Event) {
-			fmt.Printf("core %v at %04x: %v\n", nCore+1, coreCPU.PC(), e)
-		})
-		//spy.WatchW(0x7000)
-		//spy.WatchW(0x7100)
 	}
-	mem[3] = mem[0]
+	n06xx.NMI = cpu[0].NMI
+	sys.cpu3 = cpu[2]
 	mem[0].Store(0x9100, 0xff)
 	mem[0].Store(0x9101, 0xff)
 
-	mapRegisters(&sys.regs, io)
+	audio, err := NewAudio(env.AudioSpec, roms, sys.n54xx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize audio: %v", err)
+	}
+	mapRegisters(&sys.regs, io, audio)
 
 	video, err := NewVideo(env.Renderer, mem[0], roms)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize video: %v", err)
 	}
+	sys.video = video
+	pm := memory.NewPortMapper(stars)
+	for i := range video.Stars.Control {
+		pm.WO(i, &video.Stars.Control[i])
+	}
 
 	bits.Set(&sys.regs.DipSwitches[3], 0, true)
 	bits.Set(&sys.regs.DipSwitches[5], 0, true)
 	bits.Set(&sys.regs.DipSwitches[6], 0, true)
 
-	hackCPU := &HackCPU{cpu: cpu[0], mem: mem[0]}
 	sys.spec = &machine.Spec{
 		Name:        config.Name,
 		CharDecoder: GalagaDecoder,
-		CPU:         []proc.CPU{cpu[0], cpu[1], cpu[2], hackCPU},
-		Mem:         mem,
-		Display:     video,
+		CPU: []proc.CPU{
+			clockedCPU{CPU: cpu[0], clock: n06xx.Clock},
+			cpu[1],
+			clockedCPU{CPU: cpu[2], clock: sys.clock3},
+		},
+		Mem:     mem,
+		RAM:     []memory.Memory{ram},
+		Display: video,
+		Audio:   audio,
 		TickCallback: func(m *machine.Mach) {
 			if m.Status != machine.Run {
 				return
 			}
+			sys.handleInput(m)
+			video.Stars.Scroll()
 			if sys.regs.InterruptEnable0 != 0 {
 				cpu[0].INT(0)
-				cpu[0].NMI()
 			}
 			if sys.regs.InterruptEnable1 != 0 {
 				cpu[1].INT(0)
 			}
-			if sys.regs.InterruptEnable2 != 0 {
-				cpu[2].INT(0)
-			}
 		},
 		TickRate: time.Duration(16670 * time.Microsecond),
 	}
@@ -107,64 +130,99 @@ func (g Galaga) Spec() *machine.Spec {
 	return g.spec
 }
 
-func (g *Galaga) Save(enc *state.Encoder) {}
+// The three CPUs share the same RAM and I/O so only the memory of the
+// first CPU is saved.
+func (g *Galaga) Save(w *state.Writer) {
+	for i := 0; i < 3; i++ {
+		g.spec.CPU[i].Save(w.Section(fmt.Sprintf("cpu%v", i+1)))
+	}
+	g.spec.Mem[0].Save(w.Section("mem"))
+	w.Section("regs").Encode(g.regs)
+	w.Section("cpu3nmi").Encode(g.wait3)
+	g.n06xx.Save(w.Section("n06xx"))
+	g.n51xx.Save(w.Section("n51xx"))
+	g.n54xx.Save(w.Section("n54xx"))
+	g.video.Stars.Save(w.Section("n05xx"))
+}
 
-func (g *Galaga) Restore(dec *state.Decoder) {}
+func (g *Galaga) Restore(r *state.Reader) {
+	for i := 0; i < 3; i++ {
+		g.spec.CPU[i].Restore(r.Section(fmt.Sprintf("cpu%v", i+1)))
+	}
+	g.spec.Mem[0].Restore(r.Section("mem"))
+	r.Section("regs").Decode(&g.regs)
+	r.Section("cpu3nmi").Decode(&g.wait3)
+	g.n06xx.Restore(r.Section("n06xx"))
+	g.n51xx.Restore(r.Section("n51xx"))
+	g.n54xx.Restore(r.Section("n54xx"))
+	g.video.Stars.Restore(r.Section("n05xx"))
+}
 
-func mapRegisters(r *Registers, io memory.IO) {
+func mapRegisters(r *Registers, io memory.IO, a *Audio) {
 	pm := memory.NewPortMapper(io)
+	// The dip switches are read from the same addresses as the first
+	// registers of the WSG
 	for i := 0; i <= 7; i++ {
-		pm.RW(i, &r.DipSwitches[i])
+		pm.RO(i, &r.DipSwitches[i])
 	}
+	namco.MapWSG(pm, 0x00, a.WSG)
 	pm.RW(0x20, &r.InterruptEnable0)
 	pm.RW(0x21, &r.InterruptEnable1)
-	pm.RW(0x22, &r.InterruptEnable2)
+	pm.RW(0x22, &r.NMIDisable2)
 }
 
-type HackCPU struct {
-	cpu   proc.CPU
-	mem   memory.Memory
-	count int
-	stuff bool
+// clockedCPU is a CPU that also clocks a device with the number of cycles
+// of each instruction.
+type clockedCPU struct {
+	*z80.CPU
+	clock func(cycles int)
 }
 
-func (h HackCPU) PC() uint16 {
-	return 0
+func (c clockedCPU) Next() {
+	start := c.CPU.Cycles()
+	c.CPU.Next()
+	c.clock(c.CPU.Cycles() - start)
 }
 
-func (h HackCPU) SetPC(_ uint16) {
-}
-
-func (h *HackCPU) Next() {
-	if !h.stuff {
-		if h.cpu.PC() >= 0x37ec && h.cpu.PC() <= 0x37f2 {
-			h.stuff = true
-		}
+func (g *Galaga) clock3(cycles int) {
+	g.wait3 -= cycles
+	if g.wait3 > 0 {
+		return
 	}
-	h.count++
-	if h.stuff {
-		h.mem.Store(0x7100, 0x10)
-		v := uint8(h.count)
-		h.mem.Store(0x92a0, v)
+	g.wait3 += nmiCycles3
+	if g.regs.NMIDisable2&1 == 0 {
+		g.cpu3.NMI()
 	}
 }
 
-func (h HackCPU) Ready() bool {
-	return true
-}
-
-func (h HackCPU) Info() proc.Info {
-	return proc.Info{
-		NewDisassembler: func(memory.Memory) *proc.Disassembler {
-			return nil
-		},
+func (g *Galaga) handleInput(m *machine.Mach) {
+	bits.Set(&g.in0, 0, !m.In.Joysticks[0].Fire)
+	bits.Set(&g.in0, 1, !m.In.Joysticks[1].Fire)
+	bits.Set(&g.in0, 2, !m.In.PlayerStart[0].Active)
+	bits.Set(&g.in0, 3, !m.In.PlayerStart[1].Active)
+	bits.Set(&g.in0, 4, !m.In.CoinSlot[0].Active)
+	bits.Set(&g.in0, 5, !m.In.CoinSlot[1].Active)
+	bits.Set(&g.in0, 6, true) // service
+	bits.Set(&g.in0, 7, true) // test
+
+	for i := 0; i < 2; i++ {
+		joy := m.In.Joysticks[i]
+		bits.Set(&g.in1, 4*i+0, !joy.Up)
+		bits.Set(&g.in1, 4*i+1, !joy.Right)
+		bits.Set(&g.in1, 4*i+2, !joy.Down)
+		bits.Set(&g.in1, 4*i+3, !joy.Left)
 	}
 }
 
-func (h HackCPU) Save(*state.Encoder) {}
-
-func (h HackCPU) Restore(*state.Decoder) {}
-
-func (h HackCPU) String() string {
-	return ""
+// readPort returns the value of an input port of the 51xx.
+func (g *Galaga) readPort(n int) uint8 {
+	switch n {
+	case 0:
+		return g.in0 & 0x0f
+	case 1:
+		return g.in0 >> 4
+	case 2:
+		return g.in1 & 0x0f
+	}
+	return g.in1 >> 4
 }
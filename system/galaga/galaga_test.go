@@ -0,0 +1,94 @@
+package galaga
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/machine"
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/pac8"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+	"github.com/blackchip-org/pac8/pkg/util/state"
+)
+
+// cyclesPerFrame is the number of cycles each CPU runs in a frame.
+const cyclesPerFrame = 3072000 * 16670 / 1000000
+
+// newTestGalaga returns a system where the first two CPUs loop forever and
+// the third CPU counts its NMIs at $8800.
+func newTestGalaga(t *testing.T) (*Galaga, *machine.Mach) {
+	roms := memory.Set{
+		"code1":          memory.NewRAM(0x4000),
+		"code2":          memory.NewRAM(0x1000),
+		"code3":          memory.NewRAM(0x1000),
+		"color":          memory.NewRAM(0x20),
+		"palette":        memory.NewRAM(0x100),
+		"sprite-palette": memory.NewRAM(0x100),
+		"tile":           memory.NewRAM(0x1000),
+		"sprite":         memory.NewRAM(0x2000),
+		"waveform":       memory.NewRAM(0x100),
+	}
+	memory.NewCursor(roms["code1"]).PutN(0x18, 0xfe) // jr $
+	memory.NewCursor(roms["code2"]).PutN(0x18, 0xfe) // jr $
+	code3 := memory.NewCursor(roms["code3"])
+	code3.PutN(
+		0x31, 0x00, 0x90, // ld sp,$9000
+		0x18, 0xfe, // jr $
+	)
+	code3.Pos = 0x66
+	code3.PutN(
+		0x21, 0x00, 0x88, // ld hl,$8800
+		0x34,       // inc (hl)
+		0xed, 0x45, // retn
+	)
+	sys, err := New(pac8.Env{}, Config{Name: "galaga"}, roms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := machine.New(sys)
+	m.Status = machine.Run
+	return sys.(*Galaga), m
+}
+
+// runFrames runs each CPU for a frame's worth of cycles at a time.
+func runFrames(g *Galaga, m *machine.Mach, frames int) {
+	for i := 0; i < frames; i++ {
+		for _, cpu := range g.spec.CPU {
+			start := cpu.Cycles()
+			for cpu.Cycles()-start < cyclesPerFrame {
+				cpu.Next()
+			}
+		}
+		g.spec.TickCallback(m)
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	g, m := newTestGalaga(t)
+	runFrames(g, m, 5)
+
+	w := state.NewWriter("galaga", nil)
+	g.Save(w)
+	var buf bytes.Buffer
+	if err := w.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(g, m, 7)
+
+	g2, m2 := newTestGalaga(t)
+	r, err := state.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2.Restore(r)
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(g2, m2, 7)
+
+	With(t).Expect(g2.wait3).ToBe(g.wait3)
+	With(t).Expect(g2.spec.Mem[0].Load(0x8800)).ToBe(g.spec.Mem[0].Load(0x8800))
+	for i, cpu := range g.spec.CPU {
+		With(t).Expect(g2.spec.CPU[i].String()).ToBe(cpu.String())
+	}
+}
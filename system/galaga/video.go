@@ -8,7 +8,12 @@ import (
 )
 
 func NewVideo(r *sdl.Renderer, mem memory.Memory, rom memory.Set) (*namco.Video, error) {
-	return namco.NewVideo(r, mem, rom, VideoConfig)
+	v, err := namco.NewVideo(r, mem, rom, VideoConfig)
+	if err != nil {
+		return nil, err
+	}
+	v.Stars = namco.NewN05XX()
+	return v, nil
 }
 
 var VideoConfig = namco.Config{
@@ -30,10 +35,15 @@ var VideoConfig = namco.Config{
 		PixelReader:  pixelReader,
 		BytesPerCell: 64,
 	},
-	PaletteEntries: 32,
-	PaletteColors:  8,
 	VideoAddr:      0x8000,
-	Hack:           true,
+	Colors:         32,
+	PaletteEntries: 64,
+	PaletteColors:  4,
+	PaletteMask:    0x3f,
+	TileColors:     0x10,
+	Transparent:    0x0f,
+	SpritePalettes: true,
+	ReadSprites:    readSprites,
 }
 
 var tilePixels = [][]int{
@@ -70,6 +80,57 @@ var spritePixels = [][]int{
 	[]int{252, 248, 244, 240, 236, 232, 228, 224, 124, 120, 116, 112, 108, 104, 100, 96},
 }
 
+// Cells of a sprite that is two cells wide or high
+var spriteCells = [2][2]int{
+	{0, 1},
+	{2, 3},
+}
+
+// readSprites returns the 64 sprites found in the three banks of sprite
+// RAM. The first bank has the cell and palette, the second has the
+// position, and the third has the flip and size bits and the high bit of
+// the position. The position is for the unrotated screen.
+func readSprites(mem memory.Memory) []namco.Sprite {
+	sprites := make([]namco.Sprite, 0, 64)
+	for offs := uint16(0); offs < 0x80; offs += 2 {
+		n := int(mem.Load(0x8b80+offs) & 0x7f)
+		pal := mem.Load(0x8b81+offs) & 0x3f
+		attr := mem.Load(0x9b80 + offs)
+		flipX := attr&0x01 != 0
+		flipY := attr&0x02 != 0
+		sizeX := int(attr>>2) & 1
+		sizeY := int(attr>>3) & 1
+
+		sx := int(mem.Load(0x9381+offs)) - 40 + 0x100*int(mem.Load(0x9b81+offs)&0x03)
+		sy := 256 - int(mem.Load(0x9380+offs)) + 1
+		sy -= 16 * sizeY
+		sy = (sy & 0xff) - 32
+
+		for y := 0; y <= sizeY; y++ {
+			for x := 0; x <= sizeX; x++ {
+				cellY, cellX := y, x
+				if flipY {
+					cellY = sizeY - y
+				}
+				if flipX {
+					cellX = sizeX - x
+				}
+				// Rotate onto the screen, the unrotated x runs down and
+				// the unrotated y runs to the left
+				sprites = append(sprites, namco.Sprite{
+					N:     n + spriteCells[cellY][cellX],
+					X:     224 - 16 - (sy + 16*y),
+					Y:     sx + 16*x,
+					FlipX: flipY,
+					FlipY: flipX,
+					Pal:   pal,
+				})
+			}
+		}
+	}
+	return sprites
+}
+
 func pixelReader(mem memory.Memory, base uint16, pixel int) uint8 {
 	addr := base + uint16(pixel/4)
 	offset := pixel % 4
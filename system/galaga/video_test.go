@@ -0,0 +1,42 @@
+package galaga
+
+import (
+	"testing"
+
+	"github.com/blackchip-org/pac8/pkg/memory"
+	"github.com/blackchip-org/pac8/pkg/namco"
+	. "github.com/blackchip-org/pac8/pkg/util/expect"
+)
+
+func TestReadSprites(t *testing.T) {
+	mem := memory.NewRAM(0x10000)
+	mem.Store(0x8b80, 0x10) // cell
+	mem.Store(0x8b81, 0x05) // palette
+	mem.Store(0x9380, 0x80) // y
+	mem.Store(0x9381, 0x50) // x
+	sprites := readSprites(mem)
+	With(t).Expect(len(sprites)).ToBe(64)
+	With(t).Expect(sprites[0]).ToBe(namco.Sprite{
+		N:   0x10,
+		X:   224 - 16 - 97,
+		Y:   0x50 - 40,
+		Pal: 0x05,
+	})
+}
+
+func TestReadSpritesDoubleFlip(t *testing.T) {
+	mem := memory.NewRAM(0x10000)
+	mem.Store(0x8b80, 0x10)
+	mem.Store(0x9380, 0x80)
+	mem.Store(0x9381, 0x50)
+	mem.Store(0x9b80, 0x0d) // double width and height, flip x
+	sprites := readSprites(mem)
+	cells := []int{}
+	for _, s := range sprites[:4] {
+		cells = append(cells, s.N)
+		With(t).Expect(s.FlipY).ToBe(true)
+	}
+	With(t).Expect(cells).ToBe([]int{0x11, 0x10, 0x13, 0x12})
+	With(t).Expect(sprites[0].X).ToBe(224 - 16 - 81)
+	With(t).Expect(sprites[2].X).ToBe(224 - 16 - 97)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/blackchip-org/pac8/pkg/audio"
 	"github.com/blackchip-org/pac8/pkg/machine"
 	"github.com/blackchip-org/pac8/pkg/memory"
 	"github.com/blackchip-org/pac8/pkg/namco"
@@ -66,16 +67,18 @@ func New(env pac8.Env, config Config, roms memory.Set) (machine.System, error) {
 	m.Map(0xc000, ram)
 
 	mem := memory.NewPageMapped(m.Blocks)
-	cpu := z80.New(mem)
+	spy := memory.NewSpy(mem)
+	cpu := z80.New(spy)
 
 	video, err := NewVideo(env.Renderer, mem, roms)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize video: %v", err)
 	}
-	audio, err := NewAudio(env.AudioSpec, roms)
+	synth, err := audio.NewSynth(env.AudioSpec, 3)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize audio: %v", err)
 	}
+	audio := namco.NewWSG(synth, roms["waveform"])
 	mapRegisters(sys.regs, io, video, audio)
 
 	// Port 0 gets set with the partial interrupt pointer to be set
@@ -100,7 +103,8 @@ func New(env pac8.Env, config Config, roms memory.Set) (machine.System, error) {
 		Name:        config.Name,
 		CharDecoder: PacmanDecoder,
 		CPU:         []proc.CPU{cpu},
-		Mem:         []memory.Memory{mem},
+		Mem:         []memory.Memory{spy},
+		RAM:         []memory.Memory{ram},
 		Display:     video,
 		Audio:       audio,
 		TickCallback: func(m *machine.Mach) {
@@ -121,7 +125,7 @@ func (p Pacman) Spec() *machine.Spec {
 	return p.spec
 }
 
-func mapRegisters(r *Registers, io memory.IO, v *namco.Video, a *Audio) {
+func mapRegisters(r *Registers, io memory.IO, v *namco.Video, a *namco.WSG) {
 	pm := memory.NewPortMapper(io)
 	for i := 0; i <= 0x3f; i++ {
 		pm.RO(i, &r.In0)
@@ -138,43 +142,7 @@ func mapRegisters(r *Registers, io memory.IO, v *namco.Video, a *Audio) {
 		pm.RO(i, &r.In1)
 	}
 
-	pm.WO(0x40, &a.Voices[0].Acc[0])
-	pm.WO(0x41, &a.Voices[0].Acc[1])
-	pm.WO(0x42, &a.Voices[0].Acc[2])
-	pm.WO(0x43, &a.Voices[0].Acc[3])
-	pm.WO(0x44, &a.Voices[0].Acc[4])
-	pm.WO(0x45, &a.Voices[0].Waveform)
-
-	pm.WO(0x46, &a.Voices[1].Acc[0])
-	pm.WO(0x47, &a.Voices[1].Acc[1])
-	pm.WO(0x48, &a.Voices[1].Acc[2])
-	pm.WO(0x49, &a.Voices[1].Acc[3])
-	pm.WO(0x4a, &a.Voices[1].Waveform)
-
-	pm.WO(0x4b, &a.Voices[2].Acc[0])
-	pm.WO(0x4c, &a.Voices[2].Acc[1])
-	pm.WO(0x4d, &a.Voices[2].Acc[2])
-	pm.WO(0x4e, &a.Voices[2].Acc[3])
-	pm.WO(0x4f, &a.Voices[2].Waveform)
-
-	pm.WO(0x50, &a.Voices[0].Freq[0])
-	pm.WO(0x51, &a.Voices[0].Freq[1])
-	pm.WO(0x52, &a.Voices[0].Freq[2])
-	pm.WO(0x53, &a.Voices[0].Freq[3])
-	pm.WO(0x54, &a.Voices[0].Freq[4])
-	pm.WO(0x55, &a.Voices[0].Vol)
-
-	pm.WO(0x56, &a.Voices[1].Freq[0])
-	pm.WO(0x57, &a.Voices[1].Freq[1])
-	pm.WO(0x58, &a.Voices[1].Freq[2])
-	pm.WO(0x59, &a.Voices[1].Freq[3])
-	pm.WO(0x5a, &a.Voices[1].Vol)
-
-	pm.WO(0x5b, &a.Voices[2].Freq[0])
-	pm.WO(0x5c, &a.Voices[2].Freq[1])
-	pm.WO(0x5d, &a.Voices[2].Freq[2])
-	pm.WO(0x5e, &a.Voices[2].Freq[3])
-	pm.WO(0x5f, &a.Voices[2].Vol)
+	namco.MapWSG(pm, 0x40, a)
 
 	for i, s := 0x60, 0; s < 8; i, s = i+2, s+1 {
 		pm.WO(i+0, &v.SpriteCoords[s].X)
@@ -188,16 +156,16 @@ func mapRegisters(r *Registers, io memory.IO, v *namco.Video, a *Audio) {
 	}
 }
 
-func (p *Pacman) Save(enc *state.Encoder) {
-	p.spec.CPU[0].Save(enc)
-	p.spec.Mem[0].Save(enc)
-	enc.Encode(p.regs)
+func (p *Pacman) Save(w *state.Writer) {
+	p.spec.CPU[0].Save(w.Section("cpu"))
+	p.spec.Mem[0].Save(w.Section("mem"))
+	w.Section("regs").Encode(p.regs)
 }
 
-func (p *Pacman) Restore(dec *state.Decoder) {
-	p.spec.CPU[0].Restore(dec)
-	p.spec.Mem[0].Restore(dec)
-	dec.Decode(&p.regs)
+func (p *Pacman) Restore(r *state.Reader) {
+	p.spec.CPU[0].Restore(r.Section("cpu"))
+	p.spec.Mem[0].Restore(r.Section("mem"))
+	r.Section("regs").Decode(p.regs)
 }
 
 func (p *Pacman) handleInput(m *machine.Mach) {
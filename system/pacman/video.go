@@ -31,8 +31,10 @@ var VideoConfig = namco.Config{
 		BytesPerCell: 64,
 	},
 	VideoAddr:      0x4000,
+	Colors:         16,
 	PaletteEntries: 64,
 	PaletteColors:  4,
+	PaletteMask:    0x1f,
 }
 
 var spritePixels = [][]int{